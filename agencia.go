@@ -15,6 +15,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/robbyriverside/agencia/agents"
 	"gopkg.in/yaml.v3"
@@ -38,7 +39,16 @@ func IsVerbose() bool {
 }
 
 type AgentSpec struct {
-	Agents map[string]*agents.Agent `yaml:"agents,omitempty"`
+	Providers  map[string]*agents.ProviderConfig `yaml:"providers,omitempty"`
+	Defaults   agents.ModelParams                `yaml:"defaults,omitempty"`
+	Cache      *agents.CacheConfig               `yaml:"cache,omitempty"`
+	Prices     map[string]agents.Price           `yaml:"prices,omitempty"`
+	Roles      map[string]string                 `yaml:"roles,omitempty"`
+	ToolCalls  agents.ToolCallConfig             `yaml:"tool_calls,omitempty"`
+	Embeddings agents.EmbeddingConfig            `yaml:"embeddings,omitempty"`
+	Agents     map[string]*agents.Agent          `yaml:"agents,omitempty"`
+	Library    string                            `yaml:"library,omitempty"`   // the name other specs call this spec's agents by
+	Libraries  map[string]*LibrarySource         `yaml:"libraries,omitempty"` // the libraries this spec calls, by name
 }
 
 type AgentResult struct {
@@ -53,8 +63,16 @@ func (s *AgentSpec) String() string {
 	return string(b)
 }
 
+// LoadRegistry lints the spec file and registers its agents.
 func LoadRegistry(specfile string) (*Registry, error) {
-	spec, err := loadAgentSpecFile(specfile)
+	data, err := os.ReadFile(specfile)
+	if err != nil {
+		return nil, fmt.Errorf("[LOAD ERROR] cannot read agent file %s: %w", specfile, err)
+	}
+	if res := LintSpecPath(data, specfile); !res.Valid {
+		return nil, errors.New(res.Result())
+	}
+	spec, err := readAgentSpec(data, specfile)
 	if err != nil {
 		return nil, fmt.Errorf("[LOAD ERROR] %w", err)
 	}
@@ -89,23 +107,64 @@ func loadAgentSpecFile(filename string) (*AgentSpec, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot read agent file %s: %w", filename, err)
 	}
-	return loadAgentSpec(data)
+	return readAgentSpec(data, filename)
 }
 
 func loadAgentSpec(specbytes []byte) (*AgentSpec, error) {
-	var spec AgentSpec
-	err := yaml.Unmarshal(specbytes, &spec)
+	return readAgentSpec(specbytes, "")
+}
+
+// readAgentSpec merges the documents of a spec and the files it includes into one spec.
+func readAgentSpec(specbytes []byte, filename string) (*AgentSpec, error) {
+	root, conflicts, err := mergeSpec(specbytes, filename)
 	if err != nil {
 		return nil, fmt.Errorf("invalid YAML: %w", err)
 	}
+	if len(conflicts) > 0 {
+		return nil, errors.New(strings.Join(conflicts, "\n"))
+	}
+	var spec AgentSpec
+	if err := root.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
 	return &spec, nil
 }
 
+// RegisterAgents builds a registry of the spec's agents and loads the libraries it uses.
 func RegisterAgents(spec *AgentSpec) (*Registry, error) {
-	registry := &Registry{Agents: make(map[string]*agents.Agent)}
+	registry, err := registerAgents(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := registry.loadLibraries(spec.Libraries); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func registerAgents(spec *AgentSpec) (*Registry, error) {
+	registry := &Registry{
+		Agents:     make(map[string]*agents.Agent),
+		Profiles:   spec.Providers,
+		Defaults:   spec.Defaults,
+		Prices:     spec.Prices,
+		Roles:      spec.Roles,
+		ToolCalls:  spec.ToolCalls,
+		Embeddings: spec.Embeddings,
+	}
+	if spec.Cache != nil {
+		cache, err := agents.NewResponseCache(spec.Cache)
+		if err != nil {
+			return nil, err
+		}
+		registry.Cache = cache
+	}
 	if spec.Agents != nil {
 		for name, agent := range spec.Agents {
 			agent.Name = name
+			if err := bindFunction(agent); err != nil {
+				return nil, err
+			}
 			if agent.Inputs != nil {
 				for k, v := range agent.Inputs {
 					if v.Type == "" {
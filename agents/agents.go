@@ -2,12 +2,7 @@ package agents
 
 import (
 	"context"
-	"errors"
-	"fmt"
-	"os"
-	"strings"
-
-	"github.com/sashabaranov/go-openai"
+	"time"
 )
 
 type Argument struct {
@@ -52,6 +47,91 @@ func (f *Fact) EmptyDefault() any {
 	return nil
 }
 
+// ModelParams are the model settings for the AI calls made by an agent.
+// Unset values fall back to the spec defaults and then to the provider defaults.
+type ModelParams struct {
+	Provider    string   `yaml:"provider,omitempty" json:"provider,omitempty"`
+	Model       string   `yaml:"model,omitempty" json:"model,omitempty"`
+	Temperature *float32 `yaml:"temperature,omitempty" json:"temperature,omitempty"`
+	MaxTokens   int      `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"`
+	TopP        *float32 `yaml:"top_p,omitempty" json:"top_p,omitempty"`
+	Seed        *int     `yaml:"seed,omitempty" json:"seed,omitempty"`
+	Stop        []string `yaml:"stop,omitempty" json:"stop,omitempty"`
+}
+
+// WithDefaults returns the params with every unset value taken from defaults.
+func (p ModelParams) WithDefaults(defaults ModelParams) ModelParams {
+	if p.Provider == "" {
+		p.Provider = defaults.Provider
+	}
+	if p.Model == "" {
+		p.Model = defaults.Model
+	}
+	if p.Temperature == nil {
+		p.Temperature = defaults.Temperature
+	}
+	if p.MaxTokens == 0 {
+		p.MaxTokens = defaults.MaxTokens
+	}
+	if p.TopP == nil {
+		p.TopP = defaults.TopP
+	}
+	if p.Seed == nil {
+		p.Seed = defaults.Seed
+	}
+	if p.Stop == nil {
+		p.Stop = defaults.Stop
+	}
+	return p
+}
+
+// Apply copies the params onto the request, using DefaultModel and
+// DefaultTemperature when they are unset.
+func (p ModelParams) Apply(req *ChatRequest) {
+	req.Provider = p.Provider
+	req.Model = p.Model
+	if req.Model == "" {
+		req.Model = DefaultModel
+	}
+	temperature := float32(DefaultTemperature)
+	if p.Temperature != nil {
+		temperature = *p.Temperature
+	}
+	req.Temperature = &temperature
+	req.MaxTokens = p.MaxTokens
+	req.TopP = p.TopP
+	req.Seed = p.Seed
+	req.Stop = p.Stop
+}
+
+// Tool call defaults.
+const (
+	DefaultToolCallDepth      = 5 // rounds of tool calls before a prompt gives up
+	DefaultToolCallConcurrent = 4 // tool calls of one round that run at once
+)
+
+// ToolCallConfig limits how a prompt agent runs the tool calls the model asks for.
+// Unset values fall back to the spec's tool_calls section and then to the defaults.
+//
+//	tool_calls:
+//	  max_depth: 8
+//	  max_concurrent: 2
+type ToolCallConfig struct {
+	MaxDepth      int `yaml:"max_depth,omitempty" json:"max_depth,omitempty"`           // rounds of tool calls in one prompt
+	MaxConcurrent int `yaml:"max_concurrent,omitempty" json:"max_concurrent,omitempty"` // tool calls of one round that run at once
+}
+
+// WithDefaults returns the config with every unset value taken from defaults.
+func (c ToolCallConfig) WithDefaults(defaults ToolCallConfig) ToolCallConfig {
+	if c.MaxDepth == 0 {
+		c.MaxDepth = defaults.MaxDepth
+	}
+	if c.MaxConcurrent == 0 {
+		c.MaxConcurrent = defaults.MaxConcurrent
+	}
+	return c
+}
+
 type Agent struct {
 	Name        string
 	Description string
@@ -59,11 +139,24 @@ type Agent struct {
 	Prompt      string
 	Template    string
 	Alias       string
-	Function    AgentFn
+	Function    AgentFn `yaml:"-"`
+	FuncName    string  `yaml:"function,omitempty"` // the registered function that Function is bound to
 	Listeners   []string
 	Facts       map[string]*Fact
-	Job         []string
+	Job         []string // agents run in order in the background; see Registry.Jobs
 	Role        string
+	Cache       *CachePolicy   // caches the agent's AI responses when set
+	History     *HistoryPolicy // sends earlier chat turns with the prompt when set
+	ToolCalls   ToolCallConfig `yaml:"tool_calls,omitempty"`
+	Fallback    []ModelParams  // models tried in order when the agent's model fails
+	Timeout     time.Duration  // how long each model may take before the next fallback is tried; zero waits
+
+	MaxInputTokens int             `yaml:"max_input_tokens,omitempty"` // prompt tokens the agent may send; zero is no limit
+	Overflow       *OverflowPolicy // how a longer prompt is cut down; truncate_tail when unset
+	Samples        int             `yaml:"samples,omitempty"` // times the prompt is run to vote on its answer
+	Vote           *VotePolicy     // how the answer is chosen from the samples; majority when unset
+
+	ModelParams `yaml:",inline"`
 }
 
 // IsValid if the agent has only one of the following:
@@ -71,6 +164,7 @@ type Agent struct {
 // - Template
 // - Prompt
 // - Alias
+// A job agent may have none of them, and then starts its job with a standard message.
 // This is used to determine if the agent is valid for use in the registry.
 func (r *Agent) IsValid() bool {
 	var score int
@@ -86,43 +180,11 @@ func (r *Agent) IsValid() bool {
 	if r.Alias != "" {
 		score++
 	}
-	return score == 1
-}
-
-var openaiClient *openai.Client
-
-// var openaiInitError error
-// var openaiInitialized bool
-
-func GetOpenAIClient() (*openai.Client, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	org := os.Getenv("OPENAI_ORG")
-	if apiKey == "" {
-		return nil, errors.New("OPENAI_API_KEY must be set")
-	}
-	config := openai.DefaultConfig(apiKey)
-	config.OrgID = org
-	openaiClient = openai.NewClientWithConfig(config)
-	return openaiClient, nil
+	return score == 1 || score == 0 && len(r.Job) > 0
 }
 
-// CallOpenAI calls the OpenAI API with the given prompt and returns the response.
-// Used by library agents to call OpenAI.
+// CallOpenAI calls AI with the given prompt and returns the response.
+// Kept for library agents written before providers existed; use CallAI.
 func CallOpenAI(ctx context.Context, prompt string) (string, error) {
-	client, err := GetOpenAIClient()
-	if err != nil {
-		return "[MOCK ERROR: attempted real OpenAI call in test/mock mode]", err
-	}
-	req := openai.ChatCompletionRequest{
-		Model:       openai.GPT4o,
-		Temperature: 0.2,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: prompt},
-		},
-	}
-	resp, err := client.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("OpenAI API error: %w", err)
-	}
-	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+	return CallAI(ctx, nil, prompt)
 }
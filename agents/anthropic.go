@@ -0,0 +1,401 @@
+package agents
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultAnthropicModel     = "claude-sonnet-4-5"
+	DefaultAnthropicBaseURL   = "https://api.anthropic.com/v1"
+	DefaultAnthropicVersion   = "2023-06-01"
+	DefaultAnthropicMaxTokens = 4096
+	DefaultAnthropicAPIKeyEnv = "ANTHROPIC_API_KEY"
+)
+
+// Messages API content block types.
+const (
+	anthropicTextBlock       = "text"
+	anthropicToolUseBlock    = "tool_use"
+	anthropicToolResultBlock = "tool_result"
+	anthropicImageBlock      = "image"
+	anthropicDocumentBlock   = "document"
+)
+
+// anthropicRefusal is the stop reason of a response Anthropic declined to give.
+const anthropicRefusal = "refusal"
+
+// AnthropicProvider calls the Anthropic Messages API.
+// Listener tools are sent as Anthropic tools, and tool_use and tool_result
+// content blocks are translated to and from ToolCalls and tool messages.
+// A request's ResponseFormat is not sent; callers validate the answer themselves.
+type AnthropicProvider struct {
+	APIKey     string
+	BaseURL    string
+	Version    string
+	Headers    map[string]string
+	HTTPClient *http.Client
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+}
+
+// anthropicSource is the content of an image or document block.
+type anthropicSource struct {
+	Type      string `json:"type"` // base64 or url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	Type       string           `json:"type"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      *anthropicUsage  `json:"usage,omitempty"`
+	Error      *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (p *AnthropicProvider) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	httpResp, err := p.post(ctx, toAnthropicRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	var resp anthropicResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error decoding Anthropic response: %w", err)
+	}
+	return fromAnthropicResponse(&resp)
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicEvent is one server-sent event of a streamed response.
+type anthropicEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *anthropicResponse `json:"message,omitempty"`
+	ContentBlock *anthropicBlock    `json:"content_block,omitempty"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text,omitempty"`
+		PartialJSON string `json:"partial_json,omitempty"`
+		StopReason  string `json:"stop_reason,omitempty"`
+	} `json:"delta,omitempty"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (p *AnthropicProvider) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*ChatResponse, error) {
+	areq := toAnthropicRequest(req)
+	areq.Stream = true
+	httpResp, err := p.post(ctx, areq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	resp := &anthropicResponse{}
+	var inputs []strings.Builder
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return nil, fmt.Errorf("error decoding Anthropic stream: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				resp.Model = event.Message.Model
+				resp.Usage = event.Message.Usage
+			}
+		case "message_delta":
+			// The stop reason and final output token count arrive after the content.
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				resp.StopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				if resp.Usage == nil {
+					resp.Usage = &anthropicUsage{}
+				}
+				resp.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "content_block_start":
+			for len(resp.Content) <= event.Index {
+				resp.Content = append(resp.Content, anthropicBlock{})
+				inputs = append(inputs, strings.Builder{})
+			}
+			if event.ContentBlock != nil {
+				resp.Content[event.Index] = *event.ContentBlock
+			}
+		case "content_block_delta":
+			if event.Delta == nil || event.Index >= len(resp.Content) {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				resp.Content[event.Index].Text += event.Delta.Text
+				onDelta(event.Delta.Text)
+			case "input_json_delta":
+				inputs[event.Index].WriteString(event.Delta.PartialJSON)
+			}
+		case "error":
+			if event.Error != nil {
+				return nil, fmt.Errorf("Anthropic API error: %s: %s", event.Error.Type, event.Error.Message)
+			}
+			return nil, fmt.Errorf("Anthropic API error: stream failed")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Anthropic API error: %w", err)
+	}
+	for i := range resp.Content {
+		if inputs[i].Len() > 0 {
+			resp.Content[i].Input = json.RawMessage(inputs[i].String())
+		}
+	}
+	return fromAnthropicResponse(resp)
+}
+
+// post sends the request to the Messages API.
+// Error responses are returned as errors, so the caller only sees a successful body.
+func (p *AnthropicProvider) post(ctx context.Context, areq *anthropicRequest) (*http.Response, error) {
+	body, err := json.Marshal(areq)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding Anthropic request: %w", err)
+	}
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultAnthropicBaseURL
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	version := p.Version
+	if version == "" {
+		version = DefaultAnthropicVersion
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.APIKey)
+	httpReq.Header.Set("anthropic-version", version)
+	for k, v := range p.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, &ProviderError{Err: fmt.Errorf("Anthropic API error: %w", err)}
+	}
+	if httpResp.StatusCode == http.StatusOK {
+		return httpResp, nil
+	}
+	defer httpResp.Body.Close()
+	data, _ := io.ReadAll(httpResp.Body)
+	perr := &ProviderError{StatusCode: httpResp.StatusCode, RetryAfter: retryAfter(httpResp.Header)}
+	var resp anthropicResponse
+	if err := json.Unmarshal(data, &resp); err != nil || resp.Error == nil {
+		perr.Err = fmt.Errorf("Anthropic API error: status %d: %s", httpResp.StatusCode, strings.TrimSpace(string(data)))
+	} else {
+		perr.Err = fmt.Errorf("Anthropic API error: status %d: %s: %s", httpResp.StatusCode, resp.Error.Type, resp.Error.Message)
+	}
+	return nil, perr
+}
+
+// Embed is not offered by the Messages API.
+func (p *AnthropicProvider) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	return nil, fmt.Errorf("anthropic provider does not support embeddings")
+}
+
+func toAnthropicRequest(req *ChatRequest) *anthropicRequest {
+	model := req.Model
+	if model == "" {
+		model = DefaultAnthropicModel
+	}
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = DefaultAnthropicMaxTokens
+	}
+	areq := &anthropicRequest{
+		Model:         model,
+		MaxTokens:     maxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+	}
+
+	var system []string
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleSystem:
+			system = append(system, m.Content)
+		case RoleUser:
+			areq.addBlocks(RoleUser, anthropicBlock{Type: anthropicTextBlock, Text: m.Content})
+			for _, a := range m.Attachments {
+				areq.addBlocks(RoleUser, anthropicAttachment(a))
+			}
+		case RoleAssistant:
+			var blocks []anthropicBlock
+			if m.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: anthropicTextBlock, Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				blocks = append(blocks, anthropicBlock{
+					Type:  anthropicToolUseBlock,
+					ID:    call.ID,
+					Name:  call.Name,
+					Input: toolInput(call.Arguments),
+				})
+			}
+			areq.addBlocks(RoleAssistant, blocks...)
+		case RoleTool:
+			// Tool results go back to Anthropic as user content.
+			areq.addBlocks(RoleUser, anthropicBlock{
+				Type:      anthropicToolResultBlock,
+				ToolUseID: m.ToolCallID,
+				Content:   m.Content,
+			})
+		}
+	}
+	areq.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		areq.Tools = append(areq.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+	return areq
+}
+
+// addBlocks appends the blocks to the last message when it has the same role,
+// because the Messages API requires user and assistant turns to alternate.
+func (r *anthropicRequest) addBlocks(role string, blocks ...anthropicBlock) {
+	if n := len(r.Messages); n > 0 && r.Messages[n-1].Role == role {
+		r.Messages[n-1].Content = append(r.Messages[n-1].Content, blocks...)
+		return
+	}
+	r.Messages = append(r.Messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+// anthropicAttachment sends images and PDFs as image and document blocks, and other files as text.
+func anthropicAttachment(a Attachment) anthropicBlock {
+	blockType := anthropicDocumentBlock
+	switch {
+	case a.IsImage():
+		blockType = anthropicImageBlock
+	case a.MediaType != "application/pdf":
+		return anthropicBlock{Type: anthropicTextBlock, Text: attachmentText(a)}
+	}
+	if a.URL != "" {
+		return anthropicBlock{Type: blockType, Source: &anthropicSource{Type: "url", URL: a.URL}}
+	}
+	return anthropicBlock{Type: blockType, Source: &anthropicSource{
+		Type:      "base64",
+		MediaType: a.MediaType,
+		Data:      base64.StdEncoding.EncodeToString(a.Data),
+	}}
+}
+
+// toolInput converts tool call arguments to the JSON object Anthropic expects.
+func toolInput(arguments string) json.RawMessage {
+	if strings.TrimSpace(arguments) == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+func fromAnthropicResponse(resp *anthropicResponse) (*ChatResponse, error) {
+	if len(resp.Content) == 0 {
+		return nil, ErrNoChoices
+	}
+	result := &ChatResponse{Model: resp.Model, FinishReason: resp.StopReason}
+	if resp.StopReason == anthropicRefusal {
+		result.FinishReason = FinishReasonContentFilter
+	}
+	if resp.Usage != nil {
+		result.Usage = Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		}
+	}
+	var text []string
+	for _, block := range resp.Content {
+		switch block.Type {
+		case anthropicTextBlock:
+			text = append(text, block.Text)
+		case anthropicToolUseBlock:
+			args := string(block.Input)
+			if args == "" {
+				args = "{}"
+			}
+			result.ToolCalls = append(result.ToolCalls, ToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: args,
+			})
+		}
+	}
+	result.Content = strings.Join(text, "")
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Attachment is an image or file sent with a run or a chat message.
+// Its content is either Data or a URL the provider can fetch.
+// In JSON, Data is base64.
+type Attachment struct {
+	Name      string `json:"name,omitempty" yaml:"name,omitempty"`
+	MediaType string `json:"media_type" yaml:"media_type"` // such as image/png, text/plain or application/pdf
+	URL       string `json:"url,omitempty" yaml:"url,omitempty"`
+	Data      []byte `json:"data,omitempty" yaml:"-"`
+	Digest    string `json:"digest,omitempty" yaml:"digest,omitempty"` // sha256 of Data, set by Reference
+	Size      int    `json:"size,omitempty" yaml:"size,omitempty"`     // bytes of Data, set by Reference
+}
+
+// IsImage reports whether the attachment is an image.
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.MediaType, "image/")
+}
+
+// IsText reports whether the attachment is a text file that can be sent as part of the prompt.
+func (a Attachment) IsText() bool {
+	return strings.HasPrefix(a.MediaType, "text/") && a.Data != nil
+}
+
+// DataURL returns the attachment's URL, or its data as a data URL.
+func (a Attachment) DataURL() string {
+	if a.URL != "" {
+		return a.URL
+	}
+	return "data:" + a.MediaType + ";base64," + base64.StdEncoding.EncodeToString(a.Data)
+}
+
+// Reference returns the attachment without its data, identified by its URL or the digest of its data.
+// Trace cards and transcripts keep references so they stay small.
+func (a Attachment) Reference() Attachment {
+	if a.Data != nil {
+		sum := sha256.Sum256(a.Data)
+		a.Digest = "sha256:" + hex.EncodeToString(sum[:])
+		a.Size = len(a.Data)
+		a.Data = nil
+	}
+	return a
+}
+
+// String names the attachment for traces and prompts.
+func (a Attachment) String() string {
+	name := a.Name
+	if name == "" {
+		name = a.URL
+	}
+	if name == "" {
+		name = a.Digest
+	}
+	return fmt.Sprintf("%s (%s)", name, a.MediaType)
+}
+
+// Validate checks that the attachment has a media type and content.
+func (a Attachment) Validate() error {
+	if a.MediaType == "" {
+		return fmt.Errorf("attachment %s has no media type", a.Name)
+	}
+	if a.URL == "" && a.Data == nil {
+		return fmt.Errorf("attachment %s has no url or data", a)
+	}
+	return nil
+}
+
+// attachmentText is how providers send an attachment they cannot send as an image or document:
+// a text file with its content, anything else by name only.
+func attachmentText(a Attachment) string {
+	if a.IsText() {
+		return fmt.Sprintf("Attached file %s:\n%s", a, a.Data)
+	}
+	return fmt.Sprintf("Attached file %s, which cannot be shown.", a)
+}
+
+// VisionModels are the models that accept images, found by model name like prices.
+var VisionModels = map[string]bool{
+	"gpt-4o":       true,
+	"gpt-4o-mini":  true,
+	"gpt-4.1":      true,
+	"gpt-4.1-mini": true,
+	"gpt-4.1-nano": true,
+	"o3-mini":      false,
+	"claude":       true,
+}
+
+// SupportsVision reports whether the model accepts images.
+func SupportsVision(model string) bool {
+	vision, _ := lookupModel(model, VisionModels)
+	return vision
+}
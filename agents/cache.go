@@ -0,0 +1,223 @@
+package agents
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Cache backends.
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendDisk   = "disk"
+)
+
+// DefaultCacheDir is where the disk backend keeps responses when no dir is configured.
+const DefaultCacheDir = ".agencia/cache"
+
+// CachePolicy turns on response caching for an agent.
+// In yaml it is true, for responses that never expire, or a time to live such as 10m.
+//
+//	agents:
+//	  classify:
+//	    cache: 24h
+type CachePolicy struct {
+	TTL time.Duration // zero never expires
+}
+
+func (p *CachePolicy) UnmarshalYAML(node *yaml.Node) error {
+	policy, err := ParseCachePolicy(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	if policy != nil {
+		*p = *policy
+	}
+	return nil
+}
+
+func (p *CachePolicy) MarshalYAML() (any, error) {
+	if p.TTL == 0 {
+		return true, nil
+	}
+	return p.TTL.String(), nil
+}
+
+// ParseCachePolicy reads a cache setting: a boolean or a duration.
+// false returns a nil policy.
+func ParseCachePolicy(value string) (*CachePolicy, error) {
+	if on, err := strconv.ParseBool(value); err == nil {
+		if !on {
+			return nil, nil
+		}
+		return &CachePolicy{}, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("cache must be true, false or a duration like 10m, got %q", value)
+	}
+	return &CachePolicy{TTL: ttl}, nil
+}
+
+// CacheConfig selects the backend that holds cached responses.
+//
+//	cache:
+//	  backend: disk
+//	  dir: .agencia/cache
+type CacheConfig struct {
+	Backend string `yaml:"backend,omitempty" json:"backend,omitempty"` // memory (default) or disk
+	Dir     string `yaml:"dir,omitempty" json:"dir,omitempty"`         // disk backend directory
+}
+
+// ResponseCache stores chat responses by CacheKey.
+type ResponseCache interface {
+	Get(key string) (*ChatResponse, bool)
+	Set(key string, resp *ChatResponse, ttl time.Duration) error
+}
+
+// NewResponseCache builds the configured backend. A nil config is an in-memory cache.
+func NewResponseCache(cfg *CacheConfig) (ResponseCache, error) {
+	if cfg == nil {
+		return NewMemoryCache(), nil
+	}
+	switch cfg.Backend {
+	case "", CacheBackendMemory:
+		return NewMemoryCache(), nil
+	case CacheBackendDisk:
+		dir := cfg.Dir
+		if dir == "" {
+			dir = DefaultCacheDir
+		}
+		return NewDiskCache(dir), nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q: use memory or disk", cfg.Backend)
+}
+
+// CacheKey hashes everything that decides the model's answer:
+// the provider, model, parameters, messages and tools.
+// The agent, purpose and input only describe the request and are left out,
+// so identical prompts share one entry.
+func CacheKey(req *ChatRequest) string {
+	keyed := *req
+	keyed.Agent = ""
+	keyed.Purpose = ""
+	keyed.Input = ""
+	data, _ := json.Marshal(&keyed)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheEntry is a stored response and when it expires.
+type cacheEntry struct {
+	Response *ChatResponse `json:"response"`
+	Expires  time.Time     `json:"expires,omitzero"` // zero never expires
+}
+
+func newCacheEntry(resp *ChatResponse, ttl time.Duration) *cacheEntry {
+	entry := &cacheEntry{Response: resp}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	return entry
+}
+
+func (e *cacheEntry) expired() bool {
+	return !e.Expires.IsZero() && time.Now().After(e.Expires)
+}
+
+// response returns a copy so callers cannot change the cached response.
+func (e *cacheEntry) response() *ChatResponse {
+	resp := *e.Response
+	return &resp
+}
+
+// MemoryCache keeps responses for the life of the process.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]*cacheEntry)}
+}
+
+func (c *MemoryCache) Get(key string) (*ChatResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if entry.expired() {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.response(), true
+}
+
+func (c *MemoryCache) Set(key string, resp *ChatResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = newCacheEntry(resp, ttl)
+	return nil
+}
+
+// DiskCache keeps each response in a json file named by its key, so it survives restarts.
+type DiskCache struct {
+	Dir string
+}
+
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{Dir: dir}
+}
+
+func (c *DiskCache) file(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+func (c *DiskCache) Get(key string) (*ChatResponse, bool) {
+	data, err := os.ReadFile(c.file(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Response == nil {
+		return nil, false
+	}
+	if entry.expired() {
+		_ = os.Remove(c.file(key))
+		return nil, false
+	}
+	return entry.response(), true
+}
+
+func (c *DiskCache) Set(key string, resp *ChatResponse, ttl time.Duration) error {
+	data, err := json.Marshal(newCacheEntry(resp, ttl))
+	if err != nil {
+		return fmt.Errorf("cannot encode cached response: %w", err)
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create cache dir: %w", err)
+	}
+	// Write then rename so a concurrent Get never reads half a file.
+	tmp, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot write cached response: %w", err)
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	if err := errors.Join(werr, cerr); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot write cached response: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.file(key))
+}
@@ -0,0 +1,195 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Interaction is one recorded provider call.
+// Chat calls set Request and Response; embedding calls set EmbedRequest and EmbedResponse.
+// A failed call records the error text instead of a response.
+type Interaction struct {
+	Request       *ChatRequest   `yaml:"request,omitempty"`
+	Response      *ChatResponse  `yaml:"response,omitempty"`
+	EmbedRequest  *EmbedRequest  `yaml:"embed_request,omitempty"`
+	EmbedResponse *EmbedResponse `yaml:"embed_response,omitempty"`
+	Error         string         `yaml:"error,omitempty"`
+
+	used bool
+}
+
+// Cassette records provider calls to a file and replays them.
+//
+// A recording cassette wraps live providers with Wrap and writes every call on Save.
+// A replaying cassette is itself a Provider: each request is answered by the first
+// unused interaction with an identical request, so the order of the calls does not matter.
+// A request that was not recorded is an error naming the request.
+type Cassette struct {
+	File         string         `yaml:"-"`
+	Interactions []*Interaction `yaml:"interactions"`
+
+	recording bool
+	mu        sync.Mutex
+}
+
+// NewCassette starts an empty cassette that records to file.
+func NewCassette(file string) *Cassette {
+	return &Cassette{File: file, recording: true}
+}
+
+// LoadCassette reads a recorded cassette for replay.
+func LoadCassette(file string) (*Cassette, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read cassette: %w", err)
+	}
+	c := &Cassette{File: file}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("cannot parse cassette %s: %w", file, err)
+	}
+	return c, nil
+}
+
+// Save writes the recorded interactions to the cassette file.
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("cannot encode cassette: %w", err)
+	}
+	return os.WriteFile(c.File, data, 0o644)
+}
+
+// Recording reports whether the cassette records rather than replays.
+func (c *Cassette) Recording() bool {
+	return c.recording
+}
+
+// Unused returns the recorded interactions that replay has not served.
+func (c *Cassette) Unused() []*Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var unused []*Interaction
+	for _, in := range c.Interactions {
+		if !in.used {
+			unused = append(unused, in)
+		}
+	}
+	return unused
+}
+
+// Wrap returns a provider that passes calls through to p and records them on the cassette.
+func (c *Cassette) Wrap(p Provider) Provider {
+	return &cassetteRecorder{cassette: c, provider: p}
+}
+
+func (c *Cassette) add(in *Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, in)
+}
+
+func (c *Cassette) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	in, err := c.match(func(in *Interaction) bool {
+		return in.Request != nil && reflect.DeepEqual(normalize(in.Request), normalize(req))
+	}, "chat", req)
+	if err != nil {
+		return nil, err
+	}
+	if in.Error != "" {
+		return nil, errors.New(in.Error)
+	}
+	if in.Response == nil {
+		return nil, fmt.Errorf("cassette %s: chat interaction for agent %s has neither a response nor an error", c.File, in.Request.Agent)
+	}
+	resp := *in.Response
+	return &resp, nil
+}
+
+func (c *Cassette) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	in, err := c.match(func(in *Interaction) bool {
+		return in.EmbedRequest != nil && reflect.DeepEqual(normalize(in.EmbedRequest), normalize(req))
+	}, "embed", req)
+	if err != nil {
+		return nil, err
+	}
+	if in.Error != "" {
+		return nil, errors.New(in.Error)
+	}
+	if in.EmbedResponse == nil {
+		return nil, fmt.Errorf("cassette %s: embed interaction for model %s has neither a response nor an error", c.File, in.EmbedRequest.Model)
+	}
+	resp := *in.EmbedResponse
+	return &resp, nil
+}
+
+// match marks and returns the first unused interaction accepted by matches.
+func (c *Cassette) match(matches func(*Interaction) bool, kind string, req any) (*Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, in := range c.Interactions {
+		if !in.used && matches(in) {
+			in.used = true
+			return in, nil
+		}
+	}
+	data, _ := yaml.Marshal(req)
+	return nil, fmt.Errorf("cassette %s: no recorded %s interaction matches request:\n%s", c.File, kind, data)
+}
+
+// normalize round trips a request through yaml so recorded and live requests compare alike,
+// for example []string and []any inside tool parameters.
+func normalize(req any) any {
+	data, err := yaml.Marshal(req)
+	if err != nil {
+		return req
+	}
+	var out any
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return req
+	}
+	return out
+}
+
+// cassetteRecorder records every call made through a live provider.
+type cassetteRecorder struct {
+	cassette *Cassette
+	provider Provider
+}
+
+func (r *cassetteRecorder) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	resp, err := r.provider.Chat(ctx, req)
+	r.record(req, resp, err)
+	return resp, err
+}
+
+func (r *cassetteRecorder) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*ChatResponse, error) {
+	resp, err := ChatStream(ctx, r.provider, req, onDelta)
+	r.record(req, resp, err)
+	return resp, err
+}
+
+func (r *cassetteRecorder) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	resp, err := r.provider.Embed(ctx, req)
+	in := &Interaction{EmbedRequest: req, EmbedResponse: resp}
+	if err != nil {
+		in.Error = err.Error()
+	}
+	r.cassette.add(in)
+	return resp, err
+}
+
+func (r *cassetteRecorder) record(req *ChatRequest, resp *ChatResponse, err error) {
+	in := &Interaction{Request: req, Response: resp}
+	if err != nil {
+		in.Error = err.Error()
+	}
+	r.cassette.add(in)
+}
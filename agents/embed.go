@@ -0,0 +1,162 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// DefaultEmbeddingBatchSize is how many texts an Embedder sends in one request when no batch size is configured.
+const DefaultEmbeddingBatchSize = 100
+
+// EmbeddingConfig chooses how text is embedded, once for the whole spec.
+// Unset values use the provider's default embedding model and its full dimensions.
+//
+//	embeddings:
+//	  provider: openai
+//	  model: text-embedding-3-small
+//	  dimensions: 512
+//	  batch_size: 64
+type EmbeddingConfig struct {
+	Provider   string `yaml:"provider,omitempty" json:"provider,omitempty"`
+	Model      string `yaml:"model,omitempty" json:"model,omitempty"`
+	Dimensions int    `yaml:"dimensions,omitempty" json:"dimensions,omitempty"` // shortens the vectors of models that allow it
+	BatchSize  int    `yaml:"batch_size,omitempty" json:"batch_size,omitempty"` // texts per request
+}
+
+// EmbeddingDimensions are the vector sizes of common embedding models.
+var EmbeddingDimensions = map[string]int{
+	"text-embedding-ada-002": 1536,
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+}
+
+// Embedder turns texts into vectors.
+// Search, memory and semantic caching all embed through an Embedder so they agree on the model.
+type Embedder interface {
+	// Embed returns one vector per text, in order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Dimensions is the length of the vectors, or zero when it is not known before embedding.
+	Dimensions() int
+}
+
+// ProviderEmbedder embeds with a provider, in batches.
+type ProviderEmbedder struct {
+	Provider Provider
+	Config   EmbeddingConfig
+}
+
+// NewEmbedder embeds with the provider as configured.
+func NewEmbedder(p Provider, config EmbeddingConfig) *ProviderEmbedder {
+	return &ProviderEmbedder{Provider: p, Config: config}
+}
+
+func (e *ProviderEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	size := e.Config.BatchSize
+	if size <= 0 {
+		size = DefaultEmbeddingBatchSize
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += size {
+		batch := texts[start:min(start+size, len(texts))]
+		resp, err := e.Provider.Embed(ctx, &EmbedRequest{
+			Model:      e.Config.Model,
+			Dimensions: e.Config.Dimensions,
+			Input:      batch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Vectors) != len(batch) {
+			return nil, fmt.Errorf("embedding returned %d vectors for %d texts", len(resp.Vectors), len(batch))
+		}
+		vectors = append(vectors, resp.Vectors...)
+	}
+	return vectors, nil
+}
+
+func (e *ProviderEmbedder) Dimensions() int {
+	if e.Config.Dimensions > 0 {
+		return e.Config.Dimensions
+	}
+	model := e.Config.Model
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+	return EmbeddingDimensions[model]
+}
+
+// FakeEmbedder is a deterministic Embedder for tests.
+// Each word of a text adds to one dimension chosen by its hash, and the vector is normalized,
+// so texts that share words are close and the same text always has the same vector.
+type FakeEmbedder struct {
+	Dims  int // vector length; 64 when zero
+	Calls int // times Embed was called
+}
+
+func (e *FakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.Calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec := make([]float32, e.Dimensions())
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vec[h.Sum32()%uint32(len(vec))]++
+		}
+		var norm float64
+		for _, v := range vec {
+			norm += float64(v * v)
+		}
+		if norm > 0 {
+			for j := range vec {
+				vec[j] /= float32(math.Sqrt(norm))
+			}
+		}
+		vectors[i] = vec
+	}
+	return vectors, nil
+}
+
+func (e *FakeEmbedder) Dimensions() int {
+	if e.Dims <= 0 {
+		return 64
+	}
+	return e.Dims
+}
+
+type embedderKey struct{}
+
+// WithEmbedder stores the embedder in the context for function agents.
+func WithEmbedder(ctx context.Context, e Embedder) context.Context {
+	return context.WithValue(ctx, embedderKey{}, e)
+}
+
+// EmbedderFrom returns the embedder stored in the context,
+// or one that embeds with the context's provider and its default model.
+func EmbedderFrom(ctx context.Context) Embedder {
+	if e, ok := ctx.Value(embedderKey{}).(Embedder); ok && e != nil {
+		return e
+	}
+	return NewEmbedder(ProviderFrom(ctx), EmbeddingConfig{})
+}
+
+// CosineSimilarity compares two vectors of the same length; 1 is the same direction.
+func CosineSimilarity(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
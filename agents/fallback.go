@@ -0,0 +1,31 @@
+package agents
+
+import (
+	"errors"
+)
+
+// FinishReasonContentFilter is the FinishReason of a response blocked by the provider's content filter.
+const FinishReasonContentFilter = "content_filter"
+
+var (
+	// ErrContentFilter is the reason to fall back from a response blocked by a content filter.
+	ErrContentFilter = errors.New("the response was blocked by the content filter")
+	// ErrEmptyResponse is the reason to fall back from a response with no content and no tool calls.
+	ErrEmptyResponse = errors.New("the response was empty")
+)
+
+// FallbackReason explains why a call should be tried again with the next model of a fallback chain.
+// It is nil when the response can be used.
+func FallbackReason(resp *ChatResponse, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case resp == nil:
+		return ErrNoChoices
+	case resp.FinishReason == FinishReasonContentFilter:
+		return ErrContentFilter
+	case resp.Content == "" && len(resp.ToolCalls) == 0:
+		return ErrEmptyResponse
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package agents
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HistoryPolicy sends the earlier turns of a chat with an agent's prompt.
+// In yaml it is a number of turns, or a mapping with a number of turns and a token budget.
+//
+//	agents:
+//	  support:
+//	    history: 6
+//	  concierge:
+//	    history:
+//	      turns: 20
+//	      tokens: 2000
+type HistoryPolicy struct {
+	Turns  int `yaml:"turns,omitempty" json:"turns,omitempty"`   // most recent turns to send; zero sends them all
+	Tokens int `yaml:"tokens,omitempty" json:"tokens,omitempty"` // estimated tokens the turns may use; zero is no budget
+}
+
+func (p *HistoryPolicy) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		turns, err := strconv.Atoi(node.Value)
+		if err != nil || turns <= 0 {
+			return fmt.Errorf("line %d: history must be a number of turns or a mapping with turns and tokens, got %q", node.Line, node.Value)
+		}
+		*p = HistoryPolicy{Turns: turns}
+		return nil
+	}
+	type policy HistoryPolicy // without UnmarshalYAML
+	var value policy
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	if value.Turns < 0 || value.Tokens < 0 {
+		return fmt.Errorf("line %d: history turns and tokens must be positive", node.Line)
+	}
+	*p = HistoryPolicy(value)
+	return nil
+}
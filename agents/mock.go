@@ -0,0 +1,230 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/robbyriverside/agencia/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// MockResponses is the layout of a mock responses YAML file.
+//
+//	responses:
+//	  hello_user: "Hello {{ .Input }}!"        # plain reply for the agent
+//	  greeter:
+//	    content: "Hi {{ .Input }}"             # reply to the prompt (or after tool calls)
+//	    inputs: "name: Bob"                    # reply to input extraction
+//	    facts: "name: Bob"                     # reply to fact and memory extraction
+//	    tools:                                 # tool calls made in reply to the prompt
+//	      - name: greet
+//	        arguments: {personName: Bob}
+//	patterns:
+//	  - match: "(?i)weather"                   # regexp matched against the last user message
+//	    content: "It is sunny."
+//	default: "I don't know."
+type MockResponses struct {
+	Responses map[string]*MockEntry `yaml:"responses"`
+	Patterns  []*MockPattern        `yaml:"patterns,omitempty"`
+	Default   string                `yaml:"default,omitempty"`
+}
+
+// MockEntry scripts the replies for one agent or pattern.
+// Every reply is a template rendered with .Agent, .Input, .Prompt and .ToolResults.
+type MockEntry struct {
+	Content string          `yaml:"content,omitempty"`
+	Inputs  string          `yaml:"inputs,omitempty"`
+	Facts   string          `yaml:"facts,omitempty"`
+	Tools   []*MockToolCall `yaml:"tools,omitempty"`
+}
+
+// UnmarshalYAML accepts a plain string as the Content of the entry.
+func (e *MockEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.Content = node.Value
+		return nil
+	}
+	type entry MockEntry
+	return node.Decode((*entry)(e))
+}
+
+// MockToolCall is a scripted call to a listener.
+// Arguments may be a string or a mapping, which is sent as JSON.
+type MockToolCall struct {
+	Name      string `yaml:"name"`
+	Arguments any    `yaml:"arguments"`
+}
+
+// MockPattern scripts replies for any agent whose prompt matches the regexp.
+type MockPattern struct {
+	Match string    `yaml:"match"`
+	Entry MockEntry `yaml:",inline"`
+	re    *regexp.Regexp
+}
+
+// MockProvider answers chat requests from a MockResponses file without calling any model.
+type MockProvider struct {
+	MockResponses
+	calls atomic.Int64
+}
+
+type mockData struct {
+	Agent       string
+	Input       string
+	Prompt      string
+	ToolResults string // tool outputs sent back in a continuation, one per line
+}
+
+// LoadMockProvider reads a mock responses YAML file.
+func LoadMockProvider(filename string) (*MockProvider, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read mock file %s: %w", filename, err)
+	}
+	return NewMockProvider(data)
+}
+
+// NewMockProvider parses mock responses YAML.
+func NewMockProvider(data []byte) (*MockProvider, error) {
+	mock := &MockProvider{}
+	if err := yaml.Unmarshal(data, &mock.MockResponses); err != nil {
+		return nil, fmt.Errorf("invalid mock YAML: %w", err)
+	}
+	for _, pattern := range mock.Patterns {
+		re, err := regexp.Compile(pattern.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mock pattern %q: %w", pattern.Match, err)
+		}
+		pattern.re = re
+	}
+	return mock, nil
+}
+
+func (m *MockProvider) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	data := mockData{
+		Agent:       req.Agent,
+		Input:       req.Input,
+		Prompt:      lastUserMessage(req.Messages),
+		ToolResults: toolResults(req.Messages),
+	}
+	entry := m.lookup(req.Agent, data.Prompt)
+	extraction := req.Purpose == PurposeInputs || req.Purpose == PurposeFacts || req.Purpose == PurposeMemory
+	if entry == nil {
+		if extraction {
+			return &ChatResponse{Model: "mock", Content: "{}"}, nil
+		}
+		return nil, fmt.Errorf("mock: no response for agent %q", req.Agent)
+	}
+
+	text := entry.Content
+	switch req.Purpose {
+	case PurposeInputs:
+		text = entry.Inputs
+	case PurposeFacts, PurposeMemory:
+		text = entry.Facts
+	case PurposePrompt:
+		if len(entry.Tools) > 0 {
+			return m.toolCalls(entry)
+		}
+	}
+	if extraction && text == "" {
+		text = "{}"
+	}
+	content, err := renderMock(req.Agent, text, data)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResponse{Model: "mock", Content: content}, nil
+}
+
+// Embed returns a small deterministic vector for each input.
+func (m *MockProvider) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	vectors := make([][]float32, len(req.Input))
+	for i, text := range req.Input {
+		h := fnv.New64a()
+		h.Write([]byte(text))
+		sum := h.Sum64()
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = float32((sum>>(j*8))&0xff) / 255
+		}
+		vectors[i] = vec
+	}
+	return &EmbedResponse{Model: "mock", Vectors: vectors}, nil
+}
+
+func (m *MockProvider) lookup(agent, prompt string) *MockEntry {
+	if entry, ok := m.Responses[agent]; ok && entry != nil {
+		return entry
+	}
+	for _, pattern := range m.Patterns {
+		if pattern.re != nil && pattern.re.MatchString(prompt) {
+			return &pattern.Entry
+		}
+	}
+	if m.Default != "" {
+		return &MockEntry{Content: m.Default}
+	}
+	return nil
+}
+
+func (m *MockProvider) toolCalls(entry *MockEntry) (*ChatResponse, error) {
+	resp := &ChatResponse{Model: "mock"}
+	for _, call := range entry.Tools {
+		args, ok := call.Arguments.(string)
+		if !ok && call.Arguments != nil {
+			b, err := json.Marshal(call.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("mock: invalid arguments for tool %s: %w", call.Name, err)
+			}
+			args = string(b)
+		}
+		resp.ToolCalls = append(resp.ToolCalls, ToolCall{
+			ID:        fmt.Sprintf("mock_call_%d", m.calls.Add(1)),
+			Name:      call.Name,
+			Arguments: args,
+		})
+	}
+	return resp, nil
+}
+
+func renderMock(name, text string, data mockData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return strings.TrimSpace(text), nil
+	}
+	tmpl, err := utils.TemplateParse(name, text)
+	if err != nil {
+		return "", fmt.Errorf("mock template parse error: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("mock template exec error: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func lastUserMessage(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+func toolResults(messages []Message) string {
+	var results []string
+	for _, msg := range messages {
+		if msg.Role == RoleTool {
+			results = append(results, strings.TrimSpace(msg.Content))
+		}
+	}
+	return strings.Join(results, "\n")
+}
@@ -0,0 +1,364 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	DefaultModel          = openai.GPT4o
+	DefaultTemperature    = 0.2
+	DefaultEmbeddingModel = string(openai.AdaEmbeddingV2)
+)
+
+// GetOpenAIClient returns a new client for the OpenAI API configured from the environment.
+// Callers keep the client; OpenAIProvider creates one on first use.
+func GetOpenAIClient() (*openai.Client, error) {
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	org := os.Getenv("OPENAI_ORG")
+	if apiKey == "" {
+		return nil, errors.New("OPENAI_API_KEY must be set")
+	}
+	config := openai.DefaultConfig(apiKey)
+	config.OrgID = org
+	config.HTTPClient = &http.Client{Transport: &captureTransport{base: http.DefaultTransport}}
+	return openai.NewClientWithConfig(config), nil
+}
+
+// OpenAIProvider calls the OpenAI API.
+// The client is created on first use and reused afterwards.
+// Requests with a ResponseFormat use json_schema structured outputs
+// unless NoStructuredOutputs is set, for compatible servers that lack them.
+type OpenAIProvider struct {
+	Client              *openai.Client
+	NoStructuredOutputs bool
+	mu                  sync.Mutex
+}
+
+func (p *OpenAIProvider) client() (*openai.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Client != nil {
+		return p.Client, nil
+	}
+	client, err := GetOpenAIClient()
+	if err != nil {
+		return nil, err
+	}
+	p.Client = client
+	return client, nil
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx, capture := withHeaderCapture(ctx)
+	resp, err := client.CreateChatCompletion(ctx, p.toOpenAIRequest(req))
+	if err != nil {
+		return nil, openAIError(err, capture)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, ErrNoChoices
+	}
+	msg := resp.Choices[0].Message
+	return &ChatResponse{
+		Model:        resp.Model,
+		Content:      msg.Content,
+		ToolCalls:    fromOpenAIToolCalls(msg.ToolCalls),
+		Usage:        fromOpenAIUsage(resp.Usage),
+		FinishReason: string(resp.Choices[0].FinishReason),
+	}, nil
+}
+
+func (p *OpenAIProvider) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*ChatResponse, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	oreq := p.toOpenAIRequest(req)
+	oreq.Stream = true
+	oreq.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	ctx, capture := withHeaderCapture(ctx)
+	stream, err := client.CreateChatCompletionStream(ctx, oreq)
+	if err != nil {
+		return nil, openAIError(err, capture)
+	}
+	defer stream.Close()
+
+	result := &ChatResponse{}
+	var content strings.Builder
+	var calls []openai.ToolCall
+	chunks := 0
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, openAIError(err, capture)
+		}
+		if result.Model == "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			result.Usage = fromOpenAIUsage(*chunk.Usage) // the last chunk counts the whole response
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		chunks++
+		if reason := chunk.Choices[0].FinishReason; reason != "" {
+			result.FinishReason = string(reason)
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			onDelta(delta.Content)
+		}
+		calls = mergeToolCallDeltas(calls, delta.ToolCalls)
+	}
+	if chunks == 0 {
+		return nil, ErrNoChoices
+	}
+	result.Content = content.String()
+	result.ToolCalls = fromOpenAIToolCalls(calls)
+	return result, nil
+}
+
+// mergeToolCallDeltas assembles streamed tool calls.
+// The first delta for a call carries its index, id and name; later deltas
+// with the same index carry more of the arguments.
+func mergeToolCallDeltas(calls []openai.ToolCall, deltas []openai.ToolCall) []openai.ToolCall {
+	for _, d := range deltas {
+		index := len(calls)
+		if d.Index != nil {
+			index = *d.Index
+		} else if d.ID == "" && len(calls) > 0 {
+			index = len(calls) - 1
+		}
+		for len(calls) <= index {
+			calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+		call := &calls[index]
+		if d.ID != "" {
+			call.ID = d.ID
+		}
+		if d.Type != "" {
+			call.Type = d.Type
+		}
+		if d.Function.Name != "" {
+			call.Function.Name = d.Function.Name
+		}
+		call.Function.Arguments += d.Function.Arguments
+	}
+	return calls
+}
+
+func (p *OpenAIProvider) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	model := req.Model
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+	ctx, capture := withHeaderCapture(ctx)
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input:      req.Input,
+		Model:      openai.EmbeddingModel(model),
+		Dimensions: req.Dimensions,
+	})
+	if err != nil {
+		return nil, openAIError(err, capture)
+	}
+	vectors := make([][]float32, len(resp.Data))
+	for i, data := range resp.Data {
+		vectors[i] = data.Embedding
+	}
+	return &EmbedResponse{Model: string(resp.Model), Vectors: vectors}, nil
+}
+
+// openAIParts sends the message text and its attachments as content parts.
+// Images are sent by URL, text files as text, and other files are only named.
+func openAIParts(m Message) []openai.ChatMessagePart {
+	parts := []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: m.Content}}
+	for _, a := range m.Attachments {
+		part := openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: attachmentText(a)}
+		if a.IsImage() {
+			part = openai.ChatMessagePart{
+				Type:     openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{URL: a.DataURL()},
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func toOpenAIRequest(req *ChatRequest) openai.ChatCompletionRequest {
+	model := req.Model
+	if model == "" {
+		model = DefaultModel
+	}
+	messages := make([]openai.ChatCompletionMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := openai.ChatCompletionMessage{
+			Role:       m.Role,
+			Content:    m.Content,
+			ToolCallID: m.ToolCallID,
+		}
+		if len(m.Attachments) > 0 {
+			msg.Content = ""
+			msg.MultiContent = openAIParts(m)
+		}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+				ID:   call.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				},
+			})
+		}
+		messages = append(messages, msg)
+	}
+	var tools []openai.Tool
+	for _, tool := range req.Tools {
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	oreq := openai.ChatCompletionRequest{
+		Model:     model,
+		MaxTokens: req.MaxTokens,
+		Seed:      req.Seed,
+		Stop:      req.Stop,
+		Messages:  messages,
+		Tools:     tools,
+	}
+	if req.Temperature != nil {
+		oreq.Temperature = nonZero(*req.Temperature)
+	}
+	if req.TopP != nil {
+		oreq.TopP = nonZero(*req.TopP)
+	}
+	return oreq
+}
+
+// toOpenAIRequest adds the structured output format when the server supports it.
+func (p *OpenAIProvider) toOpenAIRequest(req *ChatRequest) openai.ChatCompletionRequest {
+	oreq := toOpenAIRequest(req)
+	if req.ResponseFormat != nil && !p.NoStructuredOutputs {
+		oreq.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   req.ResponseFormat.Name,
+				Schema: jsonSchema(req.ResponseFormat.Schema),
+				Strict: req.ResponseFormat.Strict,
+			},
+		}
+	}
+	return oreq
+}
+
+// jsonSchema lets a schema map be sent as a json.Marshaler.
+type jsonSchema map[string]any
+
+func (s jsonSchema) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any(s))
+}
+
+func fromOpenAIToolCalls(calls []openai.ToolCall) []ToolCall {
+	var result []ToolCall
+	for _, call := range calls {
+		if call.Type != "" && call.Type != openai.ToolTypeFunction {
+			continue
+		}
+		result = append(result, ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return result
+}
+
+func fromOpenAIUsage(u openai.Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
+
+// openAIError adds the HTTP status and Retry-After of a failed call.
+func openAIError(err error, capture *headerCapture) error {
+	err = fmt.Errorf("OpenAI API error: %w", err)
+	status := 0
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	default:
+		return err
+	}
+	return &ProviderError{StatusCode: status, RetryAfter: retryAfter(capture.header), Err: err}
+}
+
+// headerCapture holds the response headers of the call whose context carries it.
+// go-openai does not return headers with its errors, and Retry-After is needed.
+type headerCapture struct {
+	header http.Header
+}
+
+type headerCaptureKey struct{}
+
+func withHeaderCapture(ctx context.Context) (context.Context, *headerCapture) {
+	capture := &headerCapture{header: http.Header{}}
+	return context.WithValue(ctx, headerCaptureKey{}, capture), capture
+}
+
+// captureTransport saves response headers into the request's headerCapture.
+type captureTransport struct {
+	base http.RoundTripper
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if resp != nil {
+		if capture, ok := req.Context().Value(headerCaptureKey{}).(*headerCapture); ok {
+			capture.header = resp.Header
+		}
+	}
+	return resp, err
+}
+
+// nonZero keeps an explicit zero from being dropped by omitempty in the request JSON.
+func nonZero(v float32) float32 {
+	if v == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return v
+}
@@ -0,0 +1,60 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Overflow strategies for a prompt longer than an agent's max_input_tokens.
+const (
+	OverflowTruncateTail = "truncate_tail" // cut the end of the prompt; the default
+	OverflowTruncateHead = "truncate_head" // cut the start of the prompt
+	OverflowDropHistory  = "drop_history"  // drop the oldest chat turns, then cut the end of the prompt
+	OverflowSummarize    = "summarize"     // replace the prompt with a summary written by another agent
+)
+
+// OverflowPolicy fits a prompt into the agent's max_input_tokens.
+// In yaml it is the name of a strategy, or summarize:<agent> to summarize with that agent.
+//
+//	agents:
+//	  review:
+//	    max_input_tokens: 8000
+//	    overflow: summarize:condense
+type OverflowPolicy struct {
+	Strategy string
+	Agent    string // the agent that summarizes the prompt
+}
+
+func (p *OverflowPolicy) UnmarshalYAML(node *yaml.Node) error {
+	policy, err := ParseOverflowPolicy(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*p = *policy
+	return nil
+}
+
+func (p *OverflowPolicy) MarshalYAML() (any, error) {
+	return p.String(), nil
+}
+
+func (p *OverflowPolicy) String() string {
+	if p.Strategy == OverflowSummarize {
+		return OverflowSummarize + ":" + p.Agent
+	}
+	return p.Strategy
+}
+
+// ParseOverflowPolicy reads an overflow setting.
+func ParseOverflowPolicy(value string) (*OverflowPolicy, error) {
+	switch value {
+	case OverflowTruncateTail, OverflowTruncateHead, OverflowDropHistory:
+		return &OverflowPolicy{Strategy: value}, nil
+	}
+	if agent, ok := strings.CutPrefix(value, OverflowSummarize+":"); ok && agent != "" {
+		return &OverflowPolicy{Strategy: OverflowSummarize, Agent: agent}, nil
+	}
+	return nil, fmt.Errorf("overflow must be truncate_tail, truncate_head, drop_history or summarize:<agent>, got %q", value)
+}
@@ -0,0 +1,118 @@
+package agents
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Provider types for a ProviderConfig.
+const (
+	ProviderTypeOpenAI    = "openai"    // OpenAI or any server speaking the chat completions wire format
+	ProviderTypeAzure     = "azure"     // Azure OpenAI deployments
+	ProviderTypeAnthropic = "anthropic" // the Anthropic Messages API
+)
+
+// ProviderConfig is a named provider profile declared in the providers section of a spec.
+//
+//	providers:
+//	  local-llama:
+//	    base_url: http://localhost:11434/v1
+//	    model: llama3
+//	  azure:
+//	    type: azure
+//	    base_url: https://example.openai.azure.com
+//	    api_key_env: AZURE_OPENAI_KEY
+//	    api_version: 2024-02-01
+//	  claude:
+//	    type: anthropic
+//	    model: claude-sonnet-4-5
+type ProviderConfig struct {
+	Type       string            `yaml:"type,omitempty" json:"type,omitempty"`
+	BaseURL    string            `yaml:"base_url,omitempty" json:"base_url,omitempty"`
+	APIKeyEnv  string            `yaml:"api_key_env,omitempty" json:"api_key_env,omitempty"`
+	APIVersion string            `yaml:"api_version,omitempty" json:"api_version,omitempty"`
+	Headers    map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Model      string            `yaml:"model,omitempty" json:"model,omitempty"` // default model for agents using this provider
+	Retry      *RetryConfig      `yaml:"retry,omitempty" json:"retry,omitempty"` // retry, concurrency and circuit breaker settings
+
+	StructuredOutputs *bool `yaml:"structured_outputs,omitempty" json:"structured_outputs,omitempty"` // false for compatible servers without json_schema response formats
+}
+
+// DefaultModel returns the profile's model, or the vendor default when none is set.
+// An empty result leaves the choice to the spec defaults.
+func (cfg *ProviderConfig) DefaultModel() string {
+	if cfg.Model == "" && cfg.Type == ProviderTypeAnthropic {
+		return DefaultAnthropicModel
+	}
+	return cfg.Model
+}
+
+// NewProvider builds a provider from a profile.
+// The API key is read from the APIKeyEnv environment variable when it is set;
+// local servers that need no key can leave it empty.
+// Anthropic profiles read ANTHROPIC_API_KEY unless another variable is named.
+func NewProvider(cfg *ProviderConfig) (Provider, error) {
+	apiKeyEnv := cfg.APIKeyEnv
+	if apiKeyEnv == "" && cfg.Type == ProviderTypeAnthropic {
+		apiKeyEnv = DefaultAnthropicAPIKeyEnv
+	}
+	apiKey := ""
+	if apiKeyEnv != "" {
+		apiKey = os.Getenv(apiKeyEnv)
+		if apiKey == "" {
+			return nil, fmt.Errorf("%s must be set", apiKeyEnv)
+		}
+	}
+	if cfg.Type == ProviderTypeAnthropic {
+		return NewResilientProvider(&AnthropicProvider{
+			APIKey:  apiKey,
+			BaseURL: cfg.BaseURL,
+			Version: cfg.APIVersion,
+			Headers: cfg.Headers,
+		}, cfg.Retry), nil
+	}
+	var config openai.ClientConfig
+	switch cfg.Type {
+	case "", ProviderTypeOpenAI:
+		config = openai.DefaultConfig(apiKey)
+		if cfg.BaseURL != "" {
+			config.BaseURL = cfg.BaseURL
+		}
+	case ProviderTypeAzure:
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("azure provider requires base_url")
+		}
+		config = openai.DefaultAzureConfig(apiKey, cfg.BaseURL)
+		if cfg.APIVersion != "" {
+			config.APIVersion = cfg.APIVersion
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", cfg.Type)
+	}
+	transport := http.DefaultTransport
+	if len(cfg.Headers) > 0 {
+		transport = &headerTransport{headers: cfg.Headers, base: transport}
+	}
+	config.HTTPClient = &http.Client{Transport: &captureTransport{base: transport}}
+	return NewResilientProvider(&OpenAIProvider{
+		Client:              openai.NewClientWithConfig(config),
+		NoStructuredOutputs: cfg.StructuredOutputs != nil && !*cfg.StructuredOutputs,
+	}, cfg.Retry), nil
+}
+
+// headerTransport adds the profile's extra headers to every request.
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return t.base.RoundTrip(req)
+}
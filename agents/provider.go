@@ -0,0 +1,198 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Message roles understood by every provider.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Message is one chat message sent to or received from a provider.
+type Message struct {
+	Role       string     `json:"role" yaml:"role"`
+	Content    string     `json:"content,omitempty" yaml:"content,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty" yaml:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty" yaml:"tool_call_id,omitempty"`
+
+	// Attachments are sent with a user message as content parts after its text.
+	Attachments []Attachment `json:"attachments,omitempty" yaml:"attachments,omitempty"`
+}
+
+// ToolCall is a request from the model to call a listener agent.
+type ToolCall struct {
+	ID        string `json:"id" yaml:"id"`
+	Name      string `json:"name" yaml:"name"`
+	Arguments string `json:"arguments" yaml:"arguments"`
+}
+
+// Tool describes a listener agent the model is allowed to call.
+type Tool struct {
+	Name        string         `json:"name" yaml:"name"`
+	Description string         `json:"description" yaml:"description"`
+	Parameters  map[string]any `json:"parameters" yaml:"parameters"`
+}
+
+// Purposes of a ChatRequest.
+const (
+	PurposePrompt = "prompt" // the rendered prompt of a prompt agent
+	PurposeTools  = "tools"  // continuation after tool results
+	PurposeInputs = "inputs" // input extraction
+	PurposeFacts  = "facts"  // fact extraction
+	PurposeMemory = "memory" // chat memory extraction
+)
+
+// ResponseFormat asks the model to answer with a JSON object that matches Schema.
+// Providers with structured outputs enforce it; others ignore it,
+// so the caller still validates the answer.
+type ResponseFormat struct {
+	Name   string         `json:"name" yaml:"name"`
+	Schema map[string]any `json:"schema" yaml:"schema"`
+	Strict bool           `json:"strict,omitempty" yaml:"strict,omitempty"` // the schema follows the strict subset of JSON schema
+}
+
+// ChatRequest is a provider neutral chat completion request.
+type ChatRequest struct {
+	Model       string    `json:"model,omitempty" yaml:"model,omitempty"`
+	Temperature *float32  `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	TopP        *float32  `json:"top_p,omitempty" yaml:"top_p,omitempty"`
+	Seed        *int      `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Stop        []string  `json:"stop,omitempty" yaml:"stop,omitempty"`
+	Messages    []Message `json:"messages" yaml:"messages"`
+	Tools       []Tool    `json:"tools,omitempty" yaml:"tools,omitempty"`
+
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty" yaml:"response_format,omitempty"`
+
+	// Provider, Agent, Purpose and Input describe where and why the request was made.
+	// They are not sent to the model; mock and test providers use them.
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	Agent    string `json:"agent,omitempty" yaml:"agent,omitempty"`
+	Purpose  string `json:"purpose,omitempty" yaml:"purpose,omitempty"`
+	Input    string `json:"input,omitempty" yaml:"input,omitempty"`
+}
+
+// ChatResponse is the first choice of a chat completion.
+// Either Content or ToolCalls is set.
+type ChatResponse struct {
+	Model        string     `json:"model,omitempty" yaml:"model,omitempty"`
+	Content      string     `json:"content,omitempty" yaml:"content,omitempty"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty" yaml:"tool_calls,omitempty"`
+	Usage        Usage      `json:"usage,omitzero" yaml:"usage,omitempty"`                  // tokens reported by the provider
+	FinishReason string     `json:"finish_reason,omitempty" yaml:"finish_reason,omitempty"` // why the model stopped; FinishReasonContentFilter when it was blocked
+}
+
+// EmbedRequest asks the provider for one vector per input string.
+type EmbedRequest struct {
+	Model      string   `json:"model,omitempty" yaml:"model,omitempty"`
+	Dimensions int      `json:"dimensions,omitempty" yaml:"dimensions,omitempty"` // zero is the model's full size
+	Input      []string `json:"input" yaml:"input"`
+}
+
+// EmbedResponse holds the vectors in the same order as the request input.
+type EmbedResponse struct {
+	Model   string      `json:"model,omitempty" yaml:"model,omitempty"`
+	Vectors [][]float32 `json:"vectors" yaml:"vectors"`
+}
+
+// Provider is the interface to an AI backend.
+// All AI calls made by agencia and its libraries go through a Provider.
+type Provider interface {
+	Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error)
+	Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error)
+}
+
+// StreamProvider is a Provider that can stream the response as it is generated.
+// ChatStream calls onDelta with each piece of text content as it arrives and
+// returns the complete response, including any tool calls assembled from their deltas.
+type StreamProvider interface {
+	Provider
+	ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*ChatResponse, error)
+}
+
+// ChatStream streams the request when the provider supports it.
+// Other providers answer with Chat and the whole content is sent as a single delta.
+func ChatStream(ctx context.Context, p Provider, req *ChatRequest, onDelta func(delta string)) (*ChatResponse, error) {
+	if sp, ok := p.(StreamProvider); ok {
+		return sp.ChatStream(ctx, req, onDelta)
+	}
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Content != "" {
+		onDelta(resp.Content)
+	}
+	return resp, nil
+}
+
+// ErrNoChoices is returned when the provider answers without any choices.
+var ErrNoChoices = errors.New("no choices returned from AI provider")
+
+var (
+	defaultProvider   Provider
+	defaultProviderMu sync.Mutex
+)
+
+// DefaultProvider returns the process wide provider, a resilient OpenAIProvider unless replaced.
+func DefaultProvider() Provider {
+	defaultProviderMu.Lock()
+	defer defaultProviderMu.Unlock()
+	if defaultProvider == nil {
+		defaultProvider = NewResilientProvider(&OpenAIProvider{}, nil)
+	}
+	return defaultProvider
+}
+
+// SetDefaultProvider replaces the process wide provider.
+// Passing nil restores the OpenAI provider.
+func SetDefaultProvider(p Provider) {
+	defaultProviderMu.Lock()
+	defer defaultProviderMu.Unlock()
+	defaultProvider = p
+}
+
+type providerKey struct{}
+
+// WithProvider stores the provider in the context for function agents.
+func WithProvider(ctx context.Context, p Provider) context.Context {
+	return context.WithValue(ctx, providerKey{}, p)
+}
+
+// ProviderFrom returns the provider stored in the context or the default provider.
+func ProviderFrom(ctx context.Context) Provider {
+	if p, ok := ctx.Value(providerKey{}).(Provider); ok && p != nil {
+		return p
+	}
+	return DefaultProvider()
+}
+
+// CallAI sends a single user prompt to the provider in the context and returns the response.
+// The agent's model params are used when the agent is not nil.
+// Used by library agents to call AI.
+func CallAI(ctx context.Context, agent *Agent, prompt string) (string, error) {
+	req := &ChatRequest{
+		Messages: []Message{
+			{Role: RoleUser, Content: prompt},
+		},
+		Purpose: PurposePrompt,
+	}
+	var params ModelParams
+	if agent != nil {
+		params = agent.ModelParams
+		req.Agent = agent.Name
+	}
+	params.Apply(req)
+	resp, err := ProviderFrom(ctx).Chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Content), nil
+}
@@ -0,0 +1,315 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryConfig controls how a provider retries failed calls and protects its backend.
+// Zero fields take the defaults below.
+//
+//	providers:
+//	  openai:
+//	    retry:
+//	      max_attempts: 4
+//	      initial_backoff: 1s
+//	      max_concurrent: 8
+type RetryConfig struct {
+	MaxAttempts      int           `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"`           // attempts per call, including the first
+	InitialBackoff   time.Duration `yaml:"initial_backoff,omitempty" json:"initial_backoff,omitempty"`     // backoff before the second attempt
+	MaxBackoff       time.Duration `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`             // backoff never grows past this; a longer Retry-After is not retried
+	MaxConcurrent    int           `yaml:"max_concurrent,omitempty" json:"max_concurrent,omitempty"`       // calls in flight at once; 0 is unlimited
+	BreakerThreshold int           `yaml:"breaker_threshold,omitempty" json:"breaker_threshold,omitempty"` // consecutive failures that open the circuit
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown,omitempty" json:"breaker_cooldown,omitempty"`   // how long the circuit stays open
+}
+
+const (
+	DefaultMaxAttempts      = 3
+	DefaultInitialBackoff   = 500 * time.Millisecond
+	DefaultMaxBackoff       = 20 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = DefaultInitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.BreakerThreshold <= 0 {
+		c.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if c.BreakerCooldown <= 0 {
+		c.BreakerCooldown = DefaultBreakerCooldown
+	}
+	return c
+}
+
+// ProviderError is a failed call to a provider's API.
+// StatusCode is zero when no response was received.
+type ProviderError struct {
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header
+	Err        error
+}
+
+func (e *ProviderError) Error() string { return e.Err.Error() }
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// ErrCircuitOpen is returned without calling the provider while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open: provider is failing")
+
+// Retryable reports whether a failed call may succeed when tried again:
+// rate limits, timeouts, server errors and dropped connections.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var perr *ProviderError
+	if errors.As(err, &perr) {
+		switch {
+		case perr.StatusCode == 0:
+			return true
+		case perr.StatusCode == http.StatusTooManyRequests, perr.StatusCode == http.StatusRequestTimeout:
+			return true
+		case perr.StatusCode >= 500:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Attempt describes one try of a provider call.
+type Attempt struct {
+	Number   int
+	Of       int // the most attempts the call will make
+	Model    string
+	Duration time.Duration // time spent waiting for the provider
+	Wait     time.Duration // backoff before the next attempt; zero when there is none
+	Err      error
+}
+
+func (a Attempt) String() string {
+	if a.Err == nil {
+		return fmt.Sprintf("attempt %d/%d with %s succeeded in %s", a.Number, a.Of, a.Model, a.Duration.Round(time.Millisecond))
+	}
+	msg := fmt.Sprintf("attempt %d/%d with %s failed in %s: %v", a.Number, a.Of, a.Model, a.Duration.Round(time.Millisecond), a.Err)
+	if a.Wait > 0 {
+		msg += fmt.Sprintf("; retrying in %s", a.Wait.Round(time.Millisecond))
+	}
+	return msg
+}
+
+type attemptKey struct{}
+
+// WithAttemptLog has resilient providers report every attempt to fn.
+func WithAttemptLog(ctx context.Context, fn func(Attempt)) context.Context {
+	return context.WithValue(ctx, attemptKey{}, fn)
+}
+
+func logAttempt(ctx context.Context, a Attempt) {
+	if fn, ok := ctx.Value(attemptKey{}).(func(Attempt)); ok && fn != nil {
+		fn(a)
+	}
+}
+
+// ResilientProvider retries a provider's failed calls with jittered exponential backoff,
+// limits how many calls are in flight, and stops calling a failing provider for a while
+// once too many calls in a row have failed.
+type ResilientProvider struct {
+	Provider Provider
+	Config   RetryConfig
+
+	slots chan struct{}
+
+	mu       sync.Mutex
+	failures int       // consecutive failed attempts
+	openTill time.Time // the circuit is open until then
+}
+
+// NewResilientProvider wraps p. A nil config uses the defaults.
+func NewResilientProvider(p Provider, cfg *RetryConfig) *ResilientProvider {
+	var c RetryConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	c = c.withDefaults()
+	r := &ResilientProvider{Provider: p, Config: c}
+	if c.MaxConcurrent > 0 {
+		r.slots = make(chan struct{}, c.MaxConcurrent)
+	}
+	return r
+}
+
+func (r *ResilientProvider) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	var resp *ChatResponse
+	err := r.do(ctx, req.Model, func() (bool, error) {
+		var err error
+		resp, err = r.Provider.Chat(ctx, req)
+		return true, err
+	})
+	return resp, err
+}
+
+// ChatStream retries only until the first delta arrives, so no text is ever sent twice.
+func (r *ResilientProvider) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*ChatResponse, error) {
+	var resp *ChatResponse
+	streamed := false
+	err := r.do(ctx, req.Model, func() (bool, error) {
+		var err error
+		resp, err = ChatStream(ctx, r.Provider, req, func(delta string) {
+			streamed = true
+			onDelta(delta)
+		})
+		return !streamed, err
+	})
+	return resp, err
+}
+
+func (r *ResilientProvider) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	var resp *EmbedResponse
+	err := r.do(ctx, req.Model, func() (bool, error) {
+		var err error
+		resp, err = r.Provider.Embed(ctx, req)
+		return true, err
+	})
+	return resp, err
+}
+
+// do runs call until it succeeds, fails for good, or runs out of attempts.
+// call reports whether a failure may be retried.
+func (r *ResilientProvider) do(ctx context.Context, model string, call func() (bool, error)) error {
+	if model == "" {
+		model = "the default model"
+	}
+	var err error
+	for n := 1; n <= r.Config.MaxAttempts; n++ {
+		if err := r.allow(); err != nil {
+			logAttempt(ctx, Attempt{Number: n, Of: r.Config.MaxAttempts, Model: model, Err: err})
+			return err
+		}
+		if err := r.acquire(ctx); err != nil {
+			return err
+		}
+		start := time.Now()
+		var canRetry bool
+		canRetry, err = call()
+		r.release()
+		attempt := Attempt{Number: n, Of: r.Config.MaxAttempts, Model: model, Duration: time.Since(start), Err: err}
+		if err == nil {
+			r.record(true)
+			logAttempt(ctx, attempt)
+			return nil
+		}
+		retryable := Retryable(err)
+		if retryable {
+			r.record(false)
+		}
+		if !retryable || !canRetry || n == r.Config.MaxAttempts || r.waitsTooLong(err) {
+			logAttempt(ctx, attempt)
+			return err
+		}
+		attempt.Wait = r.backoff(n, err)
+		logAttempt(ctx, attempt)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(attempt.Wait):
+		}
+	}
+	return err
+}
+
+// waitsTooLong reports a Retry-After longer than MaxBackoff, which fails the call at once
+// rather than holding up every agent waiting on it.
+func (r *ResilientProvider) waitsTooLong(err error) bool {
+	var perr *ProviderError
+	return errors.As(err, &perr) && perr.RetryAfter > r.Config.MaxBackoff
+}
+
+// backoff is the wait before attempt n+1: the Retry-After the provider asked for,
+// or a random duration up to the exponential backoff for n.
+func (r *ResilientProvider) backoff(n int, err error) time.Duration {
+	var perr *ProviderError
+	if errors.As(err, &perr) && perr.RetryAfter > 0 {
+		return perr.RetryAfter
+	}
+	ceiling := r.Config.InitialBackoff << (n - 1)
+	if ceiling <= 0 || ceiling > r.Config.MaxBackoff {
+		ceiling = r.Config.MaxBackoff
+	}
+	return ceiling/2 + rand.N(ceiling/2+1)
+}
+
+func (r *ResilientProvider) acquire(ctx context.Context) error {
+	if r.slots == nil {
+		return nil
+	}
+	select {
+	case r.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *ResilientProvider) release() {
+	if r.slots != nil {
+		<-r.slots
+	}
+}
+
+// allow refuses calls while the circuit is open.
+// Once the cooldown has passed, calls go through again; one more failure reopens it.
+func (r *ResilientProvider) allow() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Now().Before(r.openTill) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+func (r *ResilientProvider) record(success bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if success {
+		r.failures = 0
+		return
+	}
+	r.failures++
+	if r.failures >= r.Config.BreakerThreshold {
+		r.openTill = time.Now().Add(r.Config.BreakerCooldown)
+	}
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+package agents
+
+import (
+	"regexp"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+func init() {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader()) // the encodings are built in; nothing is downloaded
+}
+
+// Tokenizer counts the tokens a model reads in a text.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenizerFunc adapts a function to a Tokenizer.
+type TokenizerFunc func(text string) int
+
+func (f TokenizerFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+var (
+	tokenizersMu sync.RWMutex
+	// tokenizers are found by model name like prices: gpt matches gpt-4o and gpt-4o-mini.
+	tokenizers = map[string]Tokenizer{
+		"gpt-4o":        o200k,
+		"gpt-4.1":       o200k,
+		"gpt-4.5":       o200k,
+		"gpt-5":         o200k,
+		"o1":            o200k,
+		"o3":            o200k,
+		"o4":            o200k,
+		"gpt-4":         cl100k,
+		"gpt-3.5-turbo": cl100k,
+		// Claude's tokenizer is not published.  It splits English into about an eighth more
+		// tokens than OpenAI's, so Claude models are estimated from the o200k count.
+		"claude": TokenizerFunc(func(text string) int {
+			n := o200k.CountTokens(text)
+			return n + n/8
+		}),
+	}
+
+	o200k  = bpeTokenizer("o200k_base")
+	cl100k = bpeTokenizer("cl100k_base")
+)
+
+// bpeTokenizer counts tokens with the named tiktoken encoding, loaded on first use.
+// Should the encoding fail to load, texts are counted with EstimateTokens.
+func bpeTokenizer(encoding string) Tokenizer {
+	load := sync.OnceValue(func() *tiktoken.Tiktoken {
+		enc, err := tiktoken.GetEncoding(encoding)
+		if err != nil {
+			return nil
+		}
+		return enc
+	})
+	return TokenizerFunc(func(text string) int {
+		if enc := load(); enc != nil {
+			return len(enc.EncodeOrdinary(text))
+		}
+		return EstimateTokens(text)
+	})
+}
+
+// RegisterTokenizer counts the tokens of the model, and the dated models named after it, with the tokenizer.
+// OpenAI models are counted with their own encodings; register a tokenizer for other models
+// to replace the estimate of EstimateTokens.
+func RegisterTokenizer(model string, tokenizer Tokenizer) {
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+	tokenizers[model] = tokenizer
+}
+
+// TokenizerFor returns the tokenizer registered for the model, or EstimateTokens when there is none.
+func TokenizerFor(model string) Tokenizer {
+	tokenizersMu.RLock()
+	defer tokenizersMu.RUnlock()
+	if tokenizer, ok := lookupModel(model, tokenizers); ok {
+		return tokenizer
+	}
+	return TokenizerFunc(EstimateTokens)
+}
+
+// CountTokens counts the tokens of the text with the model's tokenizer.
+func CountTokens(model, text string) int {
+	return TokenizerFor(model).CountTokens(text)
+}
+
+// Tokens every chat message costs on top of its content, and that priming the reply costs.
+const (
+	messageTokens = 3
+	replyTokens   = 3
+)
+
+// CountMessageTokens counts the prompt tokens of the messages with the model's tokenizer.
+func CountMessageTokens(model string, messages []Message) int {
+	tokenizer := TokenizerFor(model)
+	tokens := replyTokens
+	for _, msg := range messages {
+		tokens += messageTokens + tokenizer.CountTokens(msg.Content)
+		for _, call := range msg.ToolCalls {
+			tokens += tokenizer.CountTokens(call.Name) + tokenizer.CountTokens(call.Arguments)
+		}
+	}
+	return tokens
+}
+
+// pieces splits text the way byte pair encoders do before merging:
+// contractions, words with their leading space, up to three digits, punctuation runs and whitespace.
+var pieces = regexp.MustCompile(`'(?:[sdmt]|ll|ve|re)| ?\p{L}+| ?\p{N}{1,3}| ?[^\s\p{L}\p{N}]+|\s+`)
+
+// EstimateTokens estimates the tokens of a text for models without a registered tokenizer.
+// It splits the text like a GPT tokenizer; common words are one token, long words one per four letters,
+// and letters outside ASCII one each.
+func EstimateTokens(text string) int {
+	tokens := 0
+	for _, piece := range pieces.FindAllString(text, -1) {
+		runes := utf8.RuneCountInString(piece)
+		first, _ := utf8.DecodeRuneInString(piece)
+		if first == ' ' && runes > 1 {
+			runes--
+			first, _ = utf8.DecodeRuneInString(piece[1:])
+		}
+		switch {
+		case unicode.IsLetter(first) && !isASCII(piece):
+			tokens += runes
+		case unicode.IsLetter(first) && runes > 6:
+			tokens += (runes + 3) / 4
+		case unicode.IsPunct(first) || unicode.IsSymbol(first):
+			tokens += (runes + 1) / 2
+		default:
+			tokens++
+		}
+	}
+	return tokens
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// ContextWindows are the input tokens common models accept, found by model name like prices.
+var ContextWindows = map[string]int{
+	"gpt-4o":            128000,
+	"gpt-4o-mini":       128000,
+	"gpt-4.1":           1047576,
+	"gpt-4.1-mini":      1047576,
+	"gpt-4.1-nano":      1047576,
+	"o3-mini":           200000,
+	"claude-sonnet-4-5": 200000,
+	"claude-opus-4-1":   200000,
+	"claude-haiku-4-5":  200000,
+}
+
+// ContextWindow returns the input tokens the model accepts, if it is known.
+func ContextWindow(model string) (int, bool) {
+	return lookupModel(model, ContextWindows)
+}
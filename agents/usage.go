@@ -0,0 +1,81 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Usage counts the tokens of AI calls and what they are estimated to cost in US dollars.
+type Usage struct {
+	Calls            int     `json:"calls,omitempty" yaml:"calls,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens,omitempty" yaml:"prompt_tokens,omitempty"`
+	CompletionTokens int     `json:"completion_tokens,omitempty" yaml:"completion_tokens,omitempty"`
+	TotalTokens      int     `json:"total_tokens,omitempty" yaml:"total_tokens,omitempty"`
+	Cost             float64 `json:"cost,omitempty" yaml:"cost,omitempty"` // zero when the model has no price
+}
+
+// Add returns the sum of both usages.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		Calls:            u.Calls + other.Calls,
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+		Cost:             u.Cost + other.Cost,
+	}
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("%d calls, %d tokens (%d prompt, %d completion), $%.4f",
+		u.Calls, u.TotalTokens, u.PromptTokens, u.CompletionTokens, u.Cost)
+}
+
+// Price is what a model charges in US dollars per million tokens.
+type Price struct {
+	Input  float64 `yaml:"input" json:"input"`   // per million prompt tokens
+	Output float64 `yaml:"output" json:"output"` // per million completion tokens
+}
+
+// Cost estimates the cost of the tokens counted in u.
+func (p Price) Cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*p.Input + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// DefaultPrices are list prices for common models.
+// Override or extend them with the prices section of a spec.
+var DefaultPrices = map[string]Price{
+	"gpt-4o":            {Input: 2.50, Output: 10.00},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+	"gpt-4.1":           {Input: 2.00, Output: 8.00},
+	"gpt-4.1-mini":      {Input: 0.40, Output: 1.60},
+	"gpt-4.1-nano":      {Input: 0.10, Output: 0.40},
+	"o3-mini":           {Input: 1.10, Output: 4.40},
+	"claude-sonnet-4-5": {Input: 3.00, Output: 15.00},
+	"claude-opus-4-1":   {Input: 15.00, Output: 75.00},
+	"claude-haiku-4-5":  {Input: 1.00, Output: 5.00},
+}
+
+// PriceFor finds the model's price in prices and then in DefaultPrices.
+// A dated model name such as gpt-4o-2024-08-06 uses the price of the longest model name it starts with;
+// prices wins over DefaultPrices for names of the same length.
+func PriceFor(prices map[string]Price, model string) (Price, bool) {
+	return lookupModel(model, prices, DefaultPrices)
+}
+
+// lookupModel finds the model in the tables by its exact name, then by the longest model name it starts with.
+// Earlier tables win over later ones for names of the same length.
+func lookupModel[T any](model string, tables ...map[string]T) (T, bool) {
+	best := ""
+	var found T
+	for _, table := range tables {
+		if value, ok := table[model]; ok {
+			return value, true
+		}
+		for name, value := range table {
+			if len(name) > len(best) && strings.HasPrefix(model, name+"-") {
+				best, found = name, value
+			}
+		}
+	}
+	return found, best != ""
+}
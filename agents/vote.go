@@ -0,0 +1,59 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Voting strategies that choose one answer from the samples of a prompt agent.
+const (
+	VoteMajority  = "majority"  // the most common answer; the default
+	VoteUnanimous = "unanimous" // every sample must give the same answer
+	VoteJudge     = "judge"     // another agent chooses the answer
+)
+
+// VotePolicy chooses the answer of an agent that samples its prompt.
+// In yaml it is majority, unanimous or judge:<agent>.
+//
+//	agents:
+//	  triage_decision:
+//	    samples: 5
+//	    vote: judge:senior_triage
+type VotePolicy struct {
+	Strategy string
+	Agent    string // the agent that judges the answers
+}
+
+func (p *VotePolicy) UnmarshalYAML(node *yaml.Node) error {
+	policy, err := ParseVotePolicy(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*p = *policy
+	return nil
+}
+
+func (p *VotePolicy) MarshalYAML() (any, error) {
+	return p.String(), nil
+}
+
+func (p *VotePolicy) String() string {
+	if p.Strategy == VoteJudge {
+		return VoteJudge + ":" + p.Agent
+	}
+	return p.Strategy
+}
+
+// ParseVotePolicy reads a vote setting.
+func ParseVotePolicy(value string) (*VotePolicy, error) {
+	switch value {
+	case VoteMajority, VoteUnanimous:
+		return &VotePolicy{Strategy: value}, nil
+	}
+	if agent, ok := strings.CutPrefix(value, VoteJudge+":"); ok && agent != "" {
+		return &VotePolicy{Strategy: VoteJudge, Agent: agent}, nil
+	}
+	return nil, fmt.Errorf("vote must be majority, unanimous or judge:<agent>, got %q", value)
+}
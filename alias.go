@@ -0,0 +1,68 @@
+package agencia
+
+import (
+	"fmt"
+
+	"github.com/robbyriverside/agencia/agents"
+)
+
+// lookupAlias finds the named agent.  An alias is found as the agent it runs, at the end of any
+// chain of aliases, under the settings the aliases override; target names that agent and is empty
+// for an agent that is not an alias.  An alias in a library runs its library's agent first.
+func (r *Registry) lookupAlias(name string) (agent *agents.Agent, target string, err error) {
+	agent, err = r.LookupAgent(name)
+	if err != nil || agent.Alias == "" {
+		return agent, "", err
+	}
+	chain := []*agents.Agent{}
+	seen := map[string]bool{name: true}
+	target = name
+	for agent.Alias != "" {
+		chain = append(chain, agent)
+		target = r.resolveIn(libraryOf(target), agent.Alias)
+		if seen[target] {
+			return nil, "", fmt.Errorf("alias %s loops back to %s", name, target)
+		}
+		seen[target] = true
+		if agent, err = r.LookupAgent(target); err != nil {
+			return nil, "", fmt.Errorf("alias %s: %w", name, err)
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		agent = aliased(chain[i], agent)
+	}
+	return agent, target, nil
+}
+
+// aliased returns the target as it runs under the alias: named for the alias, so its facts and
+// requests are the alias's, and with the alias's description, inputs, facts, role, model settings,
+// fallbacks and timeout wherever it sets them.  Everything else is the target's.
+func aliased(alias, target *agents.Agent) *agents.Agent {
+	agent := *target
+	agent.Name = alias.Name
+	agent.Alias = ""
+	if alias.Description != "" {
+		agent.Description = alias.Description
+	}
+	if alias.Inputs != nil {
+		agent.Inputs = alias.Inputs
+	}
+	if alias.Facts != nil {
+		agent.Facts = alias.Facts
+	}
+	if alias.Role != "" {
+		agent.Role = alias.Role
+	}
+	params := target.ModelParams
+	if alias.Provider != "" && alias.Provider != params.Provider {
+		params.Model = "" // the alias's provider uses its own default model
+	}
+	agent.ModelParams = alias.ModelParams.WithDefaults(params)
+	if alias.Fallback != nil {
+		agent.Fallback = alias.Fallback
+	}
+	if alias.Timeout != 0 {
+		agent.Timeout = alias.Timeout
+	}
+	return &agent
+}
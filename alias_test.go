@@ -0,0 +1,126 @@
+package agencia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const aliasSpec = `
+roles:
+  tower: You are a control tower talking to pilots over the radio.
+agents:
+  greeting:
+    description: Greet the user
+    inputs:
+      name:
+        description: The user's name
+    model: gpt-4o
+    prompt: 'Say hello to {{ .Input "name" }}.'
+  greet:
+    description: Greet the pilot
+    inputs:
+      name:
+        description: The pilot's callsign
+    role: tower
+    temperature: 0.1
+    alias: greeting
+  welcome:
+    alias: greet
+  radio:
+    description: Talk to a pilot
+    listeners: [greet]
+    prompt: '{{ .Input }}'
+`
+
+func TestAlias_Overrides(t *testing.T) {
+	reg, err := NewRegistry(aliasSpec)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{`{"name": "Maverick"}`, "Hello Maverick"}}
+	reg.UseProvider(provider)
+
+	out, card := reg.Run(context.Background(), "welcome", "This is Maverick, requesting a flyby")
+	assert.Equal(t, "Hello Maverick", out)
+	require.Len(t, provider.requests, 2)
+
+	inputs := provider.requests[0]
+	assert.Equal(t, agents.PurposeInputs, inputs.Purpose)
+	assert.Contains(t, inputs.Messages[len(inputs.Messages)-1].Content, "The pilot's callsign", "the alias's inputs are extracted")
+
+	prompt := provider.requests[1]
+	assert.Equal(t, "welcome", prompt.Agent, "an alias runs under its own name")
+	assert.Equal(t, "gpt-4o", prompt.Model, "settings the alias leaves out are the target's")
+	require.NotNil(t, prompt.Temperature)
+	assert.InDelta(t, 0.1, *prompt.Temperature, 0.001)
+	assert.Equal(t, agents.Message{Role: agents.RoleSystem, Content: "You are a control tower talking to pilots over the radio."}, prompt.Messages[0])
+
+	assert.Equal(t, "welcome", card.AgentName)
+	assert.Equal(t, "greeting", card.AliasOf)
+	assert.Empty(t, card.BranchCards, "an alias runs on its own card")
+	assert.Contains(t, card.String(), "Agent: welcome (alias of greeting)")
+}
+
+func TestAlias_Listener(t *testing.T) {
+	reg, err := NewRegistry(aliasSpec)
+	require.NoError(t, err)
+
+	tools, err := NewRun(reg, nil).listenerTools(reg.Agents["radio"])
+	require.NoError(t, err)
+	require.Len(t, tools, 1)
+	assert.Equal(t, "greet", tools[0].Name)
+	assert.Equal(t, "Greet the pilot", tools[0].Description)
+	assert.Contains(t, tools[0].Parameters["properties"], "name")
+}
+
+func TestAlias_Loop(t *testing.T) {
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{Name: "ping", Alias: "pong"})
+	reg.RegisterAgent(&agents.Agent{Name: "pong", Alias: "ping"})
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "ping", "hi")
+	require.Error(t, res.Error)
+	assert.Equal(t, "alias ping loops back to ping", res.Error.Error())
+}
+
+func TestAlias_Identity(t *testing.T) {
+	reg, err := NewRegistry(`
+agents:
+  forecast:
+    description: Forecast the weather
+    model: gpt-4o
+    fallback:
+      - model: gpt-4o-mini
+    facts:
+      city:
+        description: The city of the forecast
+    prompt: "{{ .Input }}"
+  weather:
+    description: Tell the weather
+    alias: forecast
+    timeout: 50ms
+    fallback:
+      - model: gpt-4.1-nano
+`)
+	require.NoError(t, err)
+	provider := &modelProvider{
+		answers: map[string]*agents.ChatResponse{"gpt-4.1-nano": {Content: `{"city": "Oslo"}`}},
+		slow:    map[string]bool{"gpt-4o": true},
+	}
+	reg.UseProvider(provider)
+	chat := NewChat("weather")
+
+	run := NewRun(reg, chat)
+	res := run.CallAgent(context.Background(), "weather", "Will it rain in Oslo?")
+	require.NoError(t, res.Error)
+	assert.Equal(t, `{"city": "Oslo"}`, res.Output)
+	assert.Equal(t, "gpt-4.1-nano", run.Card.Model, "the alias's fallbacks replace the target's after the alias's timeout")
+	for _, req := range provider.requests {
+		assert.Equal(t, "weather", req.Agent)
+		assert.NotEqual(t, "gpt-4o-mini", req.Model)
+	}
+	assert.Equal(t, "Oslo", chat.Fact("weather.city"), "facts are kept under the alias")
+	assert.Nil(t, chat.Fact("forecast.city"))
+}
@@ -0,0 +1,163 @@
+package agencia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// anthropicServer stands in for the Messages API.
+// Each request is answered by reply, which names a recorded payload in testdata/anthropic.
+type anthropicServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []map[string]any
+	headers  []http.Header
+}
+
+func newAnthropicServer(t *testing.T, status int, reply func(n int, body map[string]any) string) *anthropicServer {
+	t.Helper()
+	s := &anthropicServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, body)
+		s.headers = append(s.headers, r.Header.Clone())
+		n := len(s.requests)
+		s.mu.Unlock()
+
+		data, err := os.ReadFile(filepath.Join("testdata", "anthropic", reply(n, body)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func anthropicRegistry(t *testing.T, server *anthropicServer) *Registry {
+	t.Helper()
+	reg := &Registry{
+		Defaults: agents.ModelParams{Provider: "claude"},
+		Profiles: map[string]*agents.ProviderConfig{
+			"claude": {Type: agents.ProviderTypeAnthropic, BaseURL: server.URL + "/v1", APIKeyEnv: "TEST_ANTHROPIC_KEY"},
+		},
+	}
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "greet",
+		Description: "Generates a greeting message given a person's name",
+		Inputs: map[string]*agents.Argument{
+			"personName": {Description: "The name of the person to greet.", Required: true},
+		},
+		Template: `Hello, {{ .Input "personName" }}!`,
+	})
+	reg.RegisterAgent(&agents.Agent{
+		Name:      "tryme",
+		Prompt:    "Say hello to {{ .Input }}.",
+		Listeners: []string{"greet"},
+	})
+	return reg
+}
+
+func TestAnthropicProvider_ToolUse(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	replay := []string{"01_tool_use.json", "02_extract_inputs.json", "03_end_turn.json"}
+	server := newAnthropicServer(t, http.StatusOK, func(n int, _ map[string]any) string {
+		return replay[n-1]
+	})
+	reg := anthropicRegistry(t, server)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "tryme", "Alice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "The greeting agent says: Hello, Alice!", res.Output)
+	require.Len(t, server.requests, 3, "prompt, listener input extraction, continuation")
+
+	header := server.headers[0]
+	assert.Equal(t, "sk-ant-test", header.Get("x-api-key"))
+	assert.Equal(t, agents.DefaultAnthropicVersion, header.Get("anthropic-version"))
+
+	first := server.requests[0]
+	assert.Equal(t, agents.DefaultAnthropicModel, first["model"])
+	assert.EqualValues(t, agents.DefaultAnthropicMaxTokens, first["max_tokens"])
+	tools := first["tools"].([]any)
+	require.Len(t, tools, 1)
+	tool := tools[0].(map[string]any)
+	assert.Equal(t, "greet", tool["name"])
+	schema := tool["input_schema"].(map[string]any)
+	assert.Equal(t, "object", schema["type"])
+	assert.Contains(t, schema["properties"], "personName")
+	assert.Equal(t, []any{"personName"}, schema["required"])
+
+	extraction := server.requests[1]
+	assert.Nil(t, extraction["tools"], "extraction calls never offer tools")
+
+	// The continuation replays the tool_use turn and answers it with a tool_result block.
+	messages := server.requests[2]["messages"].([]any)
+	require.Len(t, messages, 3)
+	assistant := messages[1].(map[string]any)
+	assert.Equal(t, "assistant", assistant["role"])
+	content := assistant["content"].([]any)
+	require.Len(t, content, 2, "the text and the tool_use of the turn")
+	assert.Equal(t, "I'll greet Alice for you.", content[0].(map[string]any)["text"])
+	toolUse := content[1].(map[string]any)
+	assert.Equal(t, "tool_use", toolUse["type"])
+	assert.Equal(t, "toolu_01A09q90qw90lq917835lq9", toolUse["id"])
+	assert.Equal(t, map[string]any{"personName": "Alice"}, toolUse["input"])
+	result := messages[2].(map[string]any)
+	assert.Equal(t, "user", result["role"])
+	toolResult := result["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_result", toolResult["type"])
+	assert.Equal(t, "toolu_01A09q90qw90lq917835lq9", toolResult["tool_use_id"])
+	assert.Equal(t, "Hello, Alice!", toolResult["content"])
+}
+
+func TestAnthropicProvider_DepthLimit(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	server := newAnthropicServer(t, http.StatusOK, func(_ int, body map[string]any) string {
+		if body["tools"] == nil {
+			return "02_extract_inputs.json"
+		}
+		return "01_tool_use.json"
+	})
+	reg := anthropicRegistry(t, server)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "tryme", "Alice")
+	require.Error(t, res.Error)
+	assert.Contains(t, res.Error.Error(), "too many recursive tool call levels")
+}
+
+func TestAnthropicProvider_Error(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	server := newAnthropicServer(t, 529, func(int, map[string]any) string {
+		return "error_overloaded.json"
+	})
+	reg := anthropicRegistry(t, server)
+	reg.Profiles["claude"].Retry = &agents.RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "tryme", "Alice")
+	require.Error(t, res.Error)
+	assert.Contains(t, res.Error.Error(), "status 529: overloaded_error: Overloaded")
+	assert.Len(t, server.requests, 2, "overloaded responses are retried")
+}
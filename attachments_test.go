@@ -0,0 +1,123 @@
+package agencia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	screenshot = agents.Attachment{Name: "screen.png", MediaType: "image/png", Data: []byte{1, 2, 3}}
+	notes      = agents.Attachment{Name: "notes.txt", MediaType: "text/plain", Data: []byte("error 42")}
+)
+
+func TestAttachments_RunJSON(t *testing.T) {
+	server, _, bodies := chatCompletionServer(t, "Restart the router.")
+	provider, err := agents.NewProvider(&agents.ProviderConfig{BaseURL: server.URL + "/v1"})
+	require.NoError(t, err)
+	agents.SetDefaultProvider(provider) // specs sent to the server use the server's providers
+	defer agents.SetDefaultProvider(nil)
+	spec := `
+agents:
+  support:
+    alias: answer
+  answer:
+    description: Answer with the customer's screenshots
+    model: gpt-4o
+    prompt: 'Attached: {{ range .Attachments }}{{ .Name }} {{ end }}- {{ .Input }}'
+`
+	body, err := json.Marshal(runRequest{Spec: spec, Agent: "support", Input: "It broke", Attachments: []agents.Attachment{screenshot, notes}})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	sent := <-bodies
+	messages := sent["messages"].([]any)
+	assert.Equal(t, []any{
+		map[string]any{"type": "text", "text": "Attached: screen.png notes.txt - It broke"},
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,AQID"}},
+		map[string]any{"type": "text", "text": "Attached file notes.txt (text/plain):\nerror 42"},
+	}, messages[0].(map[string]any)["content"], "an alias passes the attachments to its agent")
+
+	var resp struct {
+		Output string `json:"output"`
+		Card   struct {
+			AliasOf     string
+			Attachments []agents.Attachment
+		} `json:"card"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "Restart the router.", resp.Output)
+	assert.Equal(t, "answer", resp.Card.AliasOf)
+	refs := resp.Card.Attachments
+	require.Len(t, refs, 2)
+	assert.Nil(t, refs[0].Data, "cards keep attachments by reference")
+	assert.Equal(t, "sha256:039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81", refs[0].Digest)
+	assert.Equal(t, 3, refs[0].Size)
+
+	body, err = json.Marshal(runRequest{Spec: spec, Agent: "support", Input: "It broke", Attachments: []agents.Attachment{{Name: "empty.png", MediaType: "image/png"}}})
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "attachment empty.png (image/png) has no url or data")
+}
+
+func TestAttachments_OnlyTheStartAgentSeesThem(t *testing.T) {
+	reg, err := NewRegistry(`
+agents:
+  triage:
+    description: Triage the ticket
+    model: o3-mini
+    prompt: '{{ .Input }} {{ .Get "lookup" }}'
+  lookup:
+    description: Look up the customer
+    prompt: "Who sent {{ .Input }}?"
+`)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{"Ann", "Reboot it."}}
+	reg.UseProvider(provider)
+
+	out, card := reg.RunAttached(context.Background(), "triage", "It broke", []agents.Attachment{screenshot, notes}, nil)
+	assert.Equal(t, "Reboot it.", out)
+	assert.Empty(t, provider.requests[0].Messages[0].Attachments, "agents called from a template do not get the attachments")
+	assert.Equal(t, []agents.Attachment{notes}, provider.requests[1].Messages[0].Attachments,
+		"images are not sent to models without vision")
+	assert.Equal(t, "[ATTACH] o3-mini cannot see images; screen.png (image/png) not sent", card.Logs[0].Message)
+	assert.Contains(t, card.String(), "Attachments:\n  screen.png (image/png)\n  notes.txt (text/plain)")
+	assert.Empty(t, card.BranchCards[0].Attachments)
+}
+
+func TestAttachments_Anthropic(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	server := newAnthropicServer(t, http.StatusOK, func(int, map[string]any) string { return "03_end_turn.json" })
+	reg := anthropicRegistry(t, server)
+	report := agents.Attachment{Name: "report.pdf", MediaType: "application/pdf", URL: "https://example.com/report.pdf"}
+
+	_, card := reg.RunAttached(context.Background(), "tryme", "Alice", []agents.Attachment{screenshot, report}, nil)
+	require.NoError(t, card.Error)
+	messages := server.requests[0]["messages"].([]any)
+	assert.Equal(t, []any{
+		map[string]any{"type": "text", "text": "Say hello to Alice."},
+		map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "AQID"}},
+		map[string]any{"type": "document", "source": map[string]any{"type": "url", "url": "https://example.com/report.pdf"}},
+	}, messages[0].(map[string]any)["content"])
+}
+
+func TestReadChatMessage(t *testing.T) {
+	message, err := readChatMessage([]byte(`{"input": "See this", "attachments": [{"name": "screen.png", "media_type": "image/png", "data": "AQID"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, ChatMessage{Input: "See this", Attachments: []agents.Attachment{screenshot}}, message)
+
+	_, err = readChatMessage([]byte(`{"input": "See this", "attachments": [{"name": "screen.png"}]}`))
+	assert.EqualError(t, err, "invalid chat message: attachment screen.png has no media type")
+}
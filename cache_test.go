@@ -0,0 +1,158 @@
+package agencia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cacheSpec = `
+%s
+agents:
+  classify:
+    description: Classify the request
+    cache: 1h
+    prompt: "Is this a question? {{ .Input }}"
+  fresh:
+    description: Classify the request without caching
+    prompt: "Is this a question? {{ .Input }}"
+  flow:
+    template: '{{ .Get "classify" }} {{ .Get "classify" }}'
+`
+
+func TestCache_Memory(t *testing.T) {
+	reg, err := NewRegistry(fmt.Sprintf(cacheSpec, ""))
+	require.NoError(t, err)
+	provider := &flakyProvider{}
+	reg.UseProvider(provider)
+	ctx := context.Background()
+
+	out, card := reg.Run(ctx, "classify", "Where am I?")
+	assert.Equal(t, "ok", out)
+	assert.False(t, card.Cached)
+
+	out, card = reg.Run(ctx, "classify", "Where am I?")
+	assert.Equal(t, "ok", out)
+	assert.True(t, card.Cached)
+	require.Len(t, card.Logs, 1)
+	assert.Equal(t, "[CACHE] prompt response for classify from cache", card.Logs[0].Message)
+	assert.EqualValues(t, 1, provider.calls.Load())
+
+	reg.Run(ctx, "classify", "Who are you?")
+	assert.EqualValues(t, 2, provider.calls.Load(), "a different prompt is a different key")
+
+	reg.Run(ctx, "fresh", "Where am I?")
+	reg.Run(ctx, "fresh", "Where am I?")
+	assert.EqualValues(t, 4, provider.calls.Load(), "agents without a cache policy always call the model")
+
+	out, card = reg.Run(ctx, "flow", "Where am I?")
+	assert.Equal(t, "ok ok", out)
+	require.Len(t, card.BranchCards, 2)
+	assert.True(t, card.BranchCards[0].Cached)
+	assert.True(t, card.BranchCards[1].Cached)
+	assert.EqualValues(t, 4, provider.calls.Load(), "sub-agents in a template tree reuse the cached response")
+}
+
+func TestCache_Disk(t *testing.T) {
+	dir := t.TempDir()
+	spec := fmt.Sprintf(cacheSpec, fmt.Sprintf("cache:\n  backend: disk\n  dir: %s\n", dir))
+	provider := &flakyProvider{}
+	for range 2 {
+		reg, err := NewRegistry(spec)
+		require.NoError(t, err)
+		reg.UseProvider(provider)
+		out, _ := reg.Run(context.Background(), "classify", "Where am I?")
+		assert.Equal(t, "ok", out)
+	}
+	assert.EqualValues(t, 1, provider.calls.Load(), "the disk cache outlives the registry")
+}
+
+func TestCache_Expires(t *testing.T) {
+	for _, cache := range []agents.ResponseCache{agents.NewMemoryCache(), agents.NewDiskCache(t.TempDir())} {
+		require.NoError(t, cache.Set("short", &agents.ChatResponse{Content: "soon gone"}, 20*time.Millisecond))
+		require.NoError(t, cache.Set("forever", &agents.ChatResponse{Content: "kept"}, 0))
+		resp, ok := cache.Get("short")
+		require.True(t, ok)
+		assert.Equal(t, "soon gone", resp.Content)
+
+		time.Sleep(30 * time.Millisecond)
+		_, ok = cache.Get("short")
+		assert.False(t, ok, "%T keeps an expired response", cache)
+		resp, ok = cache.Get("forever")
+		require.True(t, ok)
+		assert.Equal(t, "kept", resp.Content)
+	}
+}
+
+func TestCache_Key(t *testing.T) {
+	temperature := float32(0.2)
+	base := func() *agents.ChatRequest {
+		return &agents.ChatRequest{
+			Model:       "gpt-4o",
+			Temperature: &temperature,
+			Messages:    []agents.Message{{Role: agents.RoleUser, Content: "Is this a question?"}},
+			Agent:       "classify",
+			Purpose:     agents.PurposePrompt,
+		}
+	}
+	key := agents.CacheKey(base())
+
+	same := base()
+	same.Agent = "other"
+	same.Input = "Where am I?"
+	assert.Equal(t, key, agents.CacheKey(same), "request metadata is not part of the key")
+
+	changes := map[string]func(*agents.ChatRequest){
+		"model":       func(r *agents.ChatRequest) { r.Model = "gpt-4o-mini" },
+		"temperature": func(r *agents.ChatRequest) { t := float32(1); r.Temperature = &t },
+		"prompt":      func(r *agents.ChatRequest) { r.Messages[0].Content = "Is this a statement?" },
+		"tools":       func(r *agents.ChatRequest) { r.Tools = []agents.Tool{{Name: "greet"}} },
+		"provider":    func(r *agents.ChatRequest) { r.Provider = "anthropic" },
+	}
+	for name, change := range changes {
+		req := base()
+		change(req)
+		assert.NotEqual(t, key, agents.CacheKey(req), "changing the %s changes the key", name)
+	}
+}
+
+func TestLintSpecFile_Cache(t *testing.T) {
+	spec := `
+cache:
+  backend: redis
+agents:
+  classify:
+    description: Classify the request
+    cache: forever
+    prompt: "Is this a question? {{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 3: Unknown cache backend 'redis'. Use memory or disk.")
+	assert.Contains(t, result.Errors, "Problem: Line 7: Agent 'classify' cache must be true, false or a duration like 10m, got 'forever'.")
+}
+
+func TestCache_RemoteSpecCannotUseDisk(t *testing.T) {
+	dir := t.TempDir()
+	spec := fmt.Sprintf(cacheSpec, fmt.Sprintf("cache:\n  backend: disk\n  dir: %s\n", dir))
+	body, err := json.Marshal(runRequest{Spec: spec, Agent: "classify", Input: "Is it raining?"})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), errRemoteCache.Error())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
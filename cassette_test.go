@@ -0,0 +1,165 @@
+package agencia
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// useCassette answers the registry's AI calls from testdata/cassettes/<test name>.yaml.
+// The committed cassettes are synthetic, so the TestPlumbing tests that use them check the
+// engine's handling of scripted answers, not a live provider.
+// Set AGENCIA_RECORD=1 to run the test against the live provider and rewrite its cassette,
+// or AGENCIA_RECORD=mock to rewrite it from the scripted answers in
+// testdata/cassettes/mocks/<test name>.yaml, which is how the committed cassettes are made.
+// A replayed test fails when a request was not recorded or a recorded one goes unused.
+func useCassette(t *testing.T, reg *Registry) {
+	t.Helper()
+	file := filepath.Join("testdata", "cassettes", t.Name()+".yaml")
+	if record := os.Getenv("AGENCIA_RECORD"); record != "" {
+		_ = godotenv.Load()
+		if record == "mock" {
+			reg.UseProvider(cassetteScript(t))
+		}
+		cassette := agents.NewCassette(file)
+		reg.Record(cassette)
+		t.Cleanup(func() {
+			require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+			require.NoError(t, cassette.Save())
+		})
+		return
+	}
+	cassette, err := agents.LoadCassette(file)
+	require.NoError(t, err)
+	reg.UseProvider(cassette)
+	t.Cleanup(func() {
+		assert.Empty(t, cassette.Unused(), "recorded interactions were never requested")
+	})
+}
+
+// cassetteScript reads the mock answers a cassette is recorded from.
+// A test that makes no AI calls needs no script.
+func cassetteScript(t *testing.T) agents.Provider {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "cassettes", "mocks", t.Name()+".yaml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = []byte("responses: {}"), nil
+	}
+	require.NoError(t, err)
+	mock, err := agents.NewMockProvider(data)
+	require.NoError(t, err)
+	return mock
+}
+
+func TestCassette_RecordAndReplay(t *testing.T) {
+	newRegistry := func() *Registry {
+		reg := &Registry{}
+		reg.RegisterAgent(&agents.Agent{
+			Name:        "greet",
+			Description: "Generates a greeting message given a person's name",
+			Inputs: map[string]*agents.Argument{
+				"personName": {Description: "The name of the person to greet."},
+			},
+			Template: `Hello, {{ .Input "personName" }}!`,
+		})
+		reg.RegisterAgent(&agents.Agent{
+			Name:      "tryme",
+			Prompt:    "Say hello to {{ .Input }}.",
+			Listeners: []string{"greet"},
+		})
+		return reg
+	}
+	mock, err := agents.NewMockProvider([]byte(`
+responses:
+  tryme:
+    tools:
+      - name: greet
+        arguments: {personName: Alice}
+    content: "Tool said: {{ .ToolResults }}"
+  greet:
+    inputs: "personName: Alice"
+`))
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "greet.yaml")
+
+	recorded := newRegistry()
+	recorded.SetProvider(mock)
+	cassette := agents.NewCassette(file)
+	recorded.Record(cassette)
+	res := NewRun(recorded, nil).CallAgent(context.Background(), "tryme", "Alice")
+	require.NoError(t, res.Error)
+	require.NoError(t, cassette.Save())
+
+	purposes := []string{}
+	for _, in := range cassette.Interactions {
+		purposes = append(purposes, in.Request.Purpose)
+	}
+	assert.Equal(t, []string{agents.PurposePrompt, agents.PurposeInputs, agents.PurposeTools}, purposes,
+		"the prompt, the listener's input extraction and the continuation are recorded")
+
+	replay, err := agents.LoadCassette(file)
+	require.NoError(t, err)
+	replayed := newRegistry()
+	replayed.UseProvider(replay)
+	again := NewRun(replayed, nil).CallAgent(context.Background(), "tryme", "Alice")
+	require.NoError(t, again.Error)
+	assert.Equal(t, res.Output, again.Output)
+	assert.Empty(t, replay.Unused())
+}
+
+func TestCassette_ReplayMismatch(t *testing.T) {
+	mock, err := agents.NewMockProvider([]byte(`
+responses:
+  greet: "Hello, {{ .Input }}!"
+`))
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "greet.yaml")
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{Name: "greet", Prompt: "Say hello to {{ .Input }}."})
+	reg.SetProvider(mock)
+	cassette := agents.NewCassette(file)
+	reg.Record(cassette)
+	first := NewRun(reg, nil).CallAgent(context.Background(), "greet", "Alice")
+	assert.Equal(t, "Hello, Alice!", first.Output)
+	require.NoError(t, cassette.Save())
+
+	replay, err := agents.LoadCassette(file)
+	require.NoError(t, err)
+	reg.Record(nil)
+	reg.UseProvider(replay)
+	res := NewRun(reg, nil).CallAgent(context.Background(), "greet", "Bob")
+	require.Error(t, res.Error)
+	assert.Contains(t, res.Error.Error(), "no recorded chat interaction matches request")
+	assert.Contains(t, res.Error.Error(), "Say hello to Bob.")
+	assert.Len(t, replay.Unused(), 1)
+}
+
+func TestCassette_MissingResponse(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "truncated.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+interactions:
+  - request:
+      agent: greet
+      purpose: prompt
+      messages:
+        - role: user
+          content: Say hello to Alice.
+`), 0o644))
+	cassette, err := agents.LoadCassette(file)
+	require.NoError(t, err)
+
+	var req *agents.ChatRequest
+	require.NoError(t, yaml.Unmarshal([]byte("agent: greet\npurpose: prompt\nmessages:\n  - role: user\n    content: Say hello to Alice.\n"), &req))
+	_, err = cassette.Chat(context.Background(), req)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "chat interaction for agent greet has neither a response nor an error")
+}
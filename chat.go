@@ -4,14 +4,16 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 
 	"encoding/json"
 
 	"github.com/gorilla/websocket"
 	"github.com/robbyriverside/agencia/agents"
-	"gopkg.in/yaml.v3"
 )
 
 var defaultChat *Chat
@@ -24,9 +26,61 @@ type Chat struct {
 	TaggedFacts        map[string][]string // tag => list of agent.fact keys
 	Registry           *Registry
 	Cards              []*TraceCard
+	Usage              agents.Usage // tokens and cost of every AI call made in the chat
+	Transcript         []Turn       // every exchange of the chat, oldest first
+	Notices            []JobNotice  // the jobs of the chat that have ended, oldest first
+	onJobEnd           func(JobNotice)
+	mu                 sync.Mutex // guards facts, usage and notices for tool calls and jobs that run concurrently
+}
+
+// Turn is one exchange of a chat: the user's message and the start agent's reply.
+type Turn struct {
+	Agent  string
+	Input  string
+	Output string
+}
+
+// AddTurn adds an exchange to the chat's transcript.
+func (c *Chat) AddTurn(agent, input, output string) {
+	c.Transcript = append(c.Transcript, Turn{Agent: agent, Input: input, Output: output})
+}
+
+// History returns the most recent turns allowed by the policy as user and assistant messages.
+// Turns past the policy's count are dropped, then the oldest turns until the rest fit its token budget
+// as counted by the model's tokenizer.
+// It also returns how many turns were dropped.
+func (c *Chat) History(policy *agents.HistoryPolicy, model string) ([]agents.Message, int) {
+	if c == nil || policy == nil {
+		return nil, 0
+	}
+	turns := c.Transcript
+	if policy.Turns > 0 && len(turns) > policy.Turns {
+		turns = turns[len(turns)-policy.Turns:]
+	}
+	if policy.Tokens > 0 {
+		tokenizer := agents.TokenizerFor(model)
+		tokens := 0
+		for _, turn := range turns {
+			tokens += tokenizer.CountTokens(turn.Input) + tokenizer.CountTokens(turn.Output)
+		}
+		for len(turns) > 0 && tokens > policy.Tokens {
+			tokens -= tokenizer.CountTokens(turns[0].Input) + tokenizer.CountTokens(turns[0].Output)
+			turns = turns[1:]
+		}
+	}
+	messages := make([]agents.Message, 0, 2*len(turns))
+	for _, turn := range turns {
+		messages = append(messages,
+			agents.Message{Role: agents.RoleUser, Content: turn.Input},
+			agents.Message{Role: agents.RoleAssistant, Content: turn.Output},
+		)
+	}
+	return messages, len(c.Transcript) - len(turns)
 }
 
 func (c *Chat) SetStartAgent(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.StartAgent = name
 }
 
@@ -49,13 +103,56 @@ func NewChat(agent string) *Chat {
 }
 
 func (c *Chat) Fact(name string) any {
+	v, _ := c.lookupFact(name)
+	return v
+}
+
+func (c *Chat) lookupFact(name string) (any, bool) {
 	if c == nil {
-		return nil
+		return nil, false
 	}
-	if v, ok := c.Facts[name]; ok {
-		return v
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.Facts[name]
+	return v, ok
+}
+
+// setFact stores a fact and adds its key to each tag.
+func (c *Chat) setFact(key string, value any, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Facts[key] = value
+	for _, tag := range tags {
+		c.TaggedFacts[tag] = append(c.TaggedFacts[tag], key)
+	}
+}
+
+func (c *Chat) addUsage(usage agents.Usage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Usage = c.Usage.Add(usage)
+}
+
+// OnJobEnd sets the function told when a job started in the chat ends.
+// It is called from the job's goroutine.
+func (c *Chat) OnJobEnd(fn func(JobNotice)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onJobEnd = fn
+}
+
+// notifyJob adds the notice of an ended job to the chat and passes it on.
+func (c *Chat) notifyJob(notice JobNotice) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.Notices = append(c.Notices, notice)
+	fn := c.onJobEnd
+	c.mu.Unlock()
+	if fn != nil {
+		fn(notice)
 	}
-	return nil
 }
 
 func (c *Chat) NewRegistry(spec string) (*Registry, error) {
@@ -70,8 +167,10 @@ func (c *Chat) NewRegistry(spec string) (*Registry, error) {
 
 func ChatWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	type ChatInitRequest struct {
-		Agent string `json:"agent"`
-		Spec  string `json:"spec"` // optionally store or use this
+		Agent  string `json:"agent"`
+		Spec   string `json:"spec"`   // optionally store or use this
+		Stream bool   `json:"stream"` // reply with ChatStreamMessage deltas instead of plain text
+		JSON   bool   `json:"json"`   // messages are ChatMessage objects instead of plain text
 	}
 
 	upgrader := websocket.Upgrader{
@@ -104,6 +203,11 @@ func ChatWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		defaultChat.StartAgent = initReq.Agent
 	}
+	if err := checkRemoteSpec([]byte(initReq.Spec)); err != nil {
+		log.Println("Failed to create registry:", err)
+		conn.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+		return
+	}
 	registry, err := NewRegistry(initReq.Spec)
 	if err != nil {
 		log.Println("Failed to create registry:", err)
@@ -112,6 +216,31 @@ func ChatWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defaultChat.Registry = registry
 
+	// Job notices are written from the job's goroutine, so every write takes the lock.
+	var writeMu sync.Mutex
+	writeText := func(text string) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.TextMessage, []byte(text))
+	}
+	writeJSON := func(msg ChatStreamMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(msg)
+	}
+	defaultChat.OnJobEnd(func(notice JobNotice) {
+		var err error
+		if initReq.Stream {
+			err = writeJSON(ChatStreamMessage{Type: ChatStreamJob, Text: notice.String(), JobID: notice.JobID})
+		} else {
+			err = writeText(notice.String())
+		}
+		if err != nil {
+			log.Println("WebSocket write error:", err)
+		}
+	})
+	defer defaultChat.OnJobEnd(nil)
+
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
@@ -126,19 +255,83 @@ func ChatWebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		// fmt.Printf("Received message for agent '%s': %s\n", defaultChat.Agent, msg)
 
 		// Optionally echo the message back
-		input := string(msg)
+		message := ChatMessage{Input: string(msg)}
+		if initReq.JSON {
+			message, err = readChatMessage(msg)
+			if err != nil {
+				if err := writeText(err.Error()); err != nil {
+					log.Println("WebSocket write error:", err)
+					break
+				}
+				continue
+			}
+		}
+		input := message.Input
 		ctx := context.Background()
 		// run := NewChatRun(registry, defaultChat)
-		resp, _ := registry.Run(ctx, defaultChat.StartAgent, input)
+		if !initReq.Stream {
+			resp, _ := registry.RunAttached(ctx, defaultChat.StartAgent, input, message.Attachments, nil)
+			if err := writeText(resp); err != nil {
+				log.Println("WebSocket write error:", err)
+				conn.Close()
+				break
+			}
+			continue
+		}
 
-		if err := conn.WriteMessage(websocket.TextMessage, []byte(resp)); err != nil {
-			log.Println("WebSocket write error:", err)
+		var writeErr error
+		resp, _ := registry.RunAttached(ctx, defaultChat.StartAgent, input, message.Attachments, func(delta string) {
+			if writeErr == nil {
+				writeErr = writeJSON(ChatStreamMessage{Type: ChatStreamDelta, Text: delta})
+			}
+		})
+		if writeErr == nil {
+			writeErr = writeJSON(ChatStreamMessage{Type: ChatStreamDone, Text: resp})
+		}
+		if writeErr != nil {
+			log.Println("WebSocket write error:", writeErr)
 			conn.Close()
 			break
 		}
 	}
 }
 
+// ChatMessage is a message with attachments, sent over the chat websocket
+// by clients that set json in their init request.
+type ChatMessage struct {
+	Input       string              `json:"input"`
+	Attachments []agents.Attachment `json:"attachments,omitempty"`
+}
+
+// readChatMessage decodes a ChatMessage and checks its attachments.
+func readChatMessage(data []byte) (ChatMessage, error) {
+	var message ChatMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return message, fmt.Errorf("invalid chat message: %w", err)
+	}
+	for _, a := range message.Attachments {
+		if err := a.Validate(); err != nil {
+			return message, fmt.Errorf("invalid chat message: %w", err)
+		}
+	}
+	return message, nil
+}
+
+// Types of ChatStreamMessage.
+const (
+	ChatStreamDelta = "delta" // the next piece of the response
+	ChatStreamDone  = "done"  // the complete response; ends the reply
+	ChatStreamJob   = "job"   // a job of the chat has ended; sent between replies
+)
+
+// ChatStreamMessage is sent over the chat websocket when the client asks to stream.
+// A reply is any number of delta messages followed by one done message.
+type ChatStreamMessage struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	JobID string `json:"job_id,omitempty"` // the job of a job message
+}
+
 // ExtractAgentMemory is called after an agent runs to allow post-processing of input/output for memory storage.
 // Extracts facts using AI and stores them in chat memory.
 func (r *RunContext) ExtractAgentMemory(ctx context.Context, agent *agents.Agent, input, output string) {
@@ -155,7 +348,8 @@ func (r *RunContext) ExtractAgentMemory(ctx context.Context, agent *agents.Agent
 	prompt += "Input:\n" + input + "\n\n"
 	prompt += "Output:\n" + output + "\n\n"
 	prompt += "Facts to extract:\n"
-	for k, arg := range agent.Facts {
+	for _, k := range slices.Sorted(maps.Keys(agent.Facts)) {
+		arg := agent.Facts[k]
 		typ := arg.Type
 		if typ == "" {
 			typ = "string"
@@ -170,24 +364,24 @@ func (r *RunContext) ExtractAgentMemory(ctx context.Context, agent *agents.Agent
 	prompt += "\nRespond ONLY with a valid YAML block and no explanation or markdown."
 
 	// Use agent description and mock function to call AI
-	resp, err := r.CallAI(ctx, &agents.Agent{
+	result, err := r.extractValues(ctx, &agents.Agent{
+		Name:        agent.Name,
 		Description: "Extract structured facts from input and output text.",
-	}, prompt)
+		ModelParams: agent.ModelParams,
+		Fallback:    agent.Fallback,
+		Timeout:     agent.Timeout,
+		Cache:       agent.Cache,
+	}, agents.PurposeMemory, prompt, factsExtraction(agent))
 	if err != nil {
 		log.Printf("[FACTS] AI call failed: %v", err)
 		return
 	}
 
-	// Parse YAML into map
-	result := make(map[string]any)
-	cleanResp := ExtractYAMLFromMarkdown(resp)
-	if err := yaml.Unmarshal([]byte(cleanResp), &result); err != nil {
-		log.Printf("[FACTS] Failed to parse YAML: %v\nAI Output:\n%s", err, resp)
-		return
-	}
-
 	// Store each fact and tag, with checks for missing/empty/null
 	for k, arg := range agent.Facts {
+		if arg.Scope == "local" {
+			continue // local facts stay inside the run or job
+		}
 		key := fmt.Sprintf("%s.%s", agent.Name, k)
 
 		v, ok := result[k]
@@ -200,14 +394,8 @@ func (r *RunContext) ExtractAgentMemory(ctx context.Context, agent *agents.Agent
 			continue
 		}
 
-		c.Facts[key] = v
+		c.setFact(key, v, arg.Tags...)
 		// log.Printf("[FACTS] Stored: %s = %v", key, v)
-
-		if arg.Tags != nil {
-			for _, tag := range arg.Tags {
-				c.TaggedFacts[tag] = append(c.TaggedFacts[tag], key)
-			}
-		}
 	}
 }
 
@@ -2,23 +2,25 @@ package agencia
 
 import (
 	"context"
-	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
-	"github.com/joho/godotenv"
 	"github.com/robbyriverside/agencia/agents"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestProcessAgentMemory(t *testing.T) {
-	// ctx := context.Background()
-	_ = godotenv.Load()
+// useDefaultChat starts a fresh default chat for the test and restores the previous one afterwards.
+func useDefaultChat(t *testing.T, start string) *Chat {
+	t.Helper()
+	saved := defaultChat
+	defaultChat = NewChat(start)
+	t.Cleanup(func() { defaultChat = saved })
+	return defaultChat
+}
 
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
+func TestPlumbing_AgentMemory(t *testing.T) {
 	// Define a simple agent with one fact
 	agent := &agents.Agent{
 		Name: "printer",
@@ -38,6 +40,7 @@ func TestProcessAgentMemory(t *testing.T) {
 			"printer": agent,
 		},
 	}
+	useCassette(t, reg)
 
 	// Create a chat and bind it to the registry
 	chat := NewChat("printer")
@@ -71,11 +74,9 @@ func TestProcessAgentMemory(t *testing.T) {
 	}
 }
 
-// TestTemplateStartSwitch verifies that using {{ .Start "agent" }} in a template
+// TestPlumbing_TemplateStartSwitch verifies that using {{ .Start "agent" }} in a template
 // changes the chat's start agent for the next user message.
-func TestTemplateStartSwitch(t *testing.T) {
-	requireAPI(t)
-
+func TestPlumbing_TemplateStartSwitch(t *testing.T) {
 	const spec = `
 agents:
   greeter:
@@ -87,22 +88,20 @@ agents:
 `
 
 	// Chat starts with 'greeter'
-	if defaultChat == nil {
-		defaultChat = NewChat("greeter")
-	}
-	reg, err := defaultChat.NewRegistry(spec)
+	reg, err := useDefaultChat(t, "greeter").NewRegistry(spec)
 	require.NoError(t, err)
+	useCassette(t, reg)
 
 	// First call should run greeter and change chat.Start
 	out1, trace := reg.Run(context.Background(), defaultChat.StartAgent, "first")
 	assert.Equal(t, "greeter", trace.AgentName, "chat start agent should helper")
-	trace.SaveMarkdown("trace1.md", true)
+	trace.SaveMarkdown(filepath.Join(t.TempDir(), "trace1.md"), true)
 	assert.Contains(t, out1, "Hi there!")
 	assert.Equal(t, "helper", defaultChat.StartAgent, "chat start agent should switch to helper")
 
 	// Second call should now go to helper automatically
 	out2, trace := reg.Run(context.Background(), defaultChat.StartAgent, "second")
-	trace.SaveMarkdown("trace2.md", true)
+	trace.SaveMarkdown(filepath.Join(t.TempDir(), "trace2.md"), true)
 	assert.Equal(t, "helper", trace.AgentName, "chat start agent should helper")
 	assert.Equal(t, "Helper heard: second", strings.TrimSpace(out2))
 }
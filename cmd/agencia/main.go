@@ -8,6 +8,7 @@ import (
 	"os"
 
 	"github.com/robbyriverside/agencia"
+	"github.com/robbyriverside/agencia/agents"
 	"github.com/robbyriverside/agencia/logs"
 
 	"github.com/jessevdk/go-flags"
@@ -19,6 +20,7 @@ var Version string = "unknown"
 func main() {
 	parser := flags.NewParser(agencia.GetOptions(), flags.Default)
 	parser.AddCommand("run", "Run an agent", "Execute a named agent with input", &RunCommand{})
+	parser.AddCommand("lint", "Lint an agent file", "Check an agent definition YAML file and its includes", &LintCommand{})
 	parser.AddCommand("server", "Run the Agencia server", "Run the Agencia server", &ServerCommand{})
 	parser.AddCommand("version", "Show the version", "Display Agencia version", &VersionCommand{})
 	if _, err := parser.Parse(); err != nil {
@@ -40,12 +42,21 @@ func (s *ServerCommand) Execute(args []string) error {
 }
 
 type RunCommand struct {
-	Name  string `short:"n" long:"name" required:"true" description:"Agent name to run"`
-	Input string `short:"i" long:"input" required:"true" description:"Input string"`
-	File  string `short:"f" long:"file" default:"agentic.yaml" description:"Agent definition YAML file"`
+	Name   string `short:"n" long:"name" required:"true" description:"Agent name to run"`
+	Input  string `short:"i" long:"input" required:"true" description:"Input string"`
+	File   string `short:"f" long:"file" default:"agentic.yaml" description:"Agent definition YAML file"`
+	Mock   string `short:"m" long:"mock" description:"Answer AI calls from a mock responses YAML file"`
+	Record string `long:"record" description:"Record every AI call to a cassette file"`
+	Replay string `long:"replay" description:"Answer AI calls from a recorded cassette file"`
 }
 
 func (r *RunCommand) Execute(args []string) error {
+	if r.Replay != "" && r.Mock != "" {
+		return errors.New("--mock and --replay cannot be used together: each answers every AI call")
+	}
+	if r.Replay != "" && r.Record != "" {
+		return errors.New("--record and --replay cannot be used together: a replay makes no AI calls to record")
+	}
 	_ = godotenv.Load()
 	logs.InitLogger(os.Getenv("ENV"))
 	ctx := context.Background()
@@ -54,13 +65,58 @@ func (r *RunCommand) Execute(args []string) error {
 		logs.Error(err)
 		return errors.New("run command failed")
 	}
-	if err := registry.RunPrint(ctx, r.Name, r.Input); err != nil {
-		logs.Error(err)
+	if r.Mock != "" {
+		mock, err := agents.LoadMockProvider(r.Mock)
+		if err != nil {
+			logs.Error(err)
+			return errors.New("run command failed")
+		}
+		registry.UseProvider(mock)
+	}
+	if r.Replay != "" {
+		cassette, err := agents.LoadCassette(r.Replay)
+		if err != nil {
+			logs.Error(err)
+			return errors.New("run command failed")
+		}
+		registry.UseProvider(cassette)
+	}
+	var cassette *agents.Cassette
+	if r.Record != "" {
+		cassette = agents.NewCassette(r.Record)
+		registry.Record(cassette)
+	}
+	runErr := registry.RunPrint(ctx, r.Name, r.Input)
+	if cassette != nil {
+		if err := cassette.Save(); err != nil {
+			logs.Error(err)
+			return errors.New("run command failed")
+		}
+	}
+	if runErr != nil {
+		logs.Error(runErr)
 		return errors.New("run command failed")
 	}
 	return nil
 }
 
+type LintCommand struct {
+	File string `short:"f" long:"file" default:"agentic.yaml" description:"Agent definition YAML file"`
+}
+
+func (l *LintCommand) Execute(args []string) error {
+	data, err := os.ReadFile(l.File)
+	if err != nil {
+		return fmt.Errorf("lint command failed: %w", err)
+	}
+	res := agencia.LintSpecPath(data, l.File)
+	fmt.Print(res.Result())
+	if !res.Valid {
+		return errors.New("lint command failed")
+	}
+	return nil
+}
+
 type VersionCommand struct{}
 
 func (v *VersionCommand) Execute(args []string) error {
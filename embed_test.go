@@ -0,0 +1,117 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// embedProvider answers embedding requests with vectors of the requested size, dropping the last one when short.
+type embedProvider struct {
+	short    bool
+	requests []*agents.EmbedRequest
+}
+
+func (p *embedProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (p *embedProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	p.requests = append(p.requests, req)
+	vectors := make([][]float32, len(req.Input))
+	for i := range vectors {
+		vectors[i] = make([]float32, req.Dimensions)
+	}
+	if p.short {
+		vectors = vectors[1:]
+	}
+	return &agents.EmbedResponse{Model: req.Model, Vectors: vectors}, nil
+}
+
+func TestEmbedder_Batches(t *testing.T) {
+	provider := &embedProvider{}
+	embedder := agents.NewEmbedder(provider, agents.EmbeddingConfig{Model: "text-embedding-3-small", Dimensions: 8, BatchSize: 2})
+	vectors, err := embedder.Embed(context.Background(), []string{"a", "b", "c", "d", "e"})
+	require.NoError(t, err)
+	assert.Len(t, vectors, 5)
+	assert.Equal(t, 8, embedder.Dimensions())
+
+	batches := [][]string{}
+	for _, req := range provider.requests {
+		batches = append(batches, req.Input)
+		assert.Equal(t, "text-embedding-3-small", req.Model)
+		assert.Equal(t, 8, req.Dimensions)
+	}
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, batches)
+
+	assert.Equal(t, 1536, agents.NewEmbedder(provider, agents.EmbeddingConfig{}).Dimensions(), "the default model's size")
+	_, err = agents.NewEmbedder(&embedProvider{short: true}, agents.EmbeddingConfig{}).Embed(context.Background(), []string{"a", "b"})
+	assert.EqualError(t, err, "embedding returned 1 vectors for 2 texts")
+}
+
+func TestFakeEmbedder(t *testing.T) {
+	fake := &agents.FakeEmbedder{}
+	vectors, err := fake.Embed(context.Background(), []string{"Reset my password", "How do I reset a password?", "Weather in Paris"})
+	require.NoError(t, err)
+	again, err := fake.Embed(context.Background(), []string{"Reset my password"})
+	require.NoError(t, err)
+	assert.Equal(t, vectors[0], again[0], "the same text has the same vector")
+	assert.Len(t, vectors[0], 64)
+	assert.InDelta(t, 1, agents.CosineSimilarity(vectors[0], vectors[0]), 1e-6)
+	assert.Greater(t, agents.CosineSimilarity(vectors[0], vectors[1]), agents.CosineSimilarity(vectors[0], vectors[2]),
+		"texts that share words are closer")
+	assert.Equal(t, 2, fake.Calls)
+}
+
+func TestRegistry_Embedder(t *testing.T) {
+	reg, err := NewRegistry(`
+embeddings:
+  model: text-embedding-3-small
+  dimensions: 256
+agents:
+  answer:
+    description: Answer the question
+    prompt: "{{ .Input }}"
+`)
+	require.NoError(t, err)
+	reg.RegisterAgent(&agents.Agent{
+		Name:   "dims",
+		Inputs: map[string]*agents.Argument{"text": {Type: "string", Description: "Any text"}},
+		Function: func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+			return strconv.Itoa(agents.EmbedderFrom(ctx).Dimensions()), nil
+		},
+	})
+	reg.UseProvider(&scriptProvider{answers: []string{`{"text": "hi"}`, `{"text": "hi"}`}})
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "dims", "hi")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "256", res.Output, "function agents embed as the embeddings section says")
+
+	reg.UseEmbedder(&agents.FakeEmbedder{Dims: 16})
+	res = NewRun(reg, nil).CallAgent(context.Background(), "dims", "hi")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "16", res.Output)
+}
+
+func TestLintSpecFile_Embeddings(t *testing.T) {
+	spec := `
+embeddings:
+  provider: cohere
+  dimensions: none
+  size: 10
+agents:
+  answer:
+    description: Answer the question
+    prompt: "{{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 3: The embeddings use undefined provider 'cohere'. Please declare it in the providers section.")
+	assert.Contains(t, result.Errors, "Problem: Line 4: The embeddings setting 'dimensions' must be a positive integer, got 'none'.")
+	assert.Contains(t, result.Errors, "Problem: Line 5: Unknown embeddings setting 'size'. Use provider, model, dimensions and batch_size.")
+}
@@ -0,0 +1,286 @@
+package agencia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// extractField is one value the model is asked to extract.
+type extractField struct {
+	Name        string
+	Description string
+	Type        string // JSON schema type; empty allows any value
+	Required    bool
+}
+
+// schemaType is the JSON schema type of the field's value, which is null when the model
+// has no value for an optional field.
+func (f extractField) schemaType() any {
+	if f.Required {
+		return f.Type
+	}
+	return []string{f.Type, "null"}
+}
+
+// extraction describes the object the model must answer an extraction prompt with.
+// The schema sent to the provider lists every field, with optional ones nullable, so it
+// can be strict. The answer is checked against a looser schema that only insists on the
+// required fields, because providers without structured outputs may leave fields out.
+// Neither schema lets a required field be null.
+type extraction struct {
+	format *agents.ResponseFormat
+	fields []extractField
+}
+
+// inputsExtraction asks for the agent's inputs.
+func inputsExtraction(agent *agents.Agent) *extraction {
+	fields := []extractField{}
+	for _, name := range slices.Sorted(maps.Keys(agent.Inputs)) {
+		arg := agent.Inputs[name]
+		typ := arg.Type
+		if typ == "" {
+			typ = "string"
+		}
+		if !toolParameterTypes[typ] {
+			typ = ""
+		}
+		fields = append(fields, extractField{Name: name, Description: arg.Description, Type: typ, Required: arg.Required})
+	}
+	return newExtraction(agents.PurposeInputs, fields)
+}
+
+// factTypes maps fact types to JSON schema types.
+var factTypes = map[string]string{
+	"":       "string",
+	"string": "string",
+	"int":    "integer",
+	"float":  "number",
+	"bool":   "boolean",
+	"list":   "array",
+}
+
+// factsExtraction asks for the agent's facts. Facts are never required.
+func factsExtraction(agent *agents.Agent) *extraction {
+	fields := []extractField{}
+	for _, name := range slices.Sorted(maps.Keys(agent.Facts)) {
+		fact := agent.Facts[name]
+		fields = append(fields, extractField{Name: name, Description: fact.Description, Type: factTypes[fact.Type]})
+	}
+	return newExtraction(agents.PurposeFacts, fields)
+}
+
+func newExtraction(name string, fields []extractField) *extraction {
+	properties := map[string]any{}
+	required := []string{}
+	strict := true
+	for _, field := range fields {
+		property := map[string]any{"description": field.Description}
+		switch field.Type {
+		case "string", "number", "integer", "boolean":
+			property["type"] = field.schemaType()
+		case "array":
+			property["type"] = field.schemaType()
+			property["items"] = map[string]any{"type": "string"}
+		default:
+			strict = false // free form values are outside the strict subset
+			if field.Type != "" {
+				property["type"] = field.schemaType()
+			}
+		}
+		properties[field.Name] = property
+		required = append(required, field.Name)
+	}
+	return &extraction{
+		format: &agents.ResponseFormat{
+			Name: name,
+			Schema: map[string]any{
+				"type":                 "object",
+				"properties":           properties,
+				"required":             required,
+				"additionalProperties": false,
+			},
+			Strict: strict,
+		},
+		fields: fields,
+	}
+}
+
+// validator compiles the schema the answer is checked against.
+func (e *extraction) validator() (*jsonschema.Schema, error) {
+	properties := map[string]any{}
+	required := []string{}
+	for _, field := range e.fields {
+		property := map[string]any{}
+		switch {
+		case field.Type != "":
+			property["type"] = field.schemaType()
+		case field.Required:
+			property["not"] = map[string]any{"type": "null"}
+		}
+		properties[field.Name] = property
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	data, err := json.Marshal(map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(e.format.Name+".json", strings.NewReader(string(data))); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(e.format.Name + ".json")
+}
+
+// read parses and validates the model's answer.
+// String fields answered with a number or boolean are kept as their text.
+func (e *extraction) read(answer string) (map[string]any, error) {
+	values, err := readValues(answer)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range e.fields {
+		switch v := values[field.Name].(type) {
+		case int, float64, bool:
+			if field.Type == "string" {
+				values[field.Name] = fmt.Sprint(v)
+			}
+		}
+	}
+	schema, err := e.validator()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s schema: %w", e.format.Name, err)
+	}
+	// Validate the JSON form of the values; yaml decodes numbers as Go ints.
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := schema.Validate(doc); err != nil {
+		var verr *jsonschema.ValidationError
+		if errors.As(err, &verr) {
+			return nil, errors.New(validationMessage(verr))
+		}
+		return nil, err
+	}
+	return values, nil
+}
+
+// validationMessage flattens a validation error into one line per failed field.
+func validationMessage(verr *jsonschema.ValidationError) string {
+	var lines []string
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			at := e.InstanceLocation
+			if at == "" {
+				at = "/"
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", at, e.Message))
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(verr)
+	return strings.Join(lines, "; ")
+}
+
+// readValues finds the object in a model's answer.
+// It may be JSON or YAML, and may be wrapped in a code fence or surrounded by prose.
+func readValues(answer string) (map[string]any, error) {
+	text := strings.TrimSpace(answer)
+	if start := strings.Index(text, "```"); start != -1 {
+		if end := strings.LastIndex(text, "```"); end > start {
+			text = text[start+3 : end]
+			if lang, rest, ok := strings.Cut(text, "\n"); ok && !strings.ContainsAny(lang, ":{") {
+				text = rest // drop the fence's language tag
+			}
+			text = strings.TrimSpace(text)
+		}
+	}
+	if text == "" {
+		return map[string]any{}, nil
+	}
+	var values map[string]any
+	err := yaml.Unmarshal([]byte(text), &values)
+	if err == nil && values != nil {
+		return values, nil
+	}
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start != -1 && end > start {
+		var object map[string]any
+		if yaml.Unmarshal([]byte(text[start:end+1]), &object) == nil && object != nil {
+			return object, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("the answer is not an object")
+	}
+	return nil, fmt.Errorf("cannot read the answer as JSON or YAML: %w", err)
+}
+
+// unreadableAnswerError is an extraction answer that was still unusable after repair.
+type unreadableAnswerError struct {
+	purpose, agent string
+	err            error
+}
+
+func (e *unreadableAnswerError) Error() string {
+	return fmt.Sprintf("cannot read %s of %s from the AI answer: %v", e.purpose, e.agent, e.err)
+}
+
+func (e *unreadableAnswerError) Unwrap() error { return e.err }
+
+// repairPrompt asks the model to fix an answer that could not be used.
+const repairPrompt = `That answer could not be used: %v
+Respond again with only the corrected object, without markdown formatting or any explanation.`
+
+// extractValues asks the model for the values described by ex.
+// An answer that cannot be read or does not match the schema is sent back once for repair.
+// An answer starting with ERROR: is returned as an errModelReported error.
+func (r *RunContext) extractValues(ctx context.Context, agent *agents.Agent, purpose, prompt string, ex *extraction) (map[string]any, error) {
+	messages := []agents.Message{{Role: agents.RoleUser, Content: prompt}}
+	for repaired := false; ; repaired = true {
+		req := r.newChatRequest(agent, purpose, messages, nil)
+		req.ResponseFormat = ex.format
+		resp, err := r.chat(ctx, agent, req)
+		if err != nil {
+			return nil, err
+		}
+		answer := strings.TrimSpace(resp.Content)
+		if strings.HasPrefix(answer, "ERROR:") {
+			return nil, fmt.Errorf("%w: %s", errModelReported, answer)
+		}
+		values, err := ex.read(answer)
+		if err == nil {
+			return values, nil
+		}
+		if repaired {
+			return nil, &unreadableAnswerError{purpose: purpose, agent: agent.Name, err: err}
+		}
+		r.Logf("[AI] %s answer for %s needs repair: %v", purpose, agent.Name, err)
+		messages = append(messages,
+			agents.Message{Role: agents.RoleAssistant, Content: answer},
+			agents.Message{Role: agents.RoleUser, Content: fmt.Sprintf(repairPrompt, err)},
+		)
+	}
+}
@@ -0,0 +1,193 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptProvider answers calls with its answers in order and keeps the requests.
+type scriptProvider struct {
+	answers  []string
+	mu       sync.Mutex
+	requests []*agents.ChatRequest
+}
+
+func (p *scriptProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, req)
+	if len(p.requests) > len(p.answers) {
+		return nil, fmt.Errorf("unexpected call %d", len(p.requests))
+	}
+	return &agents.ChatResponse{Content: p.answers[len(p.requests)-1]}, nil
+}
+
+func (p *scriptProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func greetRegistry(provider agents.Provider) *Registry {
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "greet",
+		Description: "Generates a greeting message given a person's name",
+		Inputs: map[string]*agents.Argument{
+			"personName": {Type: "string", Description: "The name of the person to greet.", Required: true},
+			"times":      {Type: "integer", Description: "How many times to greet."},
+		},
+		Template: `Hello, {{ .Input "personName" }}!`,
+	})
+	reg.UseProvider(provider)
+	return reg
+}
+
+func TestReadValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   map[string]any
+	}{
+		{"json", `{"personName": "Alice", "times": 2}`, map[string]any{"personName": "Alice", "times": 2}},
+		{"yaml", "personName: Alice\ntimes: 2", map[string]any{"personName": "Alice", "times": 2}},
+		{"fenced yaml", "```yaml\npersonName: Alice\n```", map[string]any{"personName": "Alice"}},
+		{"fenced json", "Here you go:\n```json\n{\"personName\": \"Alice\"}\n```\nAnything else?", map[string]any{"personName": "Alice"}},
+		{"prose around json", `Sure! {"personName": "Alice"} Let me know if you need more.`, map[string]any{"personName": "Alice"}},
+		{"empty", "", map[string]any{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readValues(test.answer)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+	_, err := readValues("The person is Alice.")
+	assert.Error(t, err)
+}
+
+func TestExtract_Schema(t *testing.T) {
+	provider := &scriptProvider{answers: []string{`{"personName": "Alice", "times": null}`}}
+	res := NewRun(greetRegistry(provider), nil).CallAgent(context.Background(), "greet", "Say hi to Alice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Hello, Alice!", res.Output)
+
+	require.Len(t, provider.requests, 1)
+	format := provider.requests[0].ResponseFormat
+	require.NotNil(t, format)
+	assert.Equal(t, "inputs", format.Name)
+	assert.True(t, format.Strict)
+	assert.Equal(t, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"personName": map[string]any{"type": "string", "description": "The name of the person to greet."},
+			"times":      map[string]any{"type": []string{"integer", "null"}, "description": "How many times to greet."},
+		},
+		"required":             []string{"personName", "times"},
+		"additionalProperties": false,
+	}, format.Schema, "strict schemas list every field and make the optional ones nullable")
+}
+
+func TestExtract_RequiredNull(t *testing.T) {
+	values, err := inputsExtraction(greetRegistry(nil).Agents["greet"]).read(`{"personName": null, "times": null}`)
+	assert.Nil(t, values)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/personName: expected string, but got null")
+
+	provider := &scriptProvider{answers: []string{`{"personName": null}`, `{"personName": null}`}}
+	res := NewRun(greetRegistry(provider), nil).CallAgent(context.Background(), "greet", "Say hi")
+	require.Error(t, res.Error, "a required input answered with null is missing")
+	assert.Contains(t, res.Error.Error(), "cannot read inputs of greet from the AI answer")
+
+	reg := greetRegistry(nil)
+	err = NewRun(reg, nil).checkAgentInputs(reg.Agents["greet"], map[string]any{"personName": nil})
+	require.Error(t, err, "a required input found as null is missing")
+	assert.Contains(t, err.Error(), `"personName"`)
+}
+
+func TestExtract_Repair(t *testing.T) {
+	provider := &scriptProvider{answers: []string{
+		"The person to greet is Alice.",
+		"personName: Alice",
+	}}
+	run := NewRun(greetRegistry(provider), nil)
+	res := run.CallAgent(context.Background(), "greet", "Say hi to Alice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Hello, Alice!", res.Output)
+	assert.Equal(t, map[string]any{"personName": "Alice"}, run.Card.Inputs)
+
+	require.Len(t, provider.requests, 2)
+	repair := provider.requests[1].Messages
+	require.Len(t, repair, 3, "the prompt, the bad answer and the repair request")
+	assert.Equal(t, "The person to greet is Alice.", repair[1].Content)
+	assert.Contains(t, repair[2].Content, "That answer could not be used: cannot read the answer as JSON or YAML")
+	require.Len(t, run.Card.Logs, 1)
+	assert.Contains(t, run.Card.Logs[0].Message, "[AI] inputs answer for greet needs repair")
+}
+
+func TestExtract_RepairFails(t *testing.T) {
+	provider := &scriptProvider{answers: []string{
+		`{"name": "Alice"}`,
+		`{"person": "Alice", "times": "twice"}`,
+	}}
+	res := NewRun(greetRegistry(provider), nil).CallAgent(context.Background(), "greet", "Say hi to Alice")
+	require.Error(t, res.Error)
+	assert.Contains(t, res.Error.Error(), "cannot read inputs of greet from the AI answer")
+	assert.Contains(t, res.Error.Error(), "missing properties: 'personName'")
+	assert.Contains(t, provider.requests[1].Messages[2].Content, "additionalProperties 'name' not allowed")
+}
+
+func TestExtract_UnreadableFactsAreLogged(t *testing.T) {
+	provider := &scriptProvider{answers: []string{"I am not sure.", "Still not sure."}}
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{
+		Name:     "note",
+		Template: "Noted.",
+		Facts:    map[string]*agents.Fact{"mood": {Description: "The user's mood"}},
+	})
+	reg.UseProvider(provider)
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "note", "I feel great")
+	require.NoError(t, res.Error, "facts are best effort")
+	assert.Equal(t, "Noted.", res.Output)
+	require.Len(t, run.Card.Logs, 2)
+	assert.Contains(t, run.Card.Logs[1].Message, "[ERROR] cannot read facts of note from the AI answer")
+}
+
+func TestExtract_OpenAIStructuredOutputs(t *testing.T) {
+	for _, structured := range []bool{true, false} {
+		t.Run(fmt.Sprint(structured), func(t *testing.T) {
+			server, _, bodies := chatCompletionServer(t, `{"personName": "Alice", "times": 1}`)
+			t.Setenv("LOCAL_KEY", "secret")
+			reg := greetRegistry(nil)
+			reg.Provider = nil
+			reg.Providers = nil
+			reg.Defaults = agents.ModelParams{Provider: "local"}
+			reg.Profiles = map[string]*agents.ProviderConfig{
+				"local": {BaseURL: server.URL + "/v1", APIKeyEnv: "LOCAL_KEY", StructuredOutputs: &structured},
+			}
+
+			res := NewRun(reg, nil).CallAgent(context.Background(), "greet", "Say hi to Alice")
+			require.NoError(t, res.Error)
+			assert.Equal(t, "Hello, Alice!", res.Output)
+
+			body := <-bodies
+			if !structured {
+				assert.Nil(t, body["response_format"])
+				return
+			}
+			format := body["response_format"].(map[string]any)
+			assert.Equal(t, "json_schema", format["type"])
+			schema := format["json_schema"].(map[string]any)
+			assert.Equal(t, "inputs", schema["name"])
+			assert.Equal(t, true, schema["strict"])
+			assert.Equal(t, []any{"personName", "times"}, schema["schema"].(map[string]any)["required"])
+		})
+	}
+}
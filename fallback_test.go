@@ -0,0 +1,190 @@
+package agencia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fallbackSpec = `
+providers:
+  claude:
+    type: anthropic
+    model: claude-haiku-4-5
+agents:
+  answer:
+    description: Answer the question
+    model: gpt-4o
+    temperature: 0
+    timeout: 50ms
+    fallback:
+      - model: gpt-4o-mini
+      - model: gpt-4.1-nano
+      - provider: claude
+    prompt: "{{ .Input }}"
+`
+
+// modelProvider answers each model with its response, its error or a long wait.
+type modelProvider struct {
+	answers  map[string]*agents.ChatResponse
+	errs     map[string]error
+	slow     map[string]bool
+	mu       sync.Mutex
+	requests []*agents.ChatRequest
+}
+
+func (p *modelProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+	if p.slow[req.Model] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err := p.errs[req.Model]; err != nil {
+		return nil, err
+	}
+	if resp, ok := p.answers[req.Model]; ok {
+		return resp, nil
+	}
+	return nil, fmt.Errorf("no answer for %s", req.Model)
+}
+
+func (p *modelProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestFallback_Chain(t *testing.T) {
+	reg, err := NewRegistry(fallbackSpec)
+	require.NoError(t, err)
+	provider := &modelProvider{
+		errs: map[string]error{"gpt-4o": &agents.ProviderError{StatusCode: http.StatusBadRequest, Err: errors.New("model overloaded")}},
+		answers: map[string]*agents.ChatResponse{
+			"gpt-4o-mini":      {Content: "", FinishReason: agents.FinishReasonContentFilter},
+			"gpt-4.1-nano":     {},
+			"claude-haiku-4-5": {Content: "42", Usage: agents.Usage{PromptTokens: 1000000}},
+		},
+	}
+	reg.UseProvider(provider)
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "answer", "What is six times seven?")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "42", res.Output)
+
+	models := []string{}
+	for _, req := range provider.requests {
+		models = append(models, req.Provider+"/"+req.Model)
+		assert.Equal(t, float32(0), *req.Temperature, "fallbacks keep the agent's other settings")
+	}
+	assert.Equal(t, []string{"/gpt-4o", "/gpt-4o-mini", "/gpt-4.1-nano", "claude/claude-haiku-4-5"}, models)
+
+	card := run.Card
+	assert.Equal(t, "claude-haiku-4-5", card.Model)
+	assert.Equal(t, 1, card.Fallbacks)
+	assert.Contains(t, card.String(), "Model: claude-haiku-4-5 (1 calls fell back)")
+	assert.InDelta(t, agents.DefaultPrices["claude-haiku-4-5"].Input, card.Usage.Cost, 1e-9, "usage is priced as the model that answered")
+	messages := []string{}
+	for _, log := range card.Logs {
+		messages = append(messages, log.Message)
+	}
+	assert.Equal(t, []string{
+		"[AI] prompt falling back from gpt-4o to gpt-4o-mini: model overloaded",
+		"[AI] prompt falling back from gpt-4o-mini to gpt-4.1-nano: the response was blocked by the content filter",
+		"[AI] prompt falling back from gpt-4.1-nano to claude/claude-haiku-4-5: the response was empty",
+	}, messages)
+}
+
+func TestFallback_Timeout(t *testing.T) {
+	reg, err := NewRegistry(fallbackSpec)
+	require.NoError(t, err)
+	reg.UseProvider(&modelProvider{
+		slow:    map[string]bool{"gpt-4o": true},
+		answers: map[string]*agents.ChatResponse{"gpt-4o-mini": {Content: "42"}},
+	})
+
+	start := time.Now()
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "answer", "What is six times seven?")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "42", res.Output)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "gpt-4o-mini", run.Card.Model)
+	assert.Contains(t, run.Card.Logs[0].Message, "context deadline exceeded")
+}
+
+func TestFallback_LastErrorReturned(t *testing.T) {
+	reg, err := NewRegistry(fallbackSpec)
+	require.NoError(t, err)
+	reg.UseProvider(&modelProvider{})
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "answer", "What is six times seven?")
+	require.Error(t, res.Error)
+	assert.Equal(t, "no answer for claude-haiku-4-5", res.Error.Error())
+}
+
+func TestFallback_AnthropicRefusal(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	server := newAnthropicServer(t, http.StatusOK, func(_ int, body map[string]any) string {
+		if body["model"] == "claude-sonnet-4-5" {
+			return "refusal.json"
+		}
+		return "03_end_turn.json"
+	})
+	reg := anthropicRegistry(t, server)
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "plain",
+		ModelParams: agents.ModelParams{Model: "claude-sonnet-4-5"},
+		Fallback:    []agents.ModelParams{{Model: "claude-haiku-4-5"}},
+		Prompt:      "{{ .Input }}",
+	})
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "plain", "Greet Alice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "The greeting agent says: Hello, Alice!", res.Output)
+	assert.Equal(t, 1, run.Card.Fallbacks)
+	messages := []string{}
+	for _, log := range run.Card.Logs {
+		messages = append(messages, log.Message)
+	}
+	assert.Contains(t, messages, "[AI] prompt falling back from claude/claude-sonnet-4-5 to claude/claude-haiku-4-5: the response was blocked by the content filter")
+}
+
+func TestFallbackReason(t *testing.T) {
+	boom := errors.New("boom")
+	assert.Equal(t, boom, agents.FallbackReason(nil, boom))
+	assert.Equal(t, agents.ErrNoChoices, agents.FallbackReason(nil, nil))
+	assert.Equal(t, agents.ErrEmptyResponse, agents.FallbackReason(&agents.ChatResponse{}, nil))
+	assert.Equal(t, agents.ErrContentFilter, agents.FallbackReason(&agents.ChatResponse{Content: "I can't", FinishReason: agents.FinishReasonContentFilter}, nil))
+	assert.NoError(t, agents.FallbackReason(&agents.ChatResponse{ToolCalls: []agents.ToolCall{{Name: "greet"}}}, nil))
+}
+
+func TestLintSpecFile_Fallback(t *testing.T) {
+	spec := `
+agents:
+  answer:
+    description: Answer the question
+    timeout: soon
+    fallback:
+      - provider: mistral
+      - gpt-4o-mini
+      - model: gpt-4o-mini
+        colour: blue
+    prompt: "{{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 5: Agent 'answer' timeout must be a duration like 30s, got 'soon'.")
+	assert.Contains(t, result.Errors, "Problem: Line 7: Fallback 1 of agent 'answer' uses undefined provider 'mistral'. Please declare it in the providers section.")
+	assert.Contains(t, result.Errors, "Problem: Line 8: Fallback 2 of agent 'answer' must be a mapping with a provider or model.")
+	assert.Contains(t, result.Errors, "Problem: Line 10: Fallback 3 of agent 'answer' has unknown model setting 'colour'.")
+}
@@ -0,0 +1,61 @@
+package agencia
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/robbyriverside/agencia/agents"
+)
+
+// Function is a Go function that spec agents run with function: name.
+type Function struct {
+	Name   string
+	Fn     agents.AgentFn
+	Inputs map[string]*agents.Argument // the inputs of agents that do not declare their own
+}
+
+var (
+	functionsMu sync.RWMutex
+	functions   = map[string]*Function{}
+)
+
+// RegisterFunction makes fn available to specs as function: name.
+// Function agents are given structured input, so an agent bound to fn extracts the inputs it declares,
+// or these inputs when it declares none.  Registering a name again replaces the function.
+//
+//	agencia.RegisterFunction("markdown.StripYamlBlock", StripYamlBlock, map[string]*agents.Argument{
+//		"text": {Type: "string", Description: "The markdown to clean up", Required: true},
+//	})
+func RegisterFunction(name string, fn agents.AgentFn, inputs map[string]*agents.Argument) {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	functions[name] = &Function{Name: name, Fn: fn, Inputs: inputs}
+}
+
+// LookupFunction returns the function registered with the name.
+func LookupFunction(name string) (*Function, bool) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	f, ok := functions[name]
+	return f, ok
+}
+
+// bindFunction sets the function of an agent declared with function: name in a spec.
+func bindFunction(agent *agents.Agent) error {
+	if agent.FuncName == "" || agent.Function != nil {
+		return nil
+	}
+	f, ok := LookupFunction(agent.FuncName)
+	if !ok {
+		return fmt.Errorf("agent '%s' uses unknown function '%s'", agent.Name, agent.FuncName)
+	}
+	agent.Function = f.Fn
+	if len(agent.Inputs) == 0 && len(f.Inputs) > 0 {
+		agent.Inputs = make(map[string]*agents.Argument, len(f.Inputs))
+		for name, arg := range f.Inputs {
+			copied := *arg
+			agent.Inputs[name] = &copied
+		}
+	}
+	return nil
+}
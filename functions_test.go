@@ -0,0 +1,69 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	RegisterFunction("text.Shout", func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+		return strings.ToUpper(fmt.Sprint(input["text"])) + "!", nil
+	}, map[string]*agents.Argument{
+		"text": {Type: "string", Description: "The text to shout", Required: true},
+	})
+}
+
+func TestRegisterFunction(t *testing.T) {
+	reg, err := NewRegistry(`
+agents:
+  shout:
+    description: Shout the text
+    function: text.Shout
+  shout_name:
+    description: Shout a person's name
+    function: text.Shout
+    inputs:
+      text:
+        description: The name of the person
+  greet:
+    description: Greet loudly
+    template: 'Hello {{ .Get "shout" }}'
+`)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{`{"text": "bob"}`, `{"text": "ann"}`}}
+	reg.UseProvider(provider)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "greet", "bob")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Hello BOB!", res.Output)
+	assert.Equal(t, "The text to shout", reg.Agents["shout"].Inputs["text"].Description, "agents without inputs use the function's")
+	assert.True(t, reg.Agents["shout"].Inputs["text"].Required)
+
+	res = NewRun(reg, nil).CallAgent(context.Background(), "shout_name", "My name is Ann")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "ANN!", res.Output)
+	assert.Equal(t, "The name of the person", reg.Agents["shout_name"].Inputs["text"].Description, "declared inputs win")
+	assert.Equal(t, "The text to shout", functions["text.Shout"].Inputs["text"].Description, "the registered inputs are not changed")
+}
+
+func TestRegisterFunction_Unknown(t *testing.T) {
+	spec := `
+agents:
+  strip:
+    description: Strip the yaml block
+    function: markdown.StripYamlBlock
+`
+	_, err := NewRegistry(spec, true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "agent 'strip' uses unknown function 'markdown.StripYamlBlock'")
+
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 5: Agent 'strip' uses unknown function 'markdown.StripYamlBlock'. Register it in Go with agencia.RegisterFunction.")
+}
@@ -0,0 +1,104 @@
+package agencia
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestHistory_SentBeforePrompt(t *testing.T) {
+	chat := useDefaultChat(t, "support")
+	reg, err := chat.NewRegistry(`
+agents:
+  support:
+    description: Answer the customer
+    role: You are a helpful support agent.
+    history: 2
+    prompt: "Customer: {{ .Input }}"
+  oneshot:
+    description: Answer without history
+    prompt: "Customer: {{ .Input }}"
+`)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{"Hi Ann.", "Order 42 shipped.", "You are welcome, Ann.", "Hello."}}
+	reg.UseProvider(provider)
+
+	reg.Run(context.Background(), "support", "I am Ann.")
+	reg.Run(context.Background(), "support", "Where is order 42?")
+	out, card := reg.Run(context.Background(), "support", "Thanks!")
+	assert.Equal(t, "You are welcome, Ann.", out)
+	require.Len(t, chat.Transcript, 3)
+	assert.Equal(t, Turn{Agent: "support", Input: "Thanks!", Output: "You are welcome, Ann."}, chat.Transcript[2])
+
+	assert.Equal(t, []agents.Message{
+		{Role: agents.RoleSystem, Content: "You are a helpful support agent."},
+		{Role: agents.RoleUser, Content: "I am Ann."},
+		{Role: agents.RoleAssistant, Content: "Hi Ann."},
+		{Role: agents.RoleUser, Content: "Where is order 42?"},
+		{Role: agents.RoleAssistant, Content: "Order 42 shipped."},
+		{Role: agents.RoleUser, Content: "Customer: Thanks!"},
+	}, provider.requests[2].Messages)
+	assert.Len(t, card.History, 4)
+	assert.Contains(t, card.String(), "History:\n  user: \"I am Ann.\"\n  assistant: \"Hi Ann.\"\n")
+
+	reg.Run(context.Background(), "oneshot", "Hello?")
+	assert.Equal(t, []agents.Message{{Role: agents.RoleUser, Content: "Customer: Hello?"}}, provider.requests[3].Messages,
+		"agents without history only send their prompt")
+
+	_, card = reg.Run(context.Background(), "support", "One more thing.")
+	require.Len(t, card.Logs, 1)
+	assert.Equal(t, "[HISTORY] 2 earlier turns trimmed for support", card.Logs[0].Message)
+}
+
+func TestHistory_Trim(t *testing.T) {
+	chat := NewChat("support")
+	chat.AddTurn("support", "first question", "first answer")                      // 2 + 2 tokens
+	chat.AddTurn("support", "second question", strings.Repeat("long answer ", 10)) // 2 + 21 tokens
+	chat.AddTurn("support", "third", "done")                                       // 1 + 1 tokens
+
+	history, trimmed := chat.History(&agents.HistoryPolicy{}, "gpt-4o")
+	assert.Len(t, history, 6)
+	assert.Zero(t, trimmed)
+
+	history, trimmed = chat.History(&agents.HistoryPolicy{Turns: 2}, "gpt-4o")
+	assert.Len(t, history, 4)
+	assert.Equal(t, 1, trimmed)
+
+	history, trimmed = chat.History(&agents.HistoryPolicy{Tokens: 20}, "gpt-4o")
+	assert.Equal(t, []agents.Message{
+		{Role: agents.RoleUser, Content: "third"},
+		{Role: agents.RoleAssistant, Content: "done"},
+	}, history)
+	assert.Equal(t, 2, trimmed)
+
+	var nilChat *Chat
+	history, _ = nilChat.History(&agents.HistoryPolicy{Turns: 2}, "gpt-4o")
+	assert.Empty(t, history)
+}
+
+func TestHistory_YAML(t *testing.T) {
+	var agent agents.Agent
+	require.NoError(t, yaml.Unmarshal([]byte("history: 6"), &agent))
+	assert.Equal(t, &agents.HistoryPolicy{Turns: 6}, agent.History)
+	require.NoError(t, yaml.Unmarshal([]byte("history: {turns: 20, tokens: 2000}"), &agent))
+	assert.Equal(t, &agents.HistoryPolicy{Turns: 20, Tokens: 2000}, agent.History)
+	assert.Error(t, yaml.Unmarshal([]byte("history: lots"), &agent))
+}
+
+func TestLintSpecFile_History(t *testing.T) {
+	spec := `
+agents:
+  support:
+    description: Answer the customer
+    history: lots
+    prompt: "Customer: {{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 5: Agent 'support' history must be a number of turns or a mapping with turns and tokens.")
+}
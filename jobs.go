@@ -0,0 +1,416 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robbyriverside/agencia/agents"
+)
+
+// Job statuses.
+const (
+	JobRunning  = "running"  // steps are still being called
+	JobPaused   = "paused"   // the next step waits for the job to be resumed
+	JobDone     = "done"     // every step ran; the output is the last step's
+	JobFailed   = "failed"   // a step failed and the rest were not called
+	JobCanceled = "canceled" // the job was canceled and the rest of the steps were not called
+)
+
+// Job is a run of a job agent's steps in the background.
+// Each step is given the job's input followed by the outputs of the steps before it.
+type Job struct {
+	ID      string
+	Agent   string     // the job agent
+	Input   string     // the job agent's input
+	Steps   []string   // the agents called in order
+	Card    *TraceCard // the job's trace; every step is a branch of it
+	Chat    *Chat      // the chat told when the job ends; may be nil
+	Started time.Time
+
+	mu       sync.Mutex
+	status   string
+	outputs  []string // the outputs of the steps that ran
+	err      error
+	cancel   context.CancelFunc
+	canceled bool
+	resume   chan struct{} // closed when a paused job is resumed
+	done     chan struct{}
+}
+
+// Status returns running, paused, done, failed or canceled.
+func (j *Job) Status() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Outputs returns the outputs of the steps that have run so far.
+func (j *Job) Outputs() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string(nil), j.outputs...)
+}
+
+// Err returns the error that failed the job.
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Done is closed when the job ends.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait blocks until the job ends and returns the output of its last step,
+// or an empty output when no step finished.
+func (j *Job) Wait() (string, error) {
+	<-j.done
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil || len(j.outputs) == 0 {
+		return "", j.err
+	}
+	return j.outputs[len(j.outputs)-1], nil
+}
+
+// String describes the job's progress, and its output or error once it has ended.
+func (j *Job) String() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	desc := fmt.Sprintf("%s (%s) %s: %d of %d steps finished", j.ID, j.Agent, j.status, len(j.outputs), len(j.Steps))
+	switch {
+	case j.status == JobDone && len(j.outputs) > 0:
+		desc += ". Output: " + j.outputs[len(j.outputs)-1]
+	case j.status == JobFailed:
+		desc += ". Error: " + j.err.Error()
+	}
+	return desc
+}
+
+// Cancel stops the job.  A step that is running is interrupted and no more steps are called.
+func (j *Job) Cancel() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status != JobRunning && j.status != JobPaused {
+		return fmt.Errorf("job %s is already %s", j.ID, j.status)
+	}
+	j.canceled = true
+	if j.cancel != nil {
+		j.cancel()
+	}
+	return nil
+}
+
+// Pause holds the job before its next step.  A step that is running is finished.
+func (j *Job) Pause() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status != JobRunning {
+		return fmt.Errorf("job %s is %s and cannot be paused", j.ID, j.status)
+	}
+	j.status = JobPaused
+	j.resume = make(chan struct{})
+	return nil
+}
+
+// Resume continues a paused job.
+func (j *Job) Resume() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status != JobPaused {
+		return fmt.Errorf("job %s is %s, not paused", j.ID, j.status)
+	}
+	j.status = JobRunning
+	close(j.resume)
+	j.resume = nil
+	return nil
+}
+
+// proceed waits while the job is paused, and returns an error once it is canceled.
+func (j *Job) proceed(ctx context.Context) error {
+	j.mu.Lock()
+	resume := j.resume
+	j.mu.Unlock()
+	if resume != nil {
+		select {
+		case <-resume:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+// stepInput is the job's input followed by the outputs of the steps that have run.
+func (j *Job) stepInput() string {
+	var b strings.Builder
+	b.WriteString(j.Input)
+	for i, out := range j.Outputs() {
+		fmt.Fprintf(&b, "\n\nOutput of %s:\n%s", j.Steps[i], out)
+	}
+	return b.String()
+}
+
+func (j *Job) addOutput(output string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.outputs = append(j.outputs, output)
+}
+
+// end records how the job ended.  Closing done then releases Wait.
+func (j *Job) end(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status, j.err = JobDone, err
+	switch {
+	case j.canceled:
+		j.status, j.err = JobCanceled, fmt.Errorf("job %s was canceled", j.ID)
+	case err != nil:
+		j.status = JobFailed
+	}
+}
+
+// Notice describes how the job ended.
+func (j *Job) Notice() JobNotice {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	notice := JobNotice{JobID: j.ID, Agent: j.Agent, Status: j.status}
+	if j.err != nil {
+		notice.Error = j.err.Error()
+	} else if len(j.outputs) > 0 {
+		notice.Output = j.outputs[len(j.outputs)-1]
+	}
+	return notice
+}
+
+// JobNotice tells a chat that one of its jobs has ended.
+type JobNotice struct {
+	JobID  string `json:"job_id"`
+	Agent  string `json:"agent"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (n JobNotice) String() string {
+	if n.Status == JobCanceled {
+		return fmt.Sprintf("Job %s (%s) was canceled", n.JobID, n.Agent)
+	}
+	if n.Error != "" {
+		return fmt.Sprintf("Job %s (%s) failed: %s", n.JobID, n.Agent, n.Error)
+	}
+	return fmt.Sprintf("Job %s (%s) is done: %s", n.JobID, n.Agent, n.Output)
+}
+
+// DefaultJobHistory is how many ended jobs a JobRunner keeps when its History is not set.
+const DefaultJobHistory = 100
+
+// JobRunner keeps the jobs of a registry by ID.
+// Running and paused jobs are always kept; of the jobs that have ended, only the latest are.
+type JobRunner struct {
+	History int // the ended jobs kept, dropping the oldest first; zero keeps DefaultJobHistory
+
+	mu   sync.Mutex
+	jobs []*Job // in the order they started
+	next int
+}
+
+// Job returns the job with the ID.
+func (jr *JobRunner) Job(id string) (*Job, bool) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	for _, job := range jr.jobs {
+		if job.ID == id {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// List returns every job, oldest first.
+func (jr *JobRunner) List() []*Job {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	return append([]*Job(nil), jr.jobs...)
+}
+
+// Find returns the job with the ID, or else the latest job of the named agent,
+// so users who have forgotten the ID can refer to a job by its name.
+func (jr *JobRunner) Find(ref string) (*Job, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	for _, job := range jr.jobs {
+		if job.ID == ref {
+			return job, nil
+		}
+	}
+	for i := len(jr.jobs) - 1; i >= 0; i-- {
+		if jr.jobs[i].Agent == ref {
+			return jr.jobs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("there is no job %s", ref)
+}
+
+// control finds a job and cancels, pauses or resumes it, then reports the job's new state.
+func (jr *JobRunner) control(ref string, action func(*Job) error, state string) (string, error) {
+	job, err := jr.Find(ref)
+	if err != nil {
+		return "", err
+	}
+	if err := action(job); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Job %s (%s) is %s.", job.ID, job.Agent, state), nil
+}
+
+// Describe lists every job with its progress, one per line.
+func (jr *JobRunner) Describe() string {
+	jobs := jr.List()
+	if len(jobs) == 0 {
+		return "There are no jobs."
+	}
+	lines := make([]string, len(jobs))
+	for i, job := range jobs {
+		lines[i] = job.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// newJob adds a running job for the agent with the next ID.
+func (jr *JobRunner) newJob(agent *agents.Agent, input string, chat *Chat) *Job {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.next++
+	job := &Job{
+		ID:      fmt.Sprintf("job-%d", jr.next),
+		Agent:   agent.Name,
+		Input:   input,
+		Steps:   agent.Job,
+		Chat:    chat,
+		Started: time.Now(),
+		status:  JobRunning,
+		done:    make(chan struct{}),
+	}
+	jr.prune()
+	jr.jobs = append(jr.jobs, job)
+	return job
+}
+
+// prune drops the oldest ended jobs beyond the runner's history, with their trace cards.
+func (jr *JobRunner) prune() {
+	limit := jr.History
+	if limit <= 0 {
+		limit = DefaultJobHistory
+	}
+	ended := make([]bool, len(jr.jobs))
+	extra := -limit
+	for i, job := range jr.jobs {
+		status := job.Status()
+		ended[i] = status != JobRunning && status != JobPaused
+		if ended[i] {
+			extra++
+		}
+	}
+	if extra <= 0 {
+		return
+	}
+	kept := jr.jobs[:0]
+	for i, job := range jr.jobs {
+		if ended[i] && extra > 0 {
+			extra--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	clear(jr.jobs[len(kept):])
+	jr.jobs = kept
+}
+
+// JobRunner returns the registry's jobs, creating them on first use.
+func (r *Registry) JobRunner() *JobRunner {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if r.Jobs == nil {
+		r.Jobs = &JobRunner{}
+	}
+	return r.Jobs
+}
+
+// execJobAgent starts the agent's job and returns its start message, which is the output of the
+// agent's function, template or prompt, or a standard message when it has none.
+// Local facts set by the job agent go to the job rather than to this run.
+func (r *RunContext) execJobAgent(ctx context.Context, agent *agents.Agent, input string, name string) AgentResult {
+	job := r.Registry.JobRunner().newJob(agent, input, r.Chat)
+	r.Card.JobID = job.ID
+
+	outerFacts, outerJob := r.LocalFacts, r.JobID
+	r.LocalFacts, r.JobID = maps.Clone(r.LocalFacts), job.ID
+	var result AgentResult
+	if agent.Function != nil || agent.Template != "" || agent.Prompt != "" {
+		result = r.execAgent(ctx, agent, input, name)
+	} else {
+		result = AgentResult{Output: fmt.Sprintf("Running job: %s %s %s", agent.Name, agent.Description, job.ID), Ran: true, AgentName: name}
+		if err := r.handleAgentFacts(ctx, agent, input); err != nil {
+			result.Error = err
+		}
+	}
+	jobFacts := r.LocalFacts
+	r.LocalFacts, r.JobID = outerFacts, outerJob
+	if result.Error != nil {
+		job.end(fmt.Errorf("job %s did not start: %w", job.ID, result.Error))
+		close(job.done)
+		return result
+	}
+
+	run := &RunContext{
+		IsPrint:    r.IsPrint,
+		Chat:       r.Chat,
+		Registry:   r.Registry,
+		LocalFacts: jobFacts,
+		JobID:      job.ID,
+		library:    r.library,
+		owner:      r.owner,
+	}
+	job.Card = run.NewTraceCard(name, input)
+	job.Card.JobID = job.ID
+	run.Card = job.Card
+	r.Logf("[JOB] %s started %s: %s", name, job.ID, strings.Join(job.Steps, ", "))
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job.mu.Lock()
+	job.cancel = cancel
+	job.mu.Unlock()
+	go run.runJob(jobCtx, job)
+	return result
+}
+
+// runJob calls the job's steps in order, then tells the job's chat how it ended.
+func (r *RunContext) runJob(ctx context.Context, job *Job) {
+	defer close(job.done)
+	defer job.cancel()
+	var err error
+	for _, step := range job.Steps {
+		if err = job.proceed(ctx); err != nil {
+			break
+		}
+		res := r.CallAgent(ctx, step, job.stepInput())
+		if res.Error == nil && !res.Ran {
+			res.Error = fmt.Errorf("agent %s did not run", step)
+		}
+		if res.Error != nil {
+			err = fmt.Errorf("job %s step %s: %w", job.ID, step, res.Error)
+			break
+		}
+		job.addOutput(res.Output)
+	}
+	job.end(err)
+	notice := job.Notice()
+	job.Card.Output, job.Card.Error, job.Card.Ran = notice.Output, err, true
+	r.Logf("[JOB] %s", notice)
+	job.Chat.notifyJob(notice)
+}
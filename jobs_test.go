@@ -0,0 +1,373 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jobSpec = `
+agents:
+  checkout:
+    description: Checkout a library book
+    facts:
+      library:
+        description: The library where the book resides.
+        scope: local
+    job:
+      - check_book_availability
+      - check_out_book
+    template: |
+      Checking out book: {{ .Input }}
+      Job ID: {{ .JobID }}
+  check_book_availability:
+    description: Check that the library has the book
+    template: 'Available at {{ .Fact "library" }}: {{ .Input }}'
+  check_out_book:
+    description: Check out the book
+    prompt: "Check out the book. {{ .Input }}"
+  quick_checkout:
+    description: Check out a book without a message
+    job:
+      - check_out_book
+`
+
+// purposeProvider answers each agent and purpose with its answer.
+type purposeProvider struct {
+	answers  map[string]string // agent/purpose -> answer
+	mu       sync.Mutex
+	requests []*agents.ChatRequest
+}
+
+func (p *purposeProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, req)
+	answer, ok := p.answers[req.Agent+"/"+req.Purpose]
+	if !ok {
+		return nil, fmt.Errorf("no answer for %s/%s", req.Agent, req.Purpose)
+	}
+	return &agents.ChatResponse{Content: answer}, nil
+}
+
+func (p *purposeProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestJob(t *testing.T) {
+	reg, err := NewRegistry(jobSpec)
+	require.NoError(t, err)
+	provider := &purposeProvider{answers: map[string]string{
+		"checkout/facts":        `{"library": "Main Street"}`,
+		"checkout/memory":       `{"library": "Main Street"}`,
+		"check_out_book/prompt": "Checked out until June 1",
+	}}
+	reg.UseProvider(provider)
+	chat := NewChat("checkout")
+	var notices []JobNotice
+	chat.OnJobEnd(func(notice JobNotice) { notices = append(notices, notice) })
+
+	run := NewRun(reg, chat)
+	res := run.CallAgent(context.Background(), "checkout", "Dune")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Checking out book: Dune\nJob ID: job-1", res.Output)
+	assert.Equal(t, "job-1", run.Card.JobID)
+	assert.Empty(t, run.LocalFacts, "local facts stay inside the job")
+
+	job, ok := reg.Jobs.Job("job-1")
+	require.True(t, ok)
+	out, err := job.Wait()
+	require.NoError(t, err)
+	assert.Equal(t, "Checked out until June 1", out)
+	assert.Equal(t, JobDone, job.Status())
+	assert.Equal(t, []string{"Available at Main Street: Dune", "Checked out until June 1"}, job.Outputs())
+
+	last := provider.requests[len(provider.requests)-1]
+	assert.Equal(t, "Check out the book. Dune\n\nOutput of check_book_availability:\nAvailable at Main Street: Dune", last.Messages[len(last.Messages)-1].Content)
+
+	require.Len(t, job.Card.BranchCards, 2)
+	assert.Equal(t, "check_book_availability", job.Card.BranchCards[0].AgentName)
+	assert.Contains(t, job.Card.String(), "Job: job-1")
+
+	want := JobNotice{JobID: "job-1", Agent: "checkout", Status: JobDone, Output: "Checked out until June 1"}
+	assert.Equal(t, []JobNotice{want}, chat.Notices)
+	assert.Equal(t, []JobNotice{want}, notices)
+	assert.Equal(t, "Job job-1 (checkout) is done: Checked out until June 1", want.String())
+	assert.Nil(t, chat.Fact("library"))
+	assert.Nil(t, chat.Fact("checkout.library"))
+}
+
+func TestJob_StandardMessage(t *testing.T) {
+	reg, err := NewRegistry(jobSpec)
+	require.NoError(t, err)
+	reg.UseProvider(&purposeProvider{})
+	chat := NewChat("quick_checkout")
+
+	res := NewRun(reg, chat).CallAgent(context.Background(), "quick_checkout", "Dune")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Running job: quick_checkout Check out a book without a message job-1", res.Output)
+
+	job, ok := reg.Jobs.Job("job-1")
+	require.True(t, ok)
+	_, err = job.Wait()
+	require.Error(t, err)
+	assert.Equal(t, JobFailed, job.Status())
+	require.Len(t, chat.Notices, 1)
+	assert.True(t, strings.HasPrefix(chat.Notices[0].String(), "Job job-1 (quick_checkout) failed: job job-1 step check_out_book: "))
+}
+
+func TestLintSpecFile_Job(t *testing.T) {
+	result := LintSpecFile([]byte(jobSpec))
+	assert.True(t, result.Valid, result.Errors)
+
+	result = LintSpecFile([]byte(`
+agents:
+  checkout:
+    description: Checkout a library book
+    job:
+      - find_book
+`))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 6: The job step 'find_book' in agent 'checkout' does not reference a valid agent. Please ensure all job steps refer to existing agents.")
+}
+
+const bookingSpec = `
+agents:
+  booking:
+    description: Book a room
+    job:
+      - hold_room
+      - confirm_room
+  mainmenu:
+    description: Answer questions about bookings
+    listeners:
+      - jobs.status
+    prompt: "{{ .Input }}"
+  jobs_page:
+    description: List the jobs
+    template: "{{ .Jobs }}"
+  status_page:
+    description: Show the status of a job
+    template: "{{ .JobStatus .Input }}"
+  cancel_page:
+    description: Cancel a job
+    template: "{{ .CancelJob .Input }}"
+  pause_page:
+    description: Pause a job
+    template: "{{ .PauseJob .Input }}"
+  resume_page:
+    description: Resume a job
+    template: "{{ .ResumeJob .Input }}"
+`
+
+// bookingProvider reads tool arguments and room numbers as inputs, and has mainmenu
+// ask jobs.status about the booking before answering with its report.
+type bookingProvider struct{}
+
+func (p *bookingProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	if req.Purpose == agents.PurposeInputs {
+		if strings.HasPrefix(req.Input, "{") {
+			return &agents.ChatResponse{Content: req.Input}, nil
+		}
+		return &agents.ChatResponse{Content: `{"room": "12"}`}, nil
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role == agents.RoleTool {
+		return &agents.ChatResponse{Content: "Your booking: " + last.Content}, nil
+	}
+	return &agents.ChatResponse{ToolCalls: []agents.ToolCall{
+		{ID: "call_status", Name: req.Tools[0].Name, Arguments: `{"job": "booking"}`},
+	}}, nil
+}
+
+func (p *bookingProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+// bookingRegistry holds the room until release is closed or the job is canceled.
+// The returned channel receives when hold_room starts.
+func bookingRegistry(t *testing.T, release chan struct{}) (*Registry, chan struct{}) {
+	holding := make(chan struct{}, 1)
+	reg, err := NewRegistry(bookingSpec, true)
+	require.NoError(t, err)
+	reg.UseProvider(&bookingProvider{})
+	room := map[string]*agents.Argument{"room": {Name: "room", Type: "string", Description: "The room number", Required: true}}
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "hold_room",
+		Description: "Hold a room",
+		Inputs:      room,
+		Function: func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+			holding <- struct{}{}
+			select {
+			case <-release:
+				return fmt.Sprintf("Holding room %s", input["room"]), nil
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		},
+	})
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "confirm_room",
+		Description: "Confirm a room",
+		Inputs:      room,
+		Function: func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+			return fmt.Sprintf("Confirmed room %s", input["room"]), nil
+		},
+	})
+	return reg, holding
+}
+
+func TestJob_PauseResume(t *testing.T) {
+	release := make(chan struct{})
+	reg, holding := bookingRegistry(t, release)
+	ctx := context.Background()
+	page := func(name, input string) string {
+		res := NewRun(reg, nil).CallAgent(ctx, name, input)
+		require.NoError(t, res.Error)
+		return res.Output
+	}
+
+	assert.Equal(t, "Running job: booking Book a room job-1", page("booking", "Room 12"))
+	<-holding
+	assert.Equal(t, "Job job-1 (booking) is paused.", page("pause_page", "booking"))
+	assert.Equal(t, "[error pausing job job-1: job job-1 is paused and cannot be paused]", page("pause_page", "job-1"))
+	close(release)
+
+	job, err := reg.Jobs.Find("job-1")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(job.Outputs()) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []string{"Holding room 12"}, job.Outputs(), "a paused job does not start its next step")
+	assert.Equal(t, "job-1 (booking) paused: 1 of 2 steps finished", page("status_page", "job-1"))
+
+	assert.Equal(t, "Job job-1 (booking) is running.", page("resume_page", "job-1"))
+	out, err := job.Wait()
+	require.NoError(t, err)
+	assert.Equal(t, "Confirmed room 12", out)
+	assert.Equal(t, "job-1 (booking) done: 2 of 2 steps finished. Output: Confirmed room 12", page("jobs_page", ""))
+	assert.Equal(t, "[error resuming job job-1: job job-1 is done, not paused]", page("resume_page", "job-1"))
+	assert.Equal(t, "[error finding job nothing: there is no job nothing]", page("status_page", "nothing"))
+}
+
+func TestJob_Cancel(t *testing.T) {
+	reg, holding := bookingRegistry(t, make(chan struct{}))
+	chat := NewChat("booking")
+	ctx := context.Background()
+
+	res := NewRun(reg, chat).CallAgent(ctx, "booking", "Room 12")
+	require.NoError(t, res.Error)
+	<-holding
+	res = NewRun(reg, chat).CallAgent(ctx, "cancel_page", "job-1")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Job job-1 (booking) is canceled.", res.Output)
+
+	job, err := reg.Jobs.Find("booking")
+	require.NoError(t, err)
+	_, err = job.Wait()
+	require.EqualError(t, err, "job job-1 was canceled")
+	assert.Equal(t, JobCanceled, job.Status())
+	require.Len(t, chat.Notices, 1)
+	assert.Equal(t, "Job job-1 (booking) was canceled", chat.Notices[0].String())
+
+	res = NewRun(reg, chat).CallAgent(ctx, "cancel_page", "booking")
+	assert.Equal(t, "[error canceling job booking: job job-1 is already canceled]", res.Output)
+}
+
+func TestJob_WaitWithoutOutput(t *testing.T) {
+	job := &Job{ID: "job-1", Agent: "booking", done: make(chan struct{})}
+	job.end(nil)
+	close(job.done)
+	out, err := job.Wait()
+	assert.NoError(t, err)
+	assert.Empty(t, out, "a job that ends before any step finishes has no output")
+
+	job = &Job{ID: "job-2", Agent: "booking", done: make(chan struct{}), canceled: true}
+	job.end(nil)
+	close(job.done)
+	out, err = job.Wait()
+	assert.EqualError(t, err, "job job-2 was canceled")
+	assert.Empty(t, out)
+}
+
+func TestJobRunner_History(t *testing.T) {
+	jr := &JobRunner{History: 2}
+	agent := &agents.Agent{Name: "book", Job: []string{"check_out_book"}}
+	jr.newJob(agent, "Dune", nil)
+	for range 3 {
+		jr.newJob(agent, "Emma", nil).end(nil)
+	}
+	jr.newJob(agent, "Ulysses", nil)
+
+	ids := []string{}
+	for _, job := range jr.List() {
+		ids = append(ids, job.ID)
+	}
+	assert.Equal(t, []string{"job-1", "job-3", "job-4", "job-5"}, ids, "the oldest ended job is dropped; running jobs are kept")
+	_, ok := jr.Job("job-2")
+	assert.False(t, ok)
+	_, err := jr.Find("job-2")
+	assert.EqualError(t, err, "there is no job job-2")
+}
+
+func TestJobsLibrary(t *testing.T) {
+	release := make(chan struct{})
+	reg, holding := bookingRegistry(t, release)
+	ctx := context.Background()
+
+	res := NewRun(reg, nil).CallAgent(ctx, "booking", "Room 12")
+	require.NoError(t, res.Error)
+	<-holding
+	run := NewRun(reg, nil)
+	res = run.CallAgent(ctx, "mainmenu", "What happened to my booking?")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Your booking: job-1 (booking) running: 0 of 2 steps finished", res.Output)
+	assert.Equal(t, "jobs.status", run.Card.BranchCards[0].AgentName, "the jobs__status tool call runs jobs.status")
+
+	res = NewRun(reg, nil).CallAgent(ctx, "jobs.cancel", `{"job": "job-7"}`)
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Cannot cancel job job-7: there is no job job-7.", res.Output)
+	res = NewRun(reg, nil).CallAgent(ctx, "jobs.pause", `{"job": "booking"}`)
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Job job-1 (booking) is paused.", res.Output)
+
+	close(release)
+	job, err := reg.Jobs.Find("job-1")
+	require.NoError(t, err)
+	require.NoError(t, job.Cancel())
+	_, err = job.Wait()
+	assert.Error(t, err)
+}
+
+func TestJobsLibrary_ToolName(t *testing.T) {
+	server, _, bodies := chatCompletionServer(t, "No jobs are running.")
+	t.Setenv("LOCAL_GPT_KEY", "secret")
+	reg, err := NewRegistry(fmt.Sprintf(`
+providers:
+  local-gpt:
+    base_url: %s/v1
+    api_key_env: LOCAL_GPT_KEY
+    model: gpt-4o
+agents:
+  mainmenu:
+    description: Answer questions about jobs
+    provider: local-gpt
+    listeners:
+      - jobs.status
+    prompt: "{{ .Input }}"
+`, server.URL))
+	require.NoError(t, err)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "mainmenu", "Is anything running?")
+	require.NoError(t, res.Error)
+	tools := (<-bodies)["tools"].([]any)
+	require.Len(t, tools, 1)
+	assert.Equal(t, "jobs__status", tools[0].(map[string]any)["function"].(map[string]any)["name"],
+		"providers only accept tool names without dots")
+}
@@ -0,0 +1,107 @@
+package agencia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/robbyriverside/agencia/agents"
+)
+
+// jobArgument names the job a jobs library agent acts on.
+func jobArgument(required bool) map[string]*agents.Argument {
+	return map[string]*agents.Argument{
+		"job": {
+			Description: "The job ID, such as job-1, or the name of the job's agent",
+			Type:        "string",
+			Required:    required,
+		},
+	}
+}
+
+// jobsAgents is the built-in jobs library.  Its agents let a start agent answer questions
+// about jobs and control them when they are used as listeners:
+//
+//	listeners:
+//	  - jobs.status
+//	  - jobs.cancel
+var jobsAgents = map[string]*agents.Agent{
+	"status": {
+		Name:        "jobs.status",
+		Description: "Report the progress of a job, or of every job when none is named.",
+		Inputs:      jobArgument(false),
+		Function:    jobStatus,
+	},
+	"cancel": {
+		Name:        "jobs.cancel",
+		Description: "Cancel a job.",
+		Inputs:      jobArgument(true),
+		Function: func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+			return controlJob(ctx, input, "cancel", (*Job).Cancel, JobCanceled)
+		},
+	},
+	"pause": {
+		Name:        "jobs.pause",
+		Description: "Pause a job before its next step.",
+		Inputs:      jobArgument(true),
+		Function: func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+			return controlJob(ctx, input, "pause", (*Job).Pause, JobPaused)
+		},
+	},
+	"resume": {
+		Name:        "jobs.resume",
+		Description: "Resume a paused job.",
+		Inputs:      jobArgument(true),
+		Function: func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+			return controlJob(ctx, input, "resume", (*Job).Resume, JobRunning)
+		},
+	},
+}
+
+type jobRunnerKey struct{}
+
+// withJobRunner returns a context that gives function agents the jobs of the run's registry.
+func withJobRunner(ctx context.Context, jobs *JobRunner) context.Context {
+	return context.WithValue(ctx, jobRunnerKey{}, jobs)
+}
+
+func jobRunnerFrom(ctx context.Context) (*JobRunner, error) {
+	jobs, ok := ctx.Value(jobRunnerKey{}).(*JobRunner)
+	if !ok {
+		return nil, errors.New("jobs are not available outside of a run")
+	}
+	return jobs, nil
+}
+
+// jobStatus reports on the named job, or on every job.
+// Unknown jobs are reported in the output, so the model can tell the user.
+func jobStatus(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+	jobs, err := jobRunnerFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	ref, _ := input["job"].(string)
+	if ref == "" {
+		return jobs.Describe(), nil
+	}
+	job, err := jobs.Find(ref)
+	if err != nil {
+		return fmt.Sprintf("Cannot report on job %s: %v.", ref, err), nil
+	}
+	return job.String(), nil
+}
+
+// controlJob cancels, pauses or resumes the named job.
+// A job that is unknown or cannot be changed is reported in the output, so the model can tell the user.
+func controlJob(ctx context.Context, input map[string]any, verb string, action func(*Job) error, state string) (string, error) {
+	jobs, err := jobRunnerFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	ref, _ := input["job"].(string)
+	msg, err := jobs.control(ref, action, state)
+	if err != nil {
+		return fmt.Sprintf("Cannot %s job %s: %v.", verb, ref, err), nil
+	}
+	return msg, nil
+}
@@ -9,32 +9,8 @@ import (
 
 	"github.com/qdrant/go-client/qdrant"
 	"github.com/robbyriverside/agencia/agents"
-	"github.com/sashabaranov/go-openai"
 )
 
-var (
-	openaiClient      *openai.Client
-	openaiInitError   error
-	openaiInitialized bool
-)
-
-func getOpenAIClient() (*openai.Client, error) {
-	if openaiInitialized {
-		return openaiClient, openaiInitError
-	}
-	openaiInitialized = true
-
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		openaiInitError = fmt.Errorf("OPENAI_API_KEY environment variable is not set")
-		return nil, openaiInitError
-	}
-
-	client := openai.NewClient(apiKey)
-	openaiClient = client
-	return client, nil
-}
-
 var Agents = map[string]*agents.Agent{
 	"search": {
 		Description: "Search the knowledge base for relevant passages.",
@@ -245,25 +221,16 @@ func query(name string, vec []float32, limit uint64) ([]*qdrant.ScoredPoint, err
 	return searchResult, nil
 }
 
+// embedText embeds with the registry's embedder, so queries match the vectors of the collection.
 func embedText(ctx context.Context, text string) ([]float32, error) {
-	client, err := getOpenAIClient()
+	vectors, err := agents.EmbedderFrom(ctx).Embed(ctx, []string{text})
 	if err != nil {
 		return nil, err
 	}
-	embedding, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
-		Input: []string{text},
-		Model: openai.AdaEmbeddingV2,
-	})
-	if err != nil {
-		return nil, err
-	}
-	// Convert []float64 to []float32
-	vec := embedding.Data[0].Embedding
-	result := make([]float32, len(vec))
-	for i, v := range vec {
-		result[i] = float32(v)
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("no embedding returned for query")
 	}
-	return result, nil
+	return vectors[0], nil
 }
 
 func Search(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
@@ -303,7 +270,7 @@ func AnswerWithSources(ctx context.Context, input map[string]any, agent *agents.
 	}
 	question := input["question"].(string)
 	prompt := fmt.Sprintf("Use the following sources to answer this question:\n\n%s\n\nQuestion: %s", sources, question)
-	return agents.CallOpenAI(ctx, prompt)
+	return agents.CallAI(ctx, agent, prompt)
 }
 
 func ExtractFacts(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
@@ -313,5 +280,5 @@ func ExtractFacts(ctx context.Context, input map[string]any, agent *agents.Agent
 		return "", err
 	}
 	prompt := fmt.Sprintf("Extract the most important facts from the following:\n\n%s", sources)
-	return agents.CallOpenAI(ctx, prompt)
+	return agents.CallAI(ctx, agent, prompt)
 }
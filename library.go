@@ -0,0 +1,211 @@
+package agencia
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LibraryPathEnv names the directories searched for library specs, separated like PATH.
+// A library named support is read from support.yaml or support.yml in the first directory that has one.
+const LibraryPathEnv = "AGENCIA_PATH"
+
+// librariesMu guards the libraries of every registry, which are loaded from AGENCIA_PATH on first use.
+var librariesMu sync.Mutex
+
+// Library returns the registry of the named library: one of the spec's libraries,
+// a library written in Go such as rag, or a library spec found on AGENCIA_PATH.
+func (r *Registry) Library(name string) (*Registry, error) {
+	librariesMu.Lock()
+	defer librariesMu.Unlock()
+	if lib, ok := r.Libraries[name]; ok {
+		return lib, nil
+	}
+	if lib, ok := libraries[name]; ok {
+		return &lib, nil
+	}
+	file, err := findLibrary(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.loadLibrary(file, name); err != nil {
+		return nil, err
+	}
+	return r.Libraries[name], nil
+}
+
+// findLibrary searches AGENCIA_PATH for the spec of a library.
+func findLibrary(name string) (string, error) {
+	for _, dir := range filepath.SplitList(os.Getenv(LibraryPathEnv)) {
+		for _, ext := range []string{".yaml", ".yml"} {
+			file := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(file); err == nil {
+				return file, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unknown library %s: it is not in the spec's libraries or on %s", name, LibraryPathEnv)
+}
+
+// LibrarySource is an entry of a spec's libraries section, which names each library the spec calls:
+// the path of the library's spec, relative to the spec that names it, or the library's agents inline.
+//
+//	libraries:
+//	  support: libs/support.yaml
+//	  greetings:
+//	    agents:
+//	      hello: ...
+type LibrarySource struct {
+	Path string     // the file of the library spec
+	Spec *AgentSpec // the inline library spec
+}
+
+func (l *LibrarySource) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		l.Path = node.Value
+		return nil
+	}
+	l.Spec = &AgentSpec{}
+	return node.Decode(l.Spec)
+}
+
+func (l *LibrarySource) MarshalYAML() (any, error) {
+	if l.Spec != nil {
+		return l.Spec, nil
+	}
+	return l.Path, nil
+}
+
+// loadLibraries adds the libraries of a spec's libraries section to the registry.
+func (r *Registry) loadLibraries(sources map[string]*LibrarySource) error {
+	librariesMu.Lock()
+	defer librariesMu.Unlock()
+	return r.loadLibrarySources(sources)
+}
+
+func (r *Registry) loadLibrarySources(sources map[string]*LibrarySource) error {
+	for _, name := range slices.Sorted(maps.Keys(sources)) {
+		src := sources[name]
+		var err error
+		switch {
+		case src == nil:
+			err = fmt.Errorf("library %s has neither a path nor agents", name)
+		case src.Spec != nil:
+			if err = r.addLibrary(name, src.Spec, ""); err != nil {
+				err = fmt.Errorf("library %s: %w", name, err)
+			}
+		default:
+			err = r.loadLibrary(src.Path, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLibrary adds the library spec in the file to the registry's libraries.
+// The file names its library with library: <name>, which must be the name it is wanted by, if any.
+func (r *Registry) loadLibrary(file, want string) error {
+	spec, err := loadAgentSpecFile(file)
+	if err != nil {
+		return fmt.Errorf("library %s: %w", file, err)
+	}
+	name := spec.Library
+	switch {
+	case name == "" && want == "":
+		return fmt.Errorf("library %s does not declare its name with library: <name>", file)
+	case name == "":
+		name = want
+	case want != "" && name != want:
+		return fmt.Errorf("library %s declares library %s, not %s", file, name, want)
+	}
+	abs, _ := filepath.Abs(file)
+	if err := r.addLibrary(name, spec, abs); err != nil {
+		return fmt.Errorf("library %s: %w", file, err)
+	}
+	return nil
+}
+
+// addLibrary registers the agents of a library spec read from the file, which is empty for an inline spec.
+// The libraries a library uses are added to the registry as well, so every library can call them.
+func (r *Registry) addLibrary(name string, spec *AgentSpec, file string) error {
+	if strings.Contains(name, ".") {
+		return fmt.Errorf("the library name %q cannot contain a dot", name)
+	}
+	if _, ok := libraries[name]; ok {
+		return fmt.Errorf("%s is a built-in library", name)
+	}
+	if prev, ok := r.libraryFiles[name]; ok {
+		if prev == "" || prev != file {
+			return fmt.Errorf("library %s is defined twice", name)
+		}
+		return nil // loaded already, by another spec that uses it
+	}
+	lib, err := registerAgents(spec)
+	if err != nil {
+		return err
+	}
+	if r.Libraries == nil {
+		r.Libraries = map[string]*Registry{}
+		r.libraryFiles = map[string]string{}
+	}
+	r.Libraries[name] = lib
+	r.libraryFiles[name] = file
+	return r.loadLibrarySources(spec.Libraries)
+}
+
+// owner returns the registry whose defaults, profiles and roles the agents of a library run with:
+// the library's own spec, or this registry for agents outside a library and libraries written in Go.
+func (r *Registry) owner(library string) *Registry {
+	librariesMu.Lock()
+	defer librariesMu.Unlock()
+	if lib, ok := r.Libraries[library]; ok {
+		return lib
+	}
+	return r
+}
+
+// settings returns the registry whose settings the running agent uses.
+func (r *RunContext) settings() *Registry {
+	if r.owner == nil {
+		return r.Registry
+	}
+	return r.owner
+}
+
+// libraryOf returns the library of a qualified agent name, or an empty string.
+func libraryOf(name string) string {
+	lib, _, ok := strings.Cut(name, ".")
+	if !ok {
+		return ""
+	}
+	return lib
+}
+
+// resolve qualifies an unqualified agent name called by a library agent
+// when that library has the agent, so library agents call their own agents first.
+func (r *RunContext) resolve(name string) string {
+	return r.Registry.resolveIn(r.library, name)
+}
+
+// resolveIn qualifies an unqualified agent name with the library when the library has the agent.
+func (r *Registry) resolveIn(library, name string) string {
+	if library == "" || strings.Contains(name, ".") {
+		return name
+	}
+	lib, err := r.Library(library)
+	if err != nil {
+		return name
+	}
+	if _, ok := lib.Agents[name]; ok {
+		return library + "." + name
+	}
+	return name
+}
@@ -0,0 +1,214 @@
+package agencia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibrary(t *testing.T) {
+	reg, err := LoadRegistry("testdata/libraries/main.yaml")
+	require.NoError(t, err)
+	require.Contains(t, reg.Libraries, "support")
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "mainmenu", "charged twice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Triage: billing (charged twice) / general (charged twice)", res.Output, "library agents call their own agents first")
+
+	triage := run.Card.BranchCards[0]
+	assert.Equal(t, "support.triage", triage.AgentName)
+	assert.Equal(t, "support.classify", triage.BranchCards[0].AgentName)
+	assert.Equal(t, "classify", run.Card.BranchCards[1].AgentName)
+}
+
+func TestLibrary_Path(t *testing.T) {
+	t.Setenv(LibraryPathEnv, "testdata/nowhere:testdata/libpath")
+	reg, err := NewRegistry(`
+libraries:
+  support: testdata/libraries/support.yaml
+agents:
+  refunds:
+    description: Refund the order
+    template: '{{ .Get "billing.refund" }}'
+  unknown:
+    description: Call a library that does not exist
+    template: '{{ .Get "nope.refund" }}'
+`)
+	require.NoError(t, err)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "refunds", "order 7")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Refund for order 7 by billing (order 7)", res.Output)
+	assert.Contains(t, reg.Libraries, "billing", "libraries on AGENCIA_PATH are loaded on first use")
+
+	res = NewRun(reg, nil).CallAgent(context.Background(), "unknown", "order 7")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "[error calling nope.refund: could not find agent: nope.refund: unknown library nope: it is not in the spec's libraries or on AGENCIA_PATH]", res.Output)
+}
+
+func TestLibrary_Errors(t *testing.T) {
+	_, err := NewRegistry(`
+libraries:
+  help: testdata/libraries/support.yaml
+agents:
+  hello:
+    description: Say hello
+    template: Hello
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "library testdata/libraries/support.yaml declares library support, not help")
+
+	_, err = NewRegistry(`
+libraries:
+  rag:
+    agents:
+      search:
+        description: Search
+        template: Nothing
+agents:
+  hello:
+    description: Say hello
+    template: Hello
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rag is a built-in library")
+
+	result := LintSpecFile([]byte(`
+library: support.desk
+agents:
+  triage:
+    description: Triage a ticket
+    template: Triage
+`))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 2: The library name must be a name without dots, such as support, got 'support.desk'.")
+
+	result = LintSpecFile([]byte(`
+library: support
+agents:
+  triage:
+    description: Triage a ticket
+    template: Triage
+`))
+	assert.True(t, result.Valid)
+	assert.Empty(t, result.Warnings, "library agents are used by other specs")
+}
+
+func TestLibrary_Inline(t *testing.T) {
+	reg, err := NewRegistry(`
+libraries:
+  greetings:
+    agents:
+      hello:
+        description: Say hello
+        template: 'Hello, {{ .Get "name" }}!'
+      name:
+        description: The name to greet
+        template: '{{ .Input }}'
+agents:
+  welcome:
+    description: Welcome the user
+    template: '{{ .Get "greetings.hello" }}'
+`)
+	require.NoError(t, err)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "welcome", "Ada")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Hello, Ada!", res.Output)
+}
+
+func TestLibrary_Settings(t *testing.T) {
+	reg, err := NewRegistry(`
+libraries:
+  support:
+    defaults:
+      model: gpt-4o-mini
+      temperature: 0.1
+    roles:
+      clerk: You are a support clerk.
+    agents:
+      triage:
+        description: Triage the request
+        role: clerk
+        cache: 1h
+        prompt: "Triage: {{ .Input }}"
+defaults:
+  model: gpt-4o
+roles:
+  clerk: You are a billing clerk.
+agents:
+  triage:
+    description: Triage the request without caching
+    prompt: "Bill: {{ .Input }}"
+  desk:
+    description: Triage twice
+    template: '{{ .Get "support.triage" }} {{ .Get "support.triage" }}'
+`)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{"billing"}}
+	reg.UseProvider(provider)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "desk", "charged twice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "billing billing", res.Output, "the library agent's cache answers the second call")
+	require.Len(t, provider.requests, 1)
+	req := provider.requests[0]
+	assert.Equal(t, "gpt-4o-mini", req.Model, "library agents run with the library's defaults")
+	require.NotNil(t, req.Temperature)
+	assert.Equal(t, float32(0.1), *req.Temperature)
+	assert.Equal(t, agents.Message{Role: agents.RoleSystem, Content: "You are a support clerk."}, req.Messages[0],
+		"library agents take their roles from the library")
+}
+
+func TestLibrary_RemoteNested(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	for name, spec := range map[string]string{
+		"library path": `
+libraries:
+  frontdesk:
+    libraries:
+      support: testdata/libraries/support.yaml
+    agents:
+      greet:
+        description: Greet the customer
+        template: Hello
+agents:
+  desk:
+    description: Run the desk
+    template: '{{ .Get "frontdesk.greet" }}'
+`,
+		"disk cache": fmt.Sprintf(`
+libraries:
+  support:
+    cache:
+      backend: disk
+      dir: %s
+    agents:
+      triage:
+        description: Triage the request
+        template: Triage
+agents:
+  desk:
+    description: Run the desk
+    template: '{{ .Get "support.triage" }}'
+`, dir),
+	} {
+		body, err := json.Marshal(runRequest{Spec: spec, Agent: "desk", Input: "help"})
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", bytes.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+	}
+	assert.NoDirExists(t, dir, "the server never opens a disk cache for a nested library")
+}
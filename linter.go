@@ -1,12 +1,15 @@
 package agencia
 
 import (
+	_ "embed"
 	"encoding/json"
 	"fmt"
-	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/robbyriverside/agencia/agents"
 	"github.com/santhosh-tekuri/jsonschema/v5"
 	"gopkg.in/yaml.v3"
 )
@@ -34,13 +37,16 @@ func (r LintResult) Result() string {
 	return result
 }
 
+// LintSpecFile lints a spec that was not read from a file.  Its includes are relative to the working directory.
 func LintSpecFile(source []byte) LintResult {
-	var errors []string
-	duplicateErrors := checkDuplicateAgentNames(source)
-	errors = append(errors, duplicateErrors...)
+	return LintSpecPath(source, "")
+}
 
-	var root yaml.Node
-	err := yaml.Unmarshal(source, &root)
+// LintSpecPath lints the spec read from file.  Its includes are relative to the file's directory,
+// and agents defined twice are reported with the file and line of both definitions.
+func LintSpecPath(source []byte, file string) LintResult {
+	var errors []string
+	rootMap, duplicateErrors, err := mergeSpec(source, file)
 	if err != nil {
 		errors = append(errors, fmt.Sprintf("YAML parsing error: %v", err))
 		return LintResult{
@@ -56,20 +62,32 @@ func LintSpecFile(source []byte) LintResult {
 	referencedAgents := map[string]bool{}
 	definedAgents := map[string]*yaml.Node{}
 
-	// Locate top-level "agents" mapping with defensive traversal
-	var agentsNode *yaml.Node
-	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
-		rootMap := root.Content[0]
-		if rootMap.Kind == yaml.MappingNode {
-			for i := 0; i < len(rootMap.Content)-1; i += 2 {
-				keyNode := rootMap.Content[i]
-				if keyNode.Value == "agents" {
-					agentsNode = rootMap.Content[i+1]
-					break
-				}
-			}
+	// Locate top-level "agents" and "defaults" mappings with defensive traversal
+	var agentsNode, defaultsNode, providersNode, cacheNode, pricesNode, rolesNode, toolCallsNode, embeddingsNode, libraryNode *yaml.Node
+	for i := 0; i < len(rootMap.Content)-1; i += 2 {
+		keyNode := rootMap.Content[i]
+		switch keyNode.Value {
+		case "agents":
+			agentsNode = rootMap.Content[i+1]
+		case "defaults":
+			defaultsNode = rootMap.Content[i+1]
+		case "providers":
+			providersNode = rootMap.Content[i+1]
+		case "cache":
+			cacheNode = rootMap.Content[i+1]
+		case "prices":
+			pricesNode = rootMap.Content[i+1]
+		case "roles":
+			rolesNode = rootMap.Content[i+1]
+		case "tool_calls":
+			toolCallsNode = rootMap.Content[i+1]
+		case "embeddings":
+			embeddingsNode = rootMap.Content[i+1]
+		case "library":
+			libraryNode = rootMap.Content[i+1]
 		}
 	}
+	errors = append(errors, duplicateErrors...)
 	if agentsNode == nil {
 		errors = append(errors, "Problem: The 'agents' section is missing from the YAML root. Please add an 'agents' mapping to define your agents.")
 		return LintResult{
@@ -87,6 +105,52 @@ func LintSpecFile(source []byte) LintResult {
 		}
 	}
 
+	providerNames := map[string]bool{"openai": true, "anthropic": true}
+	if providersNode != nil {
+		errors = append(errors, checkProviders(providersNode, providerNames)...)
+	}
+
+	if cacheNode != nil {
+		errors = append(errors, checkCacheConfig(cacheNode)...)
+	}
+
+	if libraryNode != nil && (libraryNode.Kind != yaml.ScalarNode || libraryNode.Value == "" || strings.Contains(libraryNode.Value, ".")) {
+		errors = append(errors, fmt.Sprintf("Problem: Line %d: The library name must be a name without dots, such as support, got '%s'.", libraryNode.Line, libraryNode.Value))
+	}
+
+	if pricesNode != nil {
+		errors = append(errors, checkPrices(pricesNode)...)
+	}
+
+	if toolCallsNode != nil {
+		errors = append(errors, checkToolCalls("The spec", toolCallsNode)...)
+	}
+
+	defaultModel := agents.DefaultModel
+	if embeddingsNode != nil {
+		errors = append(errors, checkEmbeddings(embeddingsNode, providerNames)...)
+	}
+
+	if defaultsNode != nil {
+		if defaultsNode.Kind != yaml.MappingNode {
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: The 'defaults' section must be a mapping of model settings.", defaultsNode.Line))
+		} else {
+			for i := 0; i < len(defaultsNode.Content)-1; i += 2 {
+				key := defaultsNode.Content[i].Value
+				if !modelParamKeys[key] {
+					warnings = append(warnings, fmt.Sprintf("Reminder: Line %d: Unknown model setting '%s' in defaults.", defaultsNode.Content[i].Line, key))
+					continue
+				}
+				if key == "model" {
+					defaultModel = defaultsNode.Content[i+1].Value
+				}
+				if problem := checkModelParam("The spec defaults", key, defaultsNode.Content[i+1], providerNames); problem != "" {
+					errors = append(errors, problem)
+				}
+			}
+		}
+	}
+
 	// Collect all defined agents
 	for i := 0; i < len(agentsNode.Content)-1; i += 2 {
 		agentNameNode := agentsNode.Content[i]
@@ -102,14 +166,42 @@ func LintSpecFile(source []byte) LintResult {
 	// Regex to find .Get "agentname" and .Start "agentname"
 	referenceRegex := regexp.MustCompile(`\.(Get|Start)\s+"([^"]+)"`)
 
+	roleNames := map[string]bool{}
+	if rolesNode != nil {
+		if rolesNode.Kind != yaml.MappingNode {
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: The 'roles' section must map role names to persona templates.", rolesNode.Line))
+		} else {
+			for i := 0; i < len(rolesNode.Content)-1; i += 2 {
+				roleName := rolesNode.Content[i].Value
+				roleNode := rolesNode.Content[i+1]
+				roleNames[roleName] = true
+				if roleNode.Kind != yaml.ScalarNode {
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Role '%s' must be a template string.", roleNode.Line, roleName))
+					continue
+				}
+				for _, match := range referenceRegex.FindAllStringSubmatch(roleNode.Value, -1) {
+					refAgent := match[2]
+					if !agentNames[refAgent] && !strings.Contains(refAgent, ".") {
+						errors = append(errors, fmt.Sprintf("Problem: Line %d: Role '%s' references undefined agent '%s' via .%s. Please ensure all referenced agents exist.", roleNode.Line, roleName, refAgent, match[1]))
+					} else {
+						referencedAgents[refAgent] = true
+					}
+				}
+			}
+		}
+	}
+
 	// Validate each agent
 	for name, node := range definedAgents {
 		kindSet := map[string]bool{}
 		hasDescription := false
-		var hasInputs bool
+		var hasInputs, hasJob bool
 		var inputsNode *yaml.Node
 		var listenersNode *yaml.Node
 		var factsNode *yaml.Node
+		var promptNode, overflowNode, samplesNode, voteNode *yaml.Node
+		var maxInputTokens int
+		model := defaultModel
 		for i := 0; i < len(node.Content)-1; i += 2 {
 			key := node.Content[i].Value
 			val := node.Content[i+1]
@@ -128,9 +220,80 @@ func LintSpecFile(source []byte) LintResult {
 						}
 					}
 				}
+				if key == "prompt" {
+					promptNode = val
+				}
+				if key == "function" {
+					if _, ok := LookupFunction(val.Value); !ok {
+						errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' uses unknown function '%s'. Register it in Go with agencia.RegisterFunction.", val.Line, name, val.Value))
+					}
+				}
 				if key == "alias" && val.Value == name {
 					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' is an alias that references itself. This creates an infinite loop.", val.Line, name))
 				}
+			case "provider", "model", "temperature", "max_tokens", "top_p", "seed", "stop":
+				if key == "model" {
+					model = val.Value
+				}
+				if problem := checkModelParam(fmt.Sprintf("Agent '%s'", name), key, val, providerNames); problem != "" {
+					errors = append(errors, problem)
+				}
+			case "cache":
+				if _, err := agents.ParseCachePolicy(val.Value); err != nil || val.Kind != yaml.ScalarNode {
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' cache must be true, false or a duration like 10m, got '%s'.", val.Line, name, val.Value))
+				}
+			case "tool_calls":
+				errors = append(errors, checkToolCalls(fmt.Sprintf("Agent '%s'", name), val)...)
+			case "fallback":
+				errors = append(errors, checkFallback(name, val, providerNames)...)
+			case "timeout":
+				if d, err := time.ParseDuration(val.Value); err != nil || d <= 0 {
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' timeout must be a duration like 30s, got '%s'.", val.Line, name, val.Value))
+				}
+			case "max_input_tokens":
+				if n, err := strconv.Atoi(val.Value); err != nil || n <= 0 {
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' max_input_tokens must be a positive integer, got '%s'.", val.Line, name, val.Value))
+				} else {
+					maxInputTokens = n
+				}
+			case "overflow":
+				overflowNode = val
+				policy, err := agents.ParseOverflowPolicy(val.Value)
+				switch {
+				case err != nil || val.Kind != yaml.ScalarNode:
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' overflow must be truncate_tail, truncate_head, drop_history or summarize:<agent>, got '%s'.", val.Line, name, val.Value))
+				case policy.Agent == "" || strings.Contains(policy.Agent, "."):
+				case !agentNames[policy.Agent]:
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' overflow summarizes with undefined agent '%s'. Please ensure all referenced agents exist.", val.Line, name, policy.Agent))
+				default:
+					referencedAgents[policy.Agent] = true
+				}
+			case "samples":
+				samplesNode = val
+				if n, err := strconv.Atoi(val.Value); err != nil || n <= 0 {
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' samples must be a positive integer, got '%s'.", val.Line, name, val.Value))
+				}
+			case "vote":
+				voteNode = val
+				policy, err := agents.ParseVotePolicy(val.Value)
+				switch {
+				case err != nil || val.Kind != yaml.ScalarNode:
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' vote must be majority, unanimous or judge:<agent>, got '%s'.", val.Line, name, val.Value))
+				case policy.Agent == "" || strings.Contains(policy.Agent, "."):
+				case !agentNames[policy.Agent]:
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' vote is judged by undefined agent '%s'. Please ensure all referenced agents exist.", val.Line, name, policy.Agent))
+				default:
+					referencedAgents[policy.Agent] = true
+				}
+			case "history":
+				var policy agents.HistoryPolicy
+				if err := val.Decode(&policy); err != nil || policy == (agents.HistoryPolicy{}) {
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' history must be a number of turns or a mapping with turns and tokens.", val.Line, name))
+				}
+			case "role":
+				if !roleNames[val.Value] && !strings.ContainsAny(strings.TrimSpace(val.Value), " \n") {
+					warnings = append(warnings, fmt.Sprintf("Reminder: Line %d: Agent '%s' has role '%s', which is not defined in roles. It will be sent to the model as written.", val.Line, name, val.Value))
+				}
 			case "description":
 				hasDescription = true
 			case "inputs":
@@ -139,11 +302,12 @@ func LintSpecFile(source []byte) LintResult {
 			case "listeners":
 				listenersNode = val
 			case "job":
+				hasJob = len(val.Content) > 0
 				for _, item := range val.Content {
 					if !agentNames[item.Value] && !strings.Contains(item.Value, ".") {
 						errors = append(errors, fmt.Sprintf("Problem: Line %d: The job step '%s' in agent '%s' does not reference a valid agent. Please ensure all job steps refer to existing agents.", item.Line, item.Value, name))
 					} else {
-						usedAgents[item.Value] = true
+						referencedAgents[item.Value] = true // job steps are called, not offered as tools
 					}
 				}
 			case "facts":
@@ -206,7 +370,22 @@ func LintSpecFile(source []byte) LintResult {
 			}
 		}
 
-		if len(kindSet) == 0 {
+		if overflowNode != nil && maxInputTokens == 0 {
+			warnings = append(warnings, fmt.Sprintf("Reminder: Line %d: Agent '%s' has an overflow strategy but no max_input_tokens, so it is never used.", overflowNode.Line, name))
+		}
+		if voteNode != nil && samplesNode == nil {
+			warnings = append(warnings, fmt.Sprintf("Reminder: Line %d: Agent '%s' has a vote but no samples, so it is never used.", voteNode.Line, name))
+		}
+		if samplesNode != nil && promptNode == nil {
+			warnings = append(warnings, fmt.Sprintf("Reminder: Line %d: Agent '%s' has samples, which only apply to prompt agents.", samplesNode.Line, name))
+		}
+		if promptNode != nil {
+			if warning := checkPromptTokens(name, promptNode, model, maxInputTokens); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+
+		if len(kindSet) == 0 && !hasJob {
 			errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' missing: prompt, template, or alias.", node.Line, name))
 		} else if len(kindSet) > 1 {
 			errors = append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' defines multiple action types: %v. Please specify only one of: prompt, template, or alias.", node.Line, name, keys(kindSet)))
@@ -289,9 +468,9 @@ func LintSpecFile(source []byte) LintResult {
 		usedAgents[ref] = true
 	}
 
-	// Detect unused agents
+	// Detect unused agents; the agents of a library are used by other specs
 	for name := range agentNames {
-		if !usedAgents[name] {
+		if !usedAgents[name] && libraryNode == nil {
 			warnings = append(warnings, fmt.Sprintf("Reminder: Agent '%s' is defined but never used. Ignore if starting agent.", name))
 		}
 	}
@@ -403,7 +582,7 @@ func LintSpecFile(source []byte) LintResult {
 
 	// Only run schema validation if no errors so far
 	if len(errors) == 0 {
-		schemaErrors := validateAgainstSchema(source)
+		schemaErrors := validateAgainstSchema(rootMap)
 		errors = append(errors, schemaErrors...)
 	}
 
@@ -416,77 +595,286 @@ func LintSpecFile(source []byte) LintResult {
 	}
 }
 
-func keys(m map[string]bool) []string {
-	var out []string
-	for k := range m {
-		out = append(out, k)
+// modelParamKeys are the model settings allowed on an agent and in the spec defaults.
+var modelParamKeys = map[string]bool{
+	"provider":    true,
+	"model":       true,
+	"temperature": true,
+	"max_tokens":  true,
+	"top_p":       true,
+	"seed":        true,
+	"stop":        true,
+}
+
+// checkModelParam returns a problem with a model setting or "" when it is valid.
+func checkModelParam(owner, key string, val *yaml.Node, providerNames map[string]bool) string {
+	switch key {
+	case "provider":
+		if !providerNames[val.Value] {
+			return fmt.Sprintf("Problem: Line %d: %s uses undefined provider '%s'. Please declare it in the providers section.", val.Line, owner, val.Value)
+		}
+	case "model":
+		if val.Kind != yaml.ScalarNode || strings.TrimSpace(val.Value) == "" {
+			return fmt.Sprintf("Problem: Line %d: %s has an empty model. Please name a model such as gpt-4o.", val.Line, owner)
+		}
+	case "temperature", "top_p":
+		max := 2.0
+		if key == "top_p" {
+			max = 1.0
+		}
+		f, err := strconv.ParseFloat(val.Value, 64)
+		if val.Kind != yaml.ScalarNode || err != nil || f < 0 || f > max {
+			return fmt.Sprintf("Problem: Line %d: %s has an invalid %s '%s'. It must be a number from 0 to %g.", val.Line, owner, key, val.Value, max)
+		}
+	case "max_tokens":
+		n, err := strconv.Atoi(val.Value)
+		if val.Kind != yaml.ScalarNode || err != nil || n < 1 {
+			return fmt.Sprintf("Problem: Line %d: %s has an invalid max_tokens '%s'. It must be a positive integer.", val.Line, owner, val.Value)
+		}
+	case "seed":
+		if _, err := strconv.Atoi(val.Value); val.Kind != yaml.ScalarNode || err != nil {
+			return fmt.Sprintf("Problem: Line %d: %s has an invalid seed '%s'. It must be an integer.", val.Line, owner, val.Value)
+		}
+	case "stop":
+		if val.Kind != yaml.SequenceNode {
+			return fmt.Sprintf("Problem: Line %d: %s has an invalid stop. It must be a list of strings.", val.Line, owner)
+		}
+		if len(val.Content) > 4 {
+			return fmt.Sprintf("Problem: Line %d: %s has %d stop sequences. At most 4 are allowed.", val.Line, owner, len(val.Content))
+		}
 	}
-	return out
+	return ""
 }
 
-func checkDuplicateAgentNames(source []byte) []string {
-	lines := strings.Split(string(source), "\n")
-	agentNames := map[string]int{}
+// checkProviders validates the providers section and collects the provider names.
+func checkProviders(node *yaml.Node, providerNames map[string]bool) []string {
 	var errors []string
-	inAgentsSection := false
-	agentsIndent := -1
-	firstAgentIndent := -1
-
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "agents:") {
-			inAgentsSection = true
-			agentsIndent = len(line) - len(strings.TrimLeft(line, " "))
+	if node.Kind != yaml.MappingNode {
+		return append(errors, fmt.Sprintf("Problem: Line %d: The 'providers' section must be a mapping of provider profiles.", node.Line))
+	}
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		name := node.Content[i].Value
+		profile := node.Content[i+1]
+		providerNames[name] = true
+		if profile.Kind != yaml.MappingNode {
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: Provider '%s' must be a mapping.", profile.Line, name))
 			continue
 		}
-		if inAgentsSection {
-			// Check if line is empty or a comment
-			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-				continue
+		var providerType, baseURL string
+		for j := 0; j < len(profile.Content)-1; j += 2 {
+			switch profile.Content[j].Value {
+			case "type":
+				providerType = profile.Content[j+1].Value
+			case "base_url":
+				baseURL = profile.Content[j+1].Value
+			case "retry":
+				errors = append(errors, checkRetry(name, profile.Content[j+1])...)
 			}
-			// Determine current line indent
-			currentIndent := len(line) - len(strings.TrimLeft(line, " "))
-			if currentIndent <= agentsIndent {
-				// End of agents section
-				break
-			}
-			// Determine first agent indent if not set and line looks like an agent name key
-			if firstAgentIndent == -1 && strings.Contains(trimmed, ":") {
-				parts := strings.SplitN(trimmed, ":", 2)
-				key := strings.TrimSpace(parts[0])
-				if !strings.Contains(key, ".") {
-					firstAgentIndent = currentIndent
-				}
-			}
-			// Only treat lines at the firstAgentIndent as agent names
-			if currentIndent == firstAgentIndent && strings.Contains(trimmed, ":") {
-				parts := strings.SplitN(trimmed, ":", 2)
-				key := strings.TrimSpace(parts[0])
-				// Ignore keys with dots (library agents)
-				if strings.Contains(key, ".") {
-					continue
-				}
-				if prevLine, exists := agentNames[key]; exists {
-					errors = append(errors, fmt.Sprintf("Problem: Duplicate agent name '%s' found at line %d (previously defined at line %d). Agent names must be unique.", key, i+1, prevLine))
-				} else {
-					agentNames[key] = i + 1
+		}
+		switch providerType {
+		case "", "openai", "anthropic":
+		case "azure":
+			if baseURL == "" {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: Provider '%s' is an azure provider and needs a base_url.", profile.Line, name))
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: Provider '%s' has unknown type '%s'. Use openai, azure or anthropic.", profile.Line, name, providerType))
+		}
+	}
+	return errors
+}
+
+// checkPrices validates the prices section: per model input and output prices per million tokens.
+func checkPrices(node *yaml.Node) []string {
+	var errors []string
+	if node.Kind != yaml.MappingNode {
+		return append(errors, fmt.Sprintf("Problem: Line %d: The 'prices' section must map model names to input and output prices.", node.Line))
+	}
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		model := node.Content[i].Value
+		price := node.Content[i+1]
+		if price.Kind != yaml.MappingNode {
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: The price of model '%s' must be a mapping with input and output.", price.Line, model))
+			continue
+		}
+		for j := 0; j < len(price.Content)-1; j += 2 {
+			key := price.Content[j].Value
+			val := price.Content[j+1]
+			switch key {
+			case "input", "output":
+				if f, err := strconv.ParseFloat(val.Value, 64); err != nil || f < 0 {
+					errors = append(errors, fmt.Sprintf("Problem: Line %d: The %s price of model '%s' must be a positive number of dollars per million tokens, got '%s'.", val.Line, key, model, val.Value))
 				}
+			default:
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: Unknown price setting '%s' for model '%s'. Use input and output.", price.Content[j].Line, key, model))
 			}
 		}
 	}
 	return errors
 }
 
-// validateAgainstSchema validates the source against the spec_schema.json schema.
-func validateAgainstSchema(source []byte) []string {
+// checkCacheConfig validates the top-level cache section.
+func checkCacheConfig(node *yaml.Node) []string {
 	var errors []string
-	schemaData, err := os.ReadFile("spec_schema.json")
-	if err != nil {
-		errors = append(errors, fmt.Sprintf("Problem: The spec is invalid. : Failed to read spec_schema.json: %v", err))
-		return errors
+	if node.Kind != yaml.MappingNode {
+		return append(errors, fmt.Sprintf("Problem: Line %d: The 'cache' section must be a mapping with a backend and dir.", node.Line))
 	}
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		key := node.Content[i].Value
+		val := node.Content[i+1]
+		switch key {
+		case "backend":
+			if val.Value != agents.CacheBackendMemory && val.Value != agents.CacheBackendDisk {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: Unknown cache backend '%s'. Use memory or disk.", val.Line, val.Value))
+			}
+		case "dir":
+		default:
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: Unknown cache setting '%s'.", node.Content[i].Line, key))
+		}
+	}
+	return errors
+}
+
+// checkRetry validates a provider's retry settings: counts are positive integers
+// and backoffs and cooldowns are durations such as 500ms or 30s.
+func checkRetry(provider string, node *yaml.Node) []string {
+	var errors []string
+	if node.Kind != yaml.MappingNode {
+		return append(errors, fmt.Sprintf("Problem: Line %d: The retry settings of provider '%s' must be a mapping.", node.Line, provider))
+	}
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		key := node.Content[i].Value
+		val := node.Content[i+1]
+		switch key {
+		case "max_attempts", "max_concurrent", "breaker_threshold":
+			if n, err := strconv.Atoi(val.Value); err != nil || n < 0 {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: Provider '%s' retry setting '%s' must be a positive integer, got '%s'.", val.Line, provider, key, val.Value))
+			}
+		case "initial_backoff", "max_backoff", "breaker_cooldown":
+			if d, err := time.ParseDuration(val.Value); err != nil || d < 0 {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: Provider '%s' retry setting '%s' must be a duration like 500ms or 30s, got '%s'.", val.Line, provider, key, val.Value))
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: Provider '%s' has unknown retry setting '%s'.", node.Content[i].Line, provider, key))
+		}
+	}
+	return errors
+}
+
+// promptNearLimit is the share of an agent's token limit its prompt may use before any input is rendered.
+const promptNearLimit = 0.8
+
+// templateActions matches the actions of a template, which are only known when it is rendered.
+var templateActions = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+// checkPromptTokens warns when the static text of a prompt alone nears the agent's max_input_tokens
+// or the context window of its model.
+func checkPromptTokens(agent string, prompt *yaml.Node, model string, maxInputTokens int) string {
+	limit, known := agents.ContextWindow(model)
+	if maxInputTokens > 0 && (!known || maxInputTokens < limit) {
+		limit, known = maxInputTokens, true
+	}
+	if !known {
+		return ""
+	}
+	tokens := agents.CountTokens(model, templateActions.ReplaceAllString(prompt.Value, ""))
+	if float64(tokens) < promptNearLimit*float64(limit) {
+		return ""
+	}
+	return fmt.Sprintf("Reminder: Line %d: Agent '%s' prompt is about %d tokens before any input is rendered, near its limit of %d tokens.", prompt.Line, agent, tokens, limit)
+}
+
+// checkFallback validates an agent's list of fallback models.
+func checkFallback(agent string, node *yaml.Node, providerNames map[string]bool) []string {
+	var errors []string
+	if node.Kind != yaml.SequenceNode {
+		return append(errors, fmt.Sprintf("Problem: Line %d: Agent '%s' fallback must be a list of models, each with a provider or model.", node.Line, agent))
+	}
+	for n, item := range node.Content {
+		owner := fmt.Sprintf("Fallback %d of agent '%s'", n+1, agent)
+		if item.Kind != yaml.MappingNode || len(item.Content) == 0 {
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: %s must be a mapping with a provider or model.", item.Line, owner))
+			continue
+		}
+		for i := 0; i < len(item.Content)-1; i += 2 {
+			key := item.Content[i].Value
+			if !modelParamKeys[key] {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: %s has unknown model setting '%s'.", item.Content[i].Line, owner, key))
+				continue
+			}
+			if problem := checkModelParam(owner, key, item.Content[i+1], providerNames); problem != "" {
+				errors = append(errors, problem)
+			}
+		}
+	}
+	return errors
+}
+
+// checkToolCalls validates the tool call limits of an agent or of the whole spec.
+func checkToolCalls(owner string, node *yaml.Node) []string {
+	var errors []string
+	if node.Kind != yaml.MappingNode {
+		return append(errors, fmt.Sprintf("Problem: Line %d: %s tool_calls must be a mapping with max_depth and max_concurrent.", node.Line, owner))
+	}
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		key := node.Content[i].Value
+		val := node.Content[i+1]
+		switch key {
+		case "max_depth", "max_concurrent":
+			if n, err := strconv.Atoi(val.Value); err != nil || n < 1 {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: %s tool_calls setting '%s' must be a positive integer, got '%s'.", val.Line, owner, key, val.Value))
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: %s has unknown tool_calls setting '%s'. Use max_depth and max_concurrent.", node.Content[i].Line, owner, key))
+		}
+	}
+	return errors
+}
+
+// checkEmbeddings validates the embeddings section.
+func checkEmbeddings(node *yaml.Node, providerNames map[string]bool) []string {
+	var errors []string
+	if node.Kind != yaml.MappingNode {
+		return append(errors, fmt.Sprintf("Problem: Line %d: The 'embeddings' section must be a mapping with provider, model, dimensions and batch_size.", node.Line))
+	}
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		key := node.Content[i].Value
+		val := node.Content[i+1]
+		switch key {
+		case "provider":
+			if !providerNames[val.Value] {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: The embeddings use undefined provider '%s'. Please declare it in the providers section.", val.Line, val.Value))
+			}
+		case "model":
+		case "dimensions", "batch_size":
+			if n, err := strconv.Atoi(val.Value); err != nil || n < 1 {
+				errors = append(errors, fmt.Sprintf("Problem: Line %d: The embeddings setting '%s' must be a positive integer, got '%s'.", val.Line, key, val.Value))
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("Problem: Line %d: Unknown embeddings setting '%s'. Use provider, model, dimensions and batch_size.", node.Content[i].Line, key))
+		}
+	}
+	return errors
+}
+
+func keys(m map[string]bool) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+// specSchema is built into the binary so specs lint the same from any directory.
+//
+//go:embed spec_schema.json
+var specSchema string
+
+// validateAgainstSchema validates the merged spec against the spec_schema.json schema.
+func validateAgainstSchema(spec *yaml.Node) []string {
+	var errors []string
 	compiler := jsonschema.NewCompiler()
-	if err := compiler.AddResource("spec_schema.json", strings.NewReader(string(schemaData))); err != nil {
+	if err := compiler.AddResource("spec_schema.json", strings.NewReader(specSchema)); err != nil {
 		errors = append(errors, fmt.Sprintf("Problem: The spec is invalid. : Failed to load schema resource: %v", err))
 		return errors
 	}
@@ -496,7 +884,7 @@ func validateAgainstSchema(source []byte) []string {
 		return errors
 	}
 	var jsonData map[string]interface{}
-	if err := yaml.Unmarshal(source, &jsonData); err != nil {
+	if err := spec.Decode(&jsonData); err != nil {
 		errors = append(errors, fmt.Sprintf("Problem: The spec is invalid. : Failed to unmarshal YAML: %v", err))
 		return errors
 	}
@@ -418,3 +418,65 @@ agents:
 		t.Error("Expected schema validation error")
 	}
 }
+
+func TestLintSpecFile_ModelParams(t *testing.T) {
+	yaml := `---
+defaults:
+  model: gpt-4o-mini
+  temperature: 0.2
+agents:
+  classify:
+    description: Cheap classifier
+    model: gpt-4o-mini
+    temperature: 0
+    max_tokens: 5
+    seed: 42
+    stop: ["\n"]
+    prompt: |
+      Is this a question? {{ .Input }}
+`
+	result := LintSpecFile([]byte(yaml))
+	t.Logf("### Response: %s", result.Summary)
+	for _, err := range result.Errors {
+		t.Logf("Error: %s", err)
+	}
+	if !result.Valid {
+		t.Errorf("Expected valid spec with model params, got errors: %v", result.Errors)
+	}
+}
+
+func TestLintSpecFile_InvalidModelParams(t *testing.T) {
+	yaml := `---
+defaults:
+  top_p: 1.5
+agents:
+  writer:
+    description: Creative writer
+    temperature: 3
+    max_tokens: 0
+    seed: lucky
+    stop: [a, b, c, d, e]
+    prompt: |
+      Write about {{ .Input }}
+`
+	result := LintSpecFile([]byte(yaml))
+	t.Logf("### Response: %s", result.Summary)
+	for _, err := range result.Errors {
+		t.Logf("Error: %s", err)
+	}
+	if result.Valid {
+		t.Error("Expected invalid spec due to bad model params")
+	}
+	for _, want := range []string{"top_p", "temperature", "max_tokens", "seed", "stop sequences"} {
+		found := false
+		for _, err := range result.Errors {
+			if strings.Contains(err, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected error mentioning %s", want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package agencia
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useMockWithoutAPI answers the registry's AI calls from the mock responses
+// when OPENAI_API_KEY is not configured, so the test runs offline.
+func useMockWithoutAPI(t *testing.T, reg *Registry, responses string) {
+	t.Helper()
+	_ = godotenv.Load()
+	if os.Getenv("OPENAI_API_KEY") != "" {
+		return
+	}
+	mock, err := agents.NewMockProvider([]byte(responses))
+	require.NoError(t, err)
+	reg.UseProvider(mock)
+}
+
+func TestMockProvider_AgentResponses(t *testing.T) {
+	const spec = `
+agents:
+  hello_user:
+    prompt: |
+      Hello {{ .Input }}! How can I help you today?
+  ask_mood:
+    prompt: |
+      How are you feeling today, {{ .Input }}?
+  intro_sequence:
+    template: |
+      {{ .Get "hello_user" }}
+      {{ .Get "ask_mood" }}
+`
+	reg, err := NewRegistry(spec, true)
+	require.NoError(t, err)
+	mock, err := agents.LoadMockProvider("examples/all_mock.yaml")
+	require.NoError(t, err)
+	reg.SetProvider(mock)
+
+	out, _ := reg.Run(context.Background(), "intro_sequence", "World")
+	assert.Equal(t, "Hello World! How can I help you today?\nHow are you feeling today, World?", out)
+}
+
+func TestMockProvider_PatternsAndDefault(t *testing.T) {
+	mock, err := agents.NewMockProvider([]byte(`
+patterns:
+  - match: "(?i)weather"
+    content: "It is sunny in {{ .Input }}."
+default: "I don't know."
+`))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	resp, err := mock.Chat(ctx, &agents.ChatRequest{
+		Agent:    "anyone",
+		Purpose:  agents.PurposePrompt,
+		Input:    "Paris",
+		Messages: []agents.Message{{Role: agents.RoleUser, Content: "What is the Weather?"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "It is sunny in Paris.", resp.Content)
+
+	resp, err = mock.Chat(ctx, &agents.ChatRequest{
+		Agent:    "anyone",
+		Purpose:  agents.PurposePrompt,
+		Messages: []agents.Message{{Role: agents.RoleUser, Content: "Tell me a joke"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "I don't know.", resp.Content)
+}
+
+func TestMockProvider_MissingResponse(t *testing.T) {
+	mock, err := agents.NewMockProvider([]byte(`responses: {}`))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = mock.Chat(ctx, &agents.ChatRequest{Agent: "nobody", Purpose: agents.PurposePrompt})
+	assert.ErrorContains(t, err, `no response for agent "nobody"`)
+
+	resp, err := mock.Chat(ctx, &agents.ChatRequest{Agent: "nobody", Purpose: agents.PurposeInputs})
+	require.NoError(t, err)
+	assert.Equal(t, "{}", resp.Content, "extraction without a scripted reply extracts nothing")
+}
+
+func TestMockProvider_ExtractionAndTools(t *testing.T) {
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "greet",
+		Description: "Generates a greeting message given a person's name",
+		Inputs: map[string]*agents.Argument{
+			"personName": {Description: "The name of the person to greet."},
+		},
+		Template: `Hello, {{ .Input "personName" }}!`,
+	})
+	reg.RegisterAgent(&agents.Agent{
+		Name:      "tryme",
+		Prompt:    "Say hello to {{ .Input }}.",
+		Listeners: []string{"greet"},
+		Facts: map[string]*agents.Fact{
+			"greeted": {Name: "greeted", Type: "string", Description: "Who was greeted"},
+		},
+	})
+	mock, err := agents.NewMockProvider([]byte(`
+responses:
+  tryme:
+    tools:
+      - name: greet
+        arguments: {personName: Alice}
+    content: "Tool said: {{ .ToolResults }}"
+    facts: "greeted: Alice"
+  greet:
+    inputs: "personName: Alice"
+`))
+	require.NoError(t, err)
+	reg.SetProvider(mock)
+	chat := NewChat("tryme")
+
+	res := NewRun(reg, chat).CallAgent(context.Background(), "tryme", "Alice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Tool said: Hello, Alice!", res.Output)
+	assert.Equal(t, "Alice", chat.Facts["greeted"])
+	assert.Equal(t, "Alice", chat.Facts["tryme.greeted"])
+}
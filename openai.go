@@ -3,13 +3,15 @@ package agencia
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/robbyriverside/agencia/agents"
 	"github.com/robbyriverside/agencia/utils"
-	"github.com/sashabaranov/go-openai"
 	"gopkg.in/yaml.v3"
 )
 
@@ -31,7 +33,11 @@ func NewTemplateContext(ctx context.Context, agent *agents.Agent, input string,
 	}
 }
 
+// Fact returns the value of a fact, looking first at the local facts of the run or job.
 func (t *TemplateContext) Fact(name string, optionalInput ...any) any {
+	if v, ok := t.Run.LocalFacts[name]; ok {
+		return v
+	}
 	var result any
 	if len(optionalInput) > 0 {
 		result = optionalInput[0]
@@ -68,7 +74,7 @@ func (t *TemplateContext) Inputs(optionalInput ...string) string {
 	} else {
 		name := optionalInput[0]
 		var err error
-		agent, err = t.Run.Registry.LookupAgent(name)
+		agent, _, err = t.Run.Registry.lookupAlias(t.Run.resolve(name))
 		if err != nil {
 			return fmt.Sprintf("Error: Agent %q not found", name)
 		}
@@ -103,6 +109,91 @@ func (t *TemplateContext) Input(optionalInput ...string) any {
 	}
 }
 
+// Attachments lists the images and files attached to the run's input, without their data.
+func (t *TemplateContext) Attachments() []agents.Attachment {
+	refs := make([]agents.Attachment, 0, len(t.Run.Attachments))
+	for _, a := range t.Run.Attachments {
+		refs = append(refs, a.Reference())
+	}
+	return refs
+}
+
+// Agreement returns the share of samples that agreed with the answer of the named agent,
+// the last time this agent called it.  It is 1 for agents that do not sample.
+func (t *TemplateContext) Agreement(name string) float64 {
+	if t.Run.Card == nil {
+		return 0
+	}
+	cards := t.Run.Card.BranchCards
+	for i := len(cards) - 1; i >= 0; i-- {
+		if card := cards[i]; card.AgentName == name && card.Sample == 0 {
+			if card.samples() == 0 {
+				return 1
+			}
+			return card.Agreement
+		}
+	}
+	return 0
+}
+
+// JobID returns the ID of the job the agent is starting or running in; empty outside a job.
+func (t *TemplateContext) JobID() string {
+	return t.Run.JobID
+}
+
+// Jobs lists every job with its progress, one per line.
+func (t *TemplateContext) Jobs() string {
+	return t.Run.Registry.JobRunner().Describe()
+}
+
+// JobStatus describes the progress of a job, found by its ID or by the name of its agent.
+func (t *TemplateContext) JobStatus(ref string) string {
+	job, err := t.Run.Registry.JobRunner().Find(ref)
+	if err != nil {
+		return fmt.Sprintf("[error finding job %s: %v]", ref, err)
+	}
+	return job.String()
+}
+
+// CancelJob stops a job, found by its ID or by the name of its agent.
+func (t *TemplateContext) CancelJob(ref string) string {
+	return t.controlJob(ref, "canceling", (*Job).Cancel, JobCanceled)
+}
+
+// PauseJob holds a job before its next step.
+func (t *TemplateContext) PauseJob(ref string) string {
+	return t.controlJob(ref, "pausing", (*Job).Pause, JobPaused)
+}
+
+// ResumeJob continues a paused job.
+func (t *TemplateContext) ResumeJob(ref string) string {
+	return t.controlJob(ref, "resuming", (*Job).Resume, JobRunning)
+}
+
+func (t *TemplateContext) controlJob(ref, doing string, action func(*Job) error, state string) string {
+	msg, err := t.Run.Registry.JobRunner().control(ref, action, state)
+	if err != nil {
+		return fmt.Sprintf("[error %s job %s: %v]", doing, ref, err)
+	}
+	return msg
+}
+
+// Role returns the agent's rendered role.
+// With a name it renders that role from the roles section for this agent.
+func (t *TemplateContext) Role(optionalName ...string) string {
+	if len(optionalName) == 0 {
+		if t.Run.Card == nil {
+			return ""
+		}
+		return t.Run.Card.Role
+	}
+	role, err := t.Run.renderRole(t, optionalName[0])
+	if err != nil {
+		return fmt.Sprintf("[error rendering role %s: %v]", optionalName[0], err)
+	}
+	return role
+}
+
 func (t *TemplateContext) Start(name string) string {
 	if t.Run.Chat.IsValidStartAgent(name) {
 		t.Run.Chat.SetStartAgent(name)
@@ -111,154 +202,391 @@ func (t *TemplateContext) Start(name string) string {
 	return fmt.Sprintf("Invalid Starting Agent: %s", name)
 }
 
+// CallAI sends the prompt to the registry's provider.
+// The agent's listeners are offered as tools and any tool calls are handled before returning.
 func (r *RunContext) CallAI(ctx context.Context, agent *agents.Agent, prompt string) (string, error) {
-	return r.CallOpenAI(ctx, agent, prompt)
+	return r.callAI(ctx, agent, agents.PurposePrompt, prompt)
 }
 
-func (r *RunContext) CallOpenAI(ctx context.Context, agent *agents.Agent, prompt string) (string, error) {
-	client, err := agents.GetOpenAIClient()
+// callAI sends the prompt for the given purpose.
+// Only the prompt itself offers listeners as tools; extraction calls never do.
+func (r *RunContext) callAI(ctx context.Context, agent *agents.Agent, purpose, prompt string) (string, error) {
+	tools := []agents.Tool{}
+	if purpose == agents.PurposePrompt {
+		var err error
+		tools, err = r.listenerTools(agent)
+		if err != nil {
+			return "", err
+		}
+	}
+	if purpose == agents.PurposePrompt && agent.History != nil && r.Card != nil {
+		history, trimmed := r.Chat.History(agent.History, r.settings().modelParams(agent).Model)
+		r.Card.History = history
+		if trimmed > 0 {
+			r.Logf("[HISTORY] %d earlier turns trimmed for %s", trimmed, agent.Name)
+		}
+	}
+	if purpose == agents.PurposePrompt {
+		var err error
+		prompt, err = r.fitPrompt(ctx, agent, prompt)
+		if err != nil {
+			return "", err
+		}
+	}
+	messages := []agents.Message{
+		{Role: agents.RoleUser, Content: prompt},
+	}
+	if purpose == agents.PurposePrompt {
+		messages[0].Attachments = r.promptAttachments(agent)
+	}
+	resp, err := r.chat(ctx, agent, r.newChatRequest(agent, purpose, messages, tools))
 	if err != nil {
 		return "", err
 	}
-	tools := []openai.Tool{}
+	if len(resp.ToolCalls) > 0 {
+		return r.handleToolCalls(ctx, agent, messages, tools, resp)
+	}
+	return strings.TrimSpace(resp.Content), nil
+}
+
+// promptAttachments returns the run's attachments when they go with the agent's prompt.
+// Images are left out for models that cannot see them.
+func (r *RunContext) promptAttachments(agent *agents.Agent) []agents.Attachment {
+	if r.Card == nil || !r.Card.attach {
+		return nil
+	}
+	model := r.settings().modelParams(agent).Model
+	if model == "" {
+		model = agents.DefaultModel
+	}
+	if agents.SupportsVision(model) {
+		return r.Attachments
+	}
+	attachments := []agents.Attachment{}
+	for _, a := range r.Attachments {
+		if a.IsImage() {
+			r.Logf("[ATTACH] %s cannot see images; %s not sent", model, a)
+			continue
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments
+}
+
+// newChatRequest builds a request for the agent.
+// The prompt and its tool continuations start with the agent's role as the system message,
+// followed by the chat history on the agent's card.
+func (r *RunContext) newChatRequest(agent *agents.Agent, purpose string, messages []agents.Message, tools []agents.Tool) *agents.ChatRequest {
+	if (purpose == agents.PurposePrompt || purpose == agents.PurposeTools) && r.Card != nil {
+		messages = append(slices.Clone(r.Card.History), messages...)
+		if r.Card.Role != "" {
+			messages = append([]agents.Message{{Role: agents.RoleSystem, Content: r.Card.Role}}, messages...)
+		}
+	}
+	req := &agents.ChatRequest{
+		Messages: messages,
+		Tools:    tools,
+		Agent:    agent.Name,
+		Purpose:  purpose,
+	}
+	r.settings().modelParams(agent).Apply(req)
+	if r.Card != nil {
+		req.Input = r.Card.Input
+	}
+	return req
+}
+
+// chat sends the agent's request to the provider it names.
+// The prompt and tool continuations of a streaming agent are streamed to r.Stream.
+// Requests made for an agent with a cache policy are answered from the registry's cache when they can be.
+func (r *RunContext) chat(ctx context.Context, agent *agents.Agent, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	policy := agent.Cache
+	if policy == nil {
+		resp, err := r.send(ctx, agent, req)
+		if err == nil {
+			r.addUsage(req, resp)
+		}
+		return resp, err
+	}
+	cache := r.Registry.ResponseCache()
+	key := agents.CacheKey(req)
+	if resp, ok := cache.Get(key); ok {
+		if r.Card != nil {
+			r.Card.Cached = true
+		}
+		r.Logf("[CACHE] %s response for %s from cache", req.Purpose, req.Agent)
+		if r.isStreaming(req) && resp.Content != "" {
+			r.Stream(resp.Content)
+		}
+		return resp, nil
+	}
+	resp, err := r.send(ctx, agent, req)
+	if err != nil {
+		return nil, err
+	}
+	r.addUsage(req, resp)
+	if err := cache.Set(key, resp, policy.TTL); err != nil {
+		r.Errorf("cannot cache response: %v", err)
+	}
+	return resp, nil
+}
+
+// addUsage counts the call's tokens and cost on the card and the chat.
+// Cached responses cost nothing and are not counted.
+func (r *RunContext) addUsage(req *agents.ChatRequest, resp *agents.ChatResponse) {
+	usage := resp.Usage
+	usage.Calls = 1
+	model := resp.Model
+	if model == "" {
+		model = req.Model
+	}
+	if price, ok := agents.PriceFor(r.Registry.Prices, model); ok {
+		usage.Cost = price.Cost(usage)
+	}
+	if r.Card != nil {
+		r.Card.Usage = r.Card.Usage.Add(usage)
+	}
+	if r.Chat != nil {
+		r.Chat.addUsage(usage)
+	}
+}
+
+// send sends the request to its model, then to each of the agent's fallback models in turn
+// while the answer is an error, a timeout, a content filter block or empty.
+// The model that answered is recorded on the card.
+func (r *RunContext) send(ctx context.Context, agent *agents.Agent, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	chain := []*agents.ChatRequest{req}
+	for _, params := range r.settings().fallbackParams(agent) {
+		fallback := *req
+		params.Apply(&fallback)
+		chain = append(chain, &fallback)
+	}
+	timeout := agent.Timeout
+	for i, step := range chain {
+		resp, err := r.sendTo(ctx, step, timeout)
+		reason := agents.FallbackReason(resp, err)
+		if reason == nil || i == len(chain)-1 || ctx.Err() != nil {
+			if err == nil {
+				resp = r.recordModel(step, resp, i > 0)
+			}
+			return resp, err
+		}
+		next := chain[i+1]
+		r.Logf("[AI] %s falling back from %s to %s: %v", req.Purpose, modelName(step), modelName(next), reason)
+	}
+	return nil, agents.ErrNoChoices // not reached; the chain always has the request
+}
+
+// sendTo sends the request to the provider it names, giving up after the timeout when there is one.
+func (r *RunContext) sendTo(ctx context.Context, req *agents.ChatRequest, timeout time.Duration) (*agents.ChatResponse, error) {
+	provider, err := r.Registry.ProviderFor(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx = agents.WithAttemptLog(ctx, func(a agents.Attempt) {
+		r.Logf("[AI] %s %s", req.Purpose, a)
+	})
+	if r.isStreaming(req) {
+		return agents.ChatStream(ctx, provider, req, r.Stream)
+	}
+	return provider.Chat(ctx, req)
+}
+
+// recordModel notes the model that answered on the card.
+// A fallback's response names its model, so its usage is priced as that model.
+func (r *RunContext) recordModel(req *agents.ChatRequest, resp *agents.ChatResponse, fallback bool) *agents.ChatResponse {
+	if resp.Model == "" && fallback {
+		named := *resp
+		named.Model = req.Model
+		resp = &named
+	}
+	if r.Card != nil {
+		r.Card.Model = resp.Model
+		if r.Card.Model == "" {
+			r.Card.Model = req.Model
+		}
+		if fallback {
+			r.Card.Fallbacks++
+		}
+	}
+	return resp
+}
+
+// modelName names the request's model and the provider it is sent to.
+func modelName(req *agents.ChatRequest) string {
+	if req.Provider == "" {
+		return req.Model
+	}
+	return req.Provider + "/" + req.Model
+}
+
+func (r *RunContext) isStreaming(req *agents.ChatRequest) bool {
+	if r.Stream == nil || r.Card == nil || !r.Card.streaming {
+		return false
+	}
+	return req.Purpose == agents.PurposePrompt || req.Purpose == agents.PurposeTools
+}
+
+// listenerTools describes the agent's listeners as tools for the provider.
+func (r *RunContext) listenerTools(agent *agents.Agent) ([]agents.Tool, error) {
+	tools := []agents.Tool{}
 	badListeners := []string{}
 	for _, listenerName := range agent.Listeners {
-		listenerAgent, err := r.Registry.LookupAgent(listenerName)
+		listenerAgent, _, err := r.Registry.lookupAlias(r.resolve(listenerName))
 		if err != nil {
-			return "", fmt.Errorf("error looking up listener agent %s: %w", listenerName, err)
+			return nil, fmt.Errorf("error looking up listener agent %s: %w", listenerName, err)
 		}
 		if listenerAgent.Description == "" || len(listenerAgent.Inputs) == 0 {
 			badListeners = append(badListeners, listenerName)
 			continue
 		}
-		paramSchema := buildToolParameters(listenerAgent)
-		tools = append(tools, openai.Tool{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        listenerName,
-				Description: listenerAgent.Description,
-				Parameters:  paramSchema,
-			},
+		for inputName, arg := range listenerAgent.Inputs {
+			if arg.Type != "" && !toolParameterTypes[arg.Type] {
+				return nil, fmt.Errorf("invalid input type %q for %s in listener %s", arg.Type, inputName, listenerName)
+			}
+		}
+		tools = append(tools, agents.Tool{
+			Name:        toolName(listenerName),
+			Description: listenerAgent.Description,
+			Parameters:  buildToolParameters(listenerAgent),
 		})
 	}
 
 	if len(badListeners) > 0 {
-		return "", fmt.Errorf("invalid listeners detected (missing description or input prompt): %s", strings.Join(badListeners, ", "))
+		return nil, fmt.Errorf("invalid listeners detected (missing description or input prompt): %s", strings.Join(badListeners, ", "))
 	}
+	return tools, nil
+}
 
-	req := openai.ChatCompletionRequest{
-		Model:       openai.GPT4o,
-		Temperature: 0.2,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: prompt},
-		},
-		Tools:      tools,
-		ToolChoice: nil,
-	}
-	resp, err := client.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("OpenAI API error: %w", err)
-	}
+// handleToolCalls runs the tool calls the model asked for and sends it their results,
+// round after round, until it answers without calling tools.
+// Every round is kept in the messages, so the model sees all earlier tool results.
+func (r *RunContext) handleToolCalls(ctx context.Context, agent *agents.Agent, messages []agents.Message, tools []agents.Tool, resp *agents.ChatResponse) (string, error) {
+	config := r.settings().toolCallConfig(agent)
+	trace := []string{}
+	for depth := 1; len(resp.ToolCalls) > 0; depth++ {
+		if depth > config.MaxDepth {
+			return "", fmt.Errorf(
+				"too many recursive tool call levels (depth=%d); possible infinite loop.\nTrace:\n%s",
+				depth,
+				strings.Join(trace, "\n"),
+			)
+		}
+		for _, toolCall := range resp.ToolCalls {
+			trace = append(trace, fmt.Sprintf("Depth %d: called tool %s with args %s", depth, toolCall.Name, toolCall.Arguments))
+		}
+		functionResults, err := r.runToolCalls(ctx, agent, resp.ToolCalls, config.MaxConcurrent)
+		if err != nil {
+			return "", err
+		}
+		messages = append(messages, agents.Message{Role: agents.RoleAssistant, Content: resp.Content, ToolCalls: resp.ToolCalls})
+		messages = append(messages, functionResults...)
 
-	if len(resp.Choices) > 0 && len(resp.Choices[0].Message.ToolCalls) > 0 {
-		return r.handleToolCalls(ctx, prompt, tools, resp.Choices[0].Message.ToolCalls, 1, []string{})
+		resp, err = r.chat(ctx, agent, r.newChatRequest(agent, agents.PurposeTools, messages, tools))
+		if err != nil {
+			return "", fmt.Errorf("AI error on continuation: %w", err)
+		}
 	}
-
-	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+	return strings.TrimSpace(resp.Content), nil
 }
 
-func (r *RunContext) handleToolCalls(ctx context.Context, prompt string, tools []openai.Tool, initialToolCalls []openai.ToolCall, depth int, trace []string) (string, error) {
-	if depth > 5 {
-		return "", fmt.Errorf(
-			"too many recursive tool call levels (depth=%d); possible infinite loop.\nTrace:\n%s",
-			depth,
-			strings.Join(trace, "\n"),
-		)
+// runToolCalls calls the agent's listener of each tool call on its own branch card and returns the tool results in order.
+// Up to limit calls run at once, each on a fork of the run.
+func (r *RunContext) runToolCalls(ctx context.Context, agent *agents.Agent, toolCalls []agents.ToolCall, limit int) ([]agents.Message, error) {
+	if r.Depth >= maxCallDepth {
+		return nil, fmt.Errorf("recursive agent calls exceeded %d", maxCallDepth)
 	}
-
-	messages := []openai.ChatCompletionMessage{
-		{Role: openai.ChatMessageRoleUser, Content: prompt},
-		{Role: openai.ChatMessageRoleAssistant, ToolCalls: initialToolCalls},
+	names := make([]string, len(toolCalls))
+	cards := make([]*TraceCard, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		names[i] = listenerOfTool(agent, toolCall.Name)
+		cards[i] = r.NewTraceCard(names[i], toolCall.Arguments)
+		if r.Card != nil {
+			r.Card.BranchCards = append(r.Card.BranchCards, cards[i])
+		}
+	}
+	forks := make([]*RunContext, len(toolCalls))
+	results := make([]AgentResult, len(toolCalls))
+	slots := make(chan struct{}, max(limit, 1))
+	var wg sync.WaitGroup
+	for i, toolCall := range toolCalls {
+		forks[i] = r.fork()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = forks[i].callAgent(ctx, cards[i], names[i], toolCall.Arguments)
+		}()
 	}
+	wg.Wait()
 
-	functionResults := []openai.ChatCompletionMessage{}
-	for _, toolCall := range initialToolCalls {
-		if toolCall.Type == "function" {
-			agentName := toolCall.Function.Name
-			args := toolCall.Function.Arguments
-			traceEntry := fmt.Sprintf("Depth %d: called tool %s with args %s", depth, agentName, args)
-			trace = append(trace, traceEntry)
-			res := r.CallAgent(ctx, agentName, args)
-			if res.Error != nil {
-				return "", fmt.Errorf("error handling tool callback for %s: %w", agentName, res.Error)
+	functionResults := []agents.Message{}
+	for i, toolCall := range toolCalls {
+		maps.Copy(r.LocalFacts, forks[i].LocalFacts)
+		res := results[i]
+		if res.Error != nil {
+			return nil, fmt.Errorf("error handling tool callback for %s: %w", names[i], res.Error)
+		}
+		outputContent := res.Output
+		if strings.Contains(outputContent, "{{") && strings.Contains(outputContent, "}}") {
+			tmpl, err := utils.TemplateParse(names[i], outputContent)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing template output from agent %s: %w", names[i], err)
 			}
-			if strings.Contains(res.Output, "{{") && strings.Contains(res.Output, "}}") {
-				tmpl, err := utils.TemplateParse(agentName, res.Output)
-				if err != nil {
-					return "", fmt.Errorf("error parsing template output from agent %s: %w", agentName, err)
-				}
-				var buf bytes.Buffer
-				err = tmpl.Execute(&buf, &TemplateContext{
-					UserInput: args,
-					Run:       r,
-					ctx:       ctx,
-				})
-				if err != nil {
-					return "", fmt.Errorf("error executing template output from agent %s: %w", agentName, err)
-				}
-				functionResults = append(functionResults, openai.ChatCompletionMessage{
-					Role:       openai.ChatMessageRoleTool,
-					ToolCallID: toolCall.ID,
-					Content:    buf.String(),
-				})
-			} else {
-				outputContent := res.Output
-				if outputContent == "" {
-					outputContent = " " // must be a non-nil string to satisfy OpenAI API
-				}
-				functionResults = append(functionResults, openai.ChatCompletionMessage{
-					Role:       openai.ChatMessageRoleTool,
-					ToolCallID: toolCall.ID,
-					Content:    outputContent,
-				})
+			var buf bytes.Buffer
+			err = tmpl.Execute(&buf, &TemplateContext{
+				UserInput: toolCall.Arguments,
+				Run:       r,
+				ctx:       ctx,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error executing template output from agent %s: %w", names[i], err)
 			}
+			outputContent = buf.String()
+		}
+		if outputContent == "" {
+			outputContent = " " // must be a non-nil string to satisfy OpenAI API
 		}
+		functionResults = append(functionResults, agents.Message{
+			Role:       agents.RoleTool,
+			ToolCallID: toolCall.ID,
+			Content:    outputContent,
+		})
 	}
-	messages = append(messages, functionResults...)
+	return functionResults, nil
+}
 
-	contReq := openai.ChatCompletionRequest{
-		Model:       openai.GPT4o,
-		Temperature: 0.2,
-		Messages:    messages,
-		Tools:       tools,
-	}
+// toolParameterTypes are the JSON schema types allowed for listener inputs.
+var toolParameterTypes = map[string]bool{
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"array":   true,
+	"object":  true,
+}
 
-	client, err := agents.GetOpenAIClient()
-	if err != nil {
-		return "", err
-	}
+// toolName is the name a listener is offered to the model by.  Providers only accept letters,
+// digits, underscores and dashes, so the dot of a library agent such as jobs.status becomes jobs__status.
+func toolName(listener string) string {
+	return strings.ReplaceAll(listener, ".", "__")
+}
 
-	contResp, err := client.CreateChatCompletion(ctx, contReq)
-	if err != nil {
-		return "", fmt.Errorf("OpenAI API error on continuation: %w", err)
-	}
-
-	if len(contResp.Choices) > 0 {
-		choice := contResp.Choices[0]
-		if len(choice.Message.ToolCalls) > 0 {
-			if depth+1 > 5 {
-				return "", fmt.Errorf(
-					"too many recursive tool call levels (depth=%d); possible infinite loop.\nTrace:\n%s",
-					depth+1,
-					strings.Join(trace, "\n"),
-				)
-			}
-			// Recursive: new tool calls need handling
-			return r.handleToolCalls(ctx, prompt, tools, choice.Message.ToolCalls, depth+1, trace)
+// listenerOfTool returns the agent's listener that a tool call names.
+// A name that is none of them is returned as it is, and fails as an unknown agent.
+func listenerOfTool(agent *agents.Agent, tool string) string {
+	for _, listener := range agent.Listeners {
+		if toolName(listener) == tool {
+			return listener
 		}
-		return strings.TrimSpace(choice.Message.Content), nil
 	}
-	return "", errors.New("no choices returned from continuation OpenAI call")
+	return tool
 }
 
 func buildToolParameters(agent *agents.Agent) map[string]interface{} {
@@ -268,7 +596,8 @@ func buildToolParameters(agent *agents.Agent) map[string]interface{} {
 		"required":   []string{},
 	}
 
-	for fieldName, arg := range agent.Inputs {
+	for _, fieldName := range slices.Sorted(maps.Keys(agent.Inputs)) {
+		arg := agent.Inputs[fieldName]
 		properties := paramSchema["properties"].(map[string]interface{})
 		argType := arg.Type
 		if argType == "" {
@@ -3,26 +3,17 @@ package agencia
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
 	"testing"
 
-	"github.com/joho/godotenv"
 	"github.com/robbyriverside/agencia/agents"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestCallOpenAI_FunctionCalling(t *testing.T) {
-	// Try to load .env file first
-	_ = godotenv.Load()
-
-	// Check the API key
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_FunctionCalling(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	reg.RegisterAgent(&agents.Agent{
 		Name:        "greet",
@@ -49,15 +40,10 @@ func TestCallOpenAI_FunctionCalling(t *testing.T) {
 	assert.Contains(t, output, "Hello, Alice", "should generate greeting via function call")
 }
 
-func TestCallOpenAI_ToolNotFound(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_ToolNotFound(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Register only the tryme agent but no greet agent
 	reg.RegisterAgent(&agents.Agent{
@@ -75,15 +61,10 @@ func TestCallOpenAI_ToolNotFound(t *testing.T) {
 	assert.Empty(t, output, "output should be empty on tool not found")
 }
 
-func TestCallOpenAI_MultipleToolCalls(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_MultipleToolCalls(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Register two agents
 	reg.RegisterAgent(&agents.Agent{
@@ -119,15 +100,10 @@ func TestCallOpenAI_MultipleToolCalls(t *testing.T) {
 	assert.Contains(t, res.Output, "Goodbye, Alice", "should generate farewell via function call")
 }
 
-func TestCallOpenAI_EmptyToolOutput(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_EmptyToolOutput(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Register a valid function agent that returns empty output
 	reg.RegisterAgent(&agents.Agent{
@@ -156,13 +132,7 @@ func TestCallOpenAI_EmptyToolOutput(t *testing.T) {
 	// Optionally check if output is still acceptable (blank or partial)
 }
 
-func TestCallOpenAI_RecursiveToolCalling(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_RecursiveToolCalling(t *testing.T) {
 	prompt := fmt.Sprintf(`
       1. Call the tool **echo1** with the argument:
          %sjson
@@ -186,6 +156,7 @@ func TestCallOpenAI_RecursiveToolCalling(t *testing.T) {
 
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Define two agents that keep triggering each other
 	reg.RegisterAgent(&agents.Agent{
@@ -221,15 +192,10 @@ func TestCallOpenAI_RecursiveToolCalling(t *testing.T) {
 	t.Logf("Output: %s", res.Output)
 }
 
-func TestCallOpenAI_InvalidToolSchema(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_InvalidToolSchema(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Register an agent with invalid InputPrompt schema
 	reg.RegisterAgent(&agents.Agent{
@@ -257,15 +223,10 @@ func TestCallOpenAI_InvalidToolSchema(t *testing.T) {
 	assert.True(t, strings.Contains(strings.ToLower(res.Error.Error()), "invalid"), "error should mention invalid schema or tool setup")
 }
 
-func TestCallOpenAI_ContinuationMissingTool(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_ContinuationMissingTool(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Register only a tryme agent but no actual listener agents
 	reg.RegisterAgent(&agents.Agent{
@@ -281,15 +242,10 @@ func TestCallOpenAI_ContinuationMissingTool(t *testing.T) {
 	assert.Contains(t, res.Error.Error(), "could not find", "error should mention missing agent or tool")
 }
 
-func TestCallOpenAI_AgentTemplateFails(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_AgentTemplateFails(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Register an agent with a broken template
 	reg.RegisterAgent(&agents.Agent{
@@ -312,15 +268,10 @@ func TestCallOpenAI_AgentTemplateFails(t *testing.T) {
 	assert.Contains(t, res.Error.Error(), "template", "error should mention template execution")
 }
 
-func TestCallOpenAI_MultipleBadListeners(t *testing.T) {
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
-
+func TestPlumbing_MultipleBadListeners(t *testing.T) {
 	reg := &Registry{}
 	ctx := context.Background()
+	useCassette(t, reg)
 
 	// Bad listener 1 (missing Description)
 	reg.RegisterAgent(&agents.Agent{
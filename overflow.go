@@ -0,0 +1,83 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/robbyriverside/agencia/agents"
+)
+
+// fitPrompt cuts the prompt down to the agent's max_input_tokens with its overflow strategy.
+// The role and the chat history on the card count towards the limit; drop_history trims the history.
+// The prompt sent is recorded on the card.
+func (r *RunContext) fitPrompt(ctx context.Context, agent *agents.Agent, prompt string) (string, error) {
+	limit := agent.MaxInputTokens
+	if limit <= 0 || r.Card == nil {
+		return prompt, nil
+	}
+	tokens := func(prompt string) int {
+		req := r.newChatRequest(agent, agents.PurposePrompt, []agents.Message{{Role: agents.RoleUser, Content: prompt}}, nil)
+		return agents.CountMessageTokens(req.Model, req.Messages)
+	}
+	count := tokens(prompt)
+	if count <= limit {
+		return prompt, nil
+	}
+	policy := agent.Overflow
+	if policy == nil {
+		policy = &agents.OverflowPolicy{Strategy: agents.OverflowTruncateTail}
+	}
+	r.Logf("[TOKENS] prompt of %s is %d tokens, over its limit of %d: %s", agent.Name, count, limit, policy)
+
+	switch policy.Strategy {
+	case agents.OverflowDropHistory:
+		dropped := 0
+		for len(r.Card.History) > 0 && tokens(prompt) > limit {
+			r.Card.History = r.Card.History[2:] // a user and assistant message per turn
+			dropped++
+		}
+		if dropped > 0 {
+			r.Logf("[TOKENS] %d earlier turns dropped for %s", dropped, agent.Name)
+		}
+	case agents.OverflowSummarize:
+		res := r.CallAgent(ctx, policy.Agent, prompt)
+		if res.Error != nil {
+			return "", fmt.Errorf("cannot summarize the prompt of %s with %s: %w", agent.Name, policy.Agent, res.Error)
+		}
+		prompt = res.Output
+	}
+	if tokens(prompt) > limit {
+		fitted, ok := truncate(prompt, policy.Strategy == agents.OverflowTruncateHead, func(s string) bool { return tokens(s) <= limit })
+		if !ok {
+			return "", fmt.Errorf("agent %s cannot fit its prompt into max_input_tokens %d: its role and history alone are over the limit", agent.Name, limit)
+		}
+		prompt = fitted
+	}
+	r.Card.Prompt = prompt
+	return prompt, nil
+}
+
+// truncate keeps the longest end of the text that fits; the head is cut when cutHead is set, otherwise the tail.
+// It reports false when not even an empty text fits.
+func truncate(text string, cutHead bool, fits func(string) bool) (string, bool) {
+	runes := []rune(text)
+	keep := func(n int) string {
+		if cutHead {
+			return string(runes[len(runes)-n:])
+		}
+		return string(runes[:n])
+	}
+	if !fits("") {
+		return "", false
+	}
+	low, high := 0, len(runes) // keep(low) fits; keep(high+1) does not
+	for low < high {
+		mid := (low + high + 1) / 2
+		if fits(keep(mid)) {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return keep(low), true
+}
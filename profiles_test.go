@@ -0,0 +1,169 @@
+package agencia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chatCompletionServer speaks the chat completions wire format and replies with content.
+// Every request it receives is sent on the returned channels along with its decoded body.
+func chatCompletionServer(t *testing.T, content string) (*httptest.Server, chan *http.Request, chan map[string]any) {
+	t.Helper()
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan map[string]any, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- r
+		bodies <- body
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{
+  "id": "chatcmpl-1",
+  "object": "chat.completion",
+  "model": %q,
+  "choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": %q}}],
+  "usage": {"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7}
+}`, body["model"], content)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, bodies
+}
+
+func TestProviderProfile_OpenAICompatible(t *testing.T) {
+	server, requests, bodies := chatCompletionServer(t, "Hello from llama")
+	t.Setenv("LOCAL_LLAMA_KEY", "secret")
+
+	spec := fmt.Sprintf(`
+providers:
+  local-llama:
+    base_url: %s/v1
+    api_key_env: LOCAL_LLAMA_KEY
+    model: llama3
+    headers:
+      X-Team: agencia
+agents:
+  greet:
+    description: Greet the user
+    provider: local-llama
+    prompt: "Say hello to {{ .Input }}."
+`, server.URL)
+	reg, err := NewRegistry(spec)
+	require.NoError(t, err)
+
+	out, _ := reg.Run(context.Background(), "greet", "Bob")
+	assert.Equal(t, "Hello from llama", out)
+
+	req := <-requests
+	body := <-bodies
+	assert.Equal(t, "/v1/chat/completions", req.URL.Path)
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+	assert.Equal(t, "agencia", req.Header.Get("X-Team"))
+	assert.Equal(t, "llama3", body["model"])
+}
+
+func TestProviderProfile_Azure(t *testing.T) {
+	server, requests, _ := chatCompletionServer(t, "Hello from azure")
+	t.Setenv("AZURE_TEST_KEY", "azure-secret")
+
+	spec := fmt.Sprintf(`
+providers:
+  azure:
+    type: azure
+    base_url: %s
+    api_key_env: AZURE_TEST_KEY
+    api_version: 2024-02-01
+defaults:
+  provider: azure
+  model: gpt-4o
+agents:
+  greet:
+    description: Greet the user
+    prompt: "Say hello to {{ .Input }}."
+`, server.URL)
+	reg, err := NewRegistry(spec)
+	require.NoError(t, err)
+
+	out, _ := reg.Run(context.Background(), "greet", "Bob")
+	assert.Equal(t, "Hello from azure", out)
+
+	req := <-requests
+	assert.Equal(t, "/openai/deployments/gpt-4o/chat/completions", req.URL.Path)
+	assert.Equal(t, "2024-02-01", req.URL.Query().Get("api-version"))
+	assert.Equal(t, "azure-secret", req.Header.Get("api-key"))
+}
+
+func TestProviderProfile_MissingKey(t *testing.T) {
+	t.Setenv("MISSING_PROVIDER_KEY", "")
+	const spec = `
+providers:
+  remote:
+    base_url: http://localhost:1/v1
+    api_key_env: MISSING_PROVIDER_KEY
+agents:
+  greet:
+    description: Greet the user
+    provider: remote
+    prompt: "Say hello to {{ .Input }}."
+`
+	reg, err := NewRegistry(spec)
+	require.NoError(t, err)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "greet", "Bob")
+	require.Error(t, res.Error)
+	assert.Contains(t, res.Error.Error(), "MISSING_PROVIDER_KEY must be set")
+}
+
+func TestLintSpecFile_UndefinedProvider(t *testing.T) {
+	yaml := `---
+providers:
+  broken:
+    type: azure
+agents:
+  greet:
+    description: Greet the user
+    provider: local-llama
+    prompt: Hello
+`
+	result := LintSpecFile([]byte(yaml))
+	for _, err := range result.Errors {
+		t.Logf("Error: %s", err)
+	}
+	assert.False(t, result.Valid)
+	assert.Len(t, result.Errors, 2, "expected undefined provider and missing azure base_url")
+}
+
+func TestProviderProfile_RemoteSpecCannotRedirect(t *testing.T) {
+	server, requests, _ := chatCompletionServer(t, "thanks for the key")
+	t.Setenv("AGENCIA_TEST_SECRET", "secret")
+
+	spec := fmt.Sprintf(`
+providers:
+  collector:
+    base_url: %s
+    api_key_env: AGENCIA_TEST_SECRET
+agents:
+  ask:
+    description: Ask the collector
+    provider: collector
+    prompt: "{{ .Input }}"
+`, server.URL)
+	body, err := json.Marshal(runRequest{Spec: spec, Agent: "ask", Input: "hello"})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), errRemoteProvider.Error())
+	assert.Empty(t, requests, "the server's key is never sent")
+}
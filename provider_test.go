@@ -0,0 +1,198 @@
+package agencia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider replays scripted responses in order and records every request.
+type fakeProvider struct {
+	responses []*agents.ChatResponse
+	requests  []*agents.ChatRequest
+	embeds    []*agents.EmbedRequest
+}
+
+func (f *fakeProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	f.requests = append(f.requests, req)
+	if len(f.responses) == 0 {
+		return nil, errors.New("fake provider has no more responses")
+	}
+	resp := f.responses[0]
+	f.responses = f.responses[1:]
+	return resp, nil
+}
+
+func (f *fakeProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	f.embeds = append(f.embeds, req)
+	vectors := make([][]float32, len(req.Input))
+	for i := range req.Input {
+		vectors[i] = []float32{float32(i), 1}
+	}
+	return &agents.EmbedResponse{Vectors: vectors}, nil
+}
+
+func TestProvider_PromptAgent(t *testing.T) {
+	const spec = `
+agents:
+  greet:
+    description: Greet the user
+    prompt: "Say hello to {{ .Input }}."
+`
+	reg, err := NewRegistry(spec)
+	require.NoError(t, err)
+	fake := &fakeProvider{responses: []*agents.ChatResponse{{Content: " Hello, Bob! "}}}
+	reg.SetProvider(fake)
+
+	out, card := reg.Run(context.Background(), "greet", "Bob")
+	assert.Equal(t, "Hello, Bob!", out)
+	require.NotNil(t, card)
+	require.Len(t, fake.requests, 1)
+	req := fake.requests[0]
+	assert.Equal(t, agents.DefaultModel, req.Model)
+	require.Len(t, req.Messages, 1)
+	assert.Equal(t, agents.RoleUser, req.Messages[0].Role)
+	assert.Equal(t, "Say hello to Bob.", req.Messages[0].Content)
+}
+
+func TestProvider_ToolCalls(t *testing.T) {
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "greet",
+		Description: "Generates a greeting message given a person's name",
+		Inputs: map[string]*agents.Argument{
+			"personName": {Description: "The name of the person to greet."},
+		},
+		Template: "Hello, {{ .Input }}!",
+	})
+	reg.RegisterAgent(&agents.Agent{
+		Name:      "tryme",
+		Prompt:    "Say hello to {{ .Input }}.",
+		Listeners: []string{"greet"},
+	})
+	fake := &fakeProvider{responses: []*agents.ChatResponse{
+		{ToolCalls: []agents.ToolCall{{ID: "call_1", Name: "greet", Arguments: "Alice"}}},
+		{Content: "personName: Alice"}, // input extraction for greet
+		{Content: "Hello, Alice!"},
+	}}
+	reg.SetProvider(fake)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "tryme", "Alice")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Hello, Alice!", res.Output)
+
+	require.Len(t, fake.requests, 3)
+	require.Len(t, fake.requests[0].Tools, 1)
+	assert.Equal(t, "greet", fake.requests[0].Tools[0].Name)
+	cont := fake.requests[2].Messages
+	require.Len(t, cont, 3)
+	assert.Equal(t, agents.RoleAssistant, cont[1].Role)
+	assert.Equal(t, agents.RoleTool, cont[2].Role)
+	assert.Equal(t, "call_1", cont[2].ToolCallID)
+	assert.Equal(t, "Hello, Alice!", cont[2].Content)
+}
+
+func TestProvider_FactExtraction(t *testing.T) {
+	agent := &agents.Agent{
+		Name: "printer",
+		Facts: map[string]*agents.Fact{
+			"sheet_size": {Name: "sheet_size", Type: "string", Description: "The size of the paper"},
+		},
+	}
+	reg := &Registry{Agents: map[string]*agents.Agent{"printer": agent}}
+	fake := &fakeProvider{responses: []*agents.ChatResponse{{Content: "sheet_size: 3x5"}}}
+	reg.SetProvider(fake)
+	chat := NewChat("printer")
+
+	NewRun(reg, chat).ExtractAgentMemory(context.Background(), agent, "small card", "3x5 it is")
+	assert.Equal(t, "3x5", chat.Facts["printer.sheet_size"])
+	assert.Len(t, fake.requests, 1)
+}
+
+func TestProvider_FunctionAgentContext(t *testing.T) {
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "embedder",
+		Description: "Embeds the text",
+		Inputs: map[string]*agents.Argument{
+			"text": {Description: "text to embed", Required: true},
+		},
+		Function: func(ctx context.Context, input map[string]any, agent *agents.Agent) (string, error) {
+			resp, err := agents.ProviderFrom(ctx).Embed(ctx, &agents.EmbedRequest{Input: []string{fmt.Sprint(input["text"])}})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d vectors", len(resp.Vectors)), nil
+		},
+	})
+	fake := &fakeProvider{responses: []*agents.ChatResponse{{Content: "text: hello"}}}
+	reg.SetProvider(fake)
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "embedder", "hello")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "1 vectors", res.Output)
+	require.Len(t, fake.embeds, 1)
+	assert.Equal(t, []string{"hello"}, fake.embeds[0].Input)
+}
+
+func TestProvider_ModelParams(t *testing.T) {
+	const spec = `
+defaults:
+  model: gpt-4o-mini
+  max_tokens: 100
+agents:
+  classify:
+    description: Cheap classifier
+    temperature: 0
+    seed: 7
+    stop: ["\n"]
+    inputs:
+      question:
+        description: The question to classify
+    prompt: "Classify {{ .Input \"question\" }}"
+  writer:
+    description: Creative writer
+    model: gpt-4o
+    temperature: 1.2
+    top_p: 0.9
+    prompt: "Write about {{ .Input }}"
+`
+	reg, err := NewRegistry(spec)
+	require.NoError(t, err)
+	fake := &fakeProvider{responses: []*agents.ChatResponse{
+		{Content: "question: is it on?"},
+		{Content: "yes"},
+		{Content: "A poem"},
+	}}
+	reg.SetProvider(fake)
+	ctx := context.Background()
+
+	out, _ := reg.Run(ctx, "classify", "is it on?")
+	assert.Equal(t, "yes", out)
+	out, _ = reg.Run(ctx, "writer", "the sea")
+	assert.Equal(t, "A poem", out)
+
+	require.Len(t, fake.requests, 3)
+	for i, req := range fake.requests[:2] {
+		assert.Equal(t, "gpt-4o-mini", req.Model, "request %d", i)
+		require.NotNil(t, req.Temperature)
+		assert.Equal(t, float32(0), *req.Temperature)
+		assert.Equal(t, 100, req.MaxTokens)
+		require.NotNil(t, req.Seed)
+		assert.Equal(t, 7, *req.Seed)
+		assert.Equal(t, []string{"\n"}, req.Stop)
+	}
+	assert.Equal(t, agents.PurposeInputs, fake.requests[0].Purpose)
+
+	writer := fake.requests[2]
+	assert.Equal(t, "gpt-4o", writer.Model)
+	assert.Equal(t, float32(1.2), *writer.Temperature)
+	assert.Equal(t, float32(0.9), *writer.TopP)
+	assert.Equal(t, 100, writer.MaxTokens)
+	assert.Nil(t, writer.Seed)
+}
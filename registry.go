@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -29,19 +32,197 @@ func (e *AgentNotFoundError) Error() string {
 	return fmt.Sprintf("could not find agent: %s", e.AgentName)
 }
 
-type RegistryCaller interface {
-	CallAI(ctx context.Context, agent *agents.Agent, prompt string, tmplCtx *TemplateContext) (string, error)
+type Registry struct {
+	Agents    map[string]*agents.Agent
+	Chat      *Chat
+	Provider  agents.Provider                   // nil uses agents.DefaultProvider
+	Providers map[string]agents.Provider        // named providers, built from Profiles on first use
+	Profiles  map[string]*agents.ProviderConfig // provider profiles from the spec
+	Defaults  agents.ModelParams                // spec wide model params
+	Recorder  *agents.Cassette                  // records every provider call when set
+	Cache     agents.ResponseCache              // answers agents with a cache policy; nil uses an in-memory cache
+	Prices    map[string]agents.Price           // model prices for cost estimates, over agents.DefaultPrices
+	Roles     map[string]string                 // named persona templates that agents select with role: name
+	ToolCalls agents.ToolCallConfig             // spec wide tool call limits
+
+	Embeddings agents.EmbeddingConfig // the embedding model used for search and memory
+	Embedding  agents.Embedder        // replaces the embedder built from Embeddings when set
+
+	Jobs *JobRunner // the background jobs started by job agents, created on first use
+
+	Libraries    map[string]*Registry // library specs whose agents are called as library.agent
+	libraryFiles map[string]string    // the file of each library spec
 }
 
-type Registry struct {
-	Agents map[string]*agents.Agent
-	Chat   *Chat
+// providersMu guards the lazy creation of named providers and the response cache.
+var providersMu sync.Mutex
+
+// ResponseCache returns the registry's response cache, creating an in-memory one on first use.
+func (r *Registry) ResponseCache() agents.ResponseCache {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if r.Cache == nil {
+		r.Cache = agents.NewMemoryCache()
+	}
+	return r.Cache
+}
+
+// SetProvider replaces the AI provider used by agents that do not name a provider.
+func (r *Registry) SetProvider(p agents.Provider) {
+	r.Provider = p
+}
+
+// UseProvider answers every AI call in the registry with p,
+// including agents that name a provider. Used for mocks and tests.
+func (r *Registry) UseProvider(p agents.Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	r.Provider = p
+	r.Providers = map[string]agents.Provider{}
+	for name := range r.Profiles {
+		r.Providers[name] = p
+	}
+	r.Providers[agents.ProviderTypeOpenAI] = p
+}
+
+// RegisterProvider adds a named provider that agents select with provider: name.
+func (r *Registry) RegisterProvider(name string, p agents.Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if r.Providers == nil {
+		r.Providers = make(map[string]agents.Provider)
+	}
+	r.Providers[name] = p
+}
+
+// GetProvider returns the registry's provider or the default provider.
+func (r *Registry) GetProvider() agents.Provider {
+	if r == nil || r.Provider == nil {
+		return agents.DefaultProvider()
+	}
+	return r.Provider
+}
+
+// UseEmbedder embeds all text for the registry's agents with e, such as an agents.FakeEmbedder in tests.
+func (r *Registry) UseEmbedder(e agents.Embedder) {
+	r.Embedding = e
+}
+
+// Embedder returns the registry's embedder, which embeds with the provider and model of the embeddings section.
+func (r *Registry) Embedder() (agents.Embedder, error) {
+	if r.Embedding != nil {
+		return r.Embedding, nil
+	}
+	provider, err := r.ProviderFor(r.Embeddings.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return agents.NewEmbedder(provider, r.Embeddings), nil
+}
+
+// Record sends every provider call made by the registry to the cassette.
+// Replay a cassette by passing it to UseProvider.
+func (r *Registry) Record(c *agents.Cassette) {
+	r.Recorder = c
+}
+
+// ProviderFor returns the named provider, building it from its profile on first use.
+// An empty name returns the registry's provider.
+func (r *Registry) ProviderFor(name string) (agents.Provider, error) {
+	p, err := r.providerFor(name)
+	if err != nil || r.Recorder == nil {
+		return p, err
+	}
+	return r.Recorder.Wrap(p), nil
+}
+
+func (r *Registry) providerFor(name string) (agents.Provider, error) {
+	if name == "" {
+		return r.GetProvider(), nil
+	}
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if p, ok := r.Providers[name]; ok {
+		return p, nil
+	}
+	if name == agents.ProviderTypeOpenAI && r.Profiles[name] == nil {
+		return agents.DefaultProvider(), nil
+	}
+	profile := r.profile(name)
+	if profile == nil {
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+	p, err := agents.NewProvider(profile)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", name, err)
+	}
+	if r.Providers == nil {
+		r.Providers = make(map[string]agents.Provider)
+	}
+	r.Providers[name] = p
+	return p, nil
+}
+
+// modelParams resolves the agent's model params against its provider profile and the spec defaults.
+func (r *Registry) modelParams(agent *agents.Agent) agents.ModelParams {
+	params := agent.ModelParams
+	if params.Provider == "" {
+		params.Provider = r.Defaults.Provider
+	}
+	if params.Model == "" {
+		if profile := r.profile(params.Provider); profile != nil {
+			params.Model = profile.DefaultModel()
+		}
+	}
+	return params.WithDefaults(r.Defaults)
+}
+
+// fallbackParams resolves the agent's fallback models in order.
+// A fallback takes every setting it leaves out from the agent,
+// except that a fallback on another provider uses that provider's default model.
+func (r *Registry) fallbackParams(agent *agents.Agent) []agents.ModelParams {
+	primary := r.modelParams(agent)
+	chain := []agents.ModelParams{}
+	for _, fallback := range agent.Fallback {
+		params := fallback
+		if params.Provider == "" {
+			params.Provider = primary.Provider
+		}
+		if params.Model == "" && params.Provider != primary.Provider {
+			if profile := r.profile(params.Provider); profile != nil {
+				params.Model = profile.DefaultModel()
+			}
+		}
+		chain = append(chain, params.WithDefaults(primary))
+	}
+	return chain
+}
+
+// toolCallConfig resolves the agent's tool call limits against the spec and the defaults.
+func (r *Registry) toolCallConfig(agent *agents.Agent) agents.ToolCallConfig {
+	return agent.ToolCalls.WithDefaults(r.ToolCalls).WithDefaults(agents.ToolCallConfig{
+		MaxDepth:      agents.DefaultToolCallDepth,
+		MaxConcurrent: agents.DefaultToolCallConcurrent,
+	})
+}
+
+// profile returns the named provider profile.
+// The anthropic provider needs no profile; it reads ANTHROPIC_API_KEY.
+func (r *Registry) profile(name string) *agents.ProviderConfig {
+	if profile, ok := r.Profiles[name]; ok {
+		return profile
+	}
+	if name == agents.ProviderTypeAnthropic {
+		return &agents.ProviderConfig{Type: agents.ProviderTypeAnthropic}
+	}
+	return nil
 }
 
 type Libraries map[string]Registry
 
 var libraries Libraries = map[string]Registry{
-	"rag": {Agents: rag.Agents},
+	"rag":  {Agents: rag.Agents},
+	"jobs": {Agents: jobsAgents},
 }
 
 // LookupAgent resolves both unqualified and qualified agent names
@@ -56,9 +237,9 @@ func (r *Registry) LookupAgent(name string) (*agents.Agent, error) {
 
 	parts := strings.SplitN(name, ".", 2)
 	pkgName, agentName := parts[0], parts[1]
-	pkg, ok := libraries[pkgName]
-	if !ok {
-		return nil, &AgentNotFoundError{AgentName: name}
+	pkg, err := r.Library(pkgName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", &AgentNotFoundError{AgentName: name}, err)
 	}
 	agent, ok := pkg.Agents[agentName]
 	if !ok {
@@ -80,11 +261,24 @@ type TraceCard struct {
 	Prompt      string
 	Error       error
 	Ran         bool
-	PriorCard   *TraceCard
+	Role        string           // the rendered role, sent as the system message of the agent's prompt
+	History     []agents.Message // earlier chat turns sent before the agent's prompt
+	PriorCard   *TraceCard       `json:"-"`
 	BranchCards []*TraceCard
 	Logs        []*LogMessage
-	Facts       map[string]any // facts set by this agent
-	LocalFacts  map[string]any // local facts set by this agent
+	Facts       map[string]any      // facts set by this agent
+	LocalFacts  map[string]any      // local facts set by this agent
+	Cached      bool                // an AI call of this agent was answered from the response cache
+	Model       string              // the model that answered the agent's latest AI call
+	Fallbacks   int                 // AI calls of this agent answered by a fallback model
+	Usage       agents.Usage        // tokens and cost of this agent's own AI calls
+	Attachments []agents.Attachment // references to the run's attachments, when they were sent with the agent's prompt
+	Sample      int                 // the number of this sample of the prior card's prompt; zero when not a sample
+	Agreement   float64             // the share of the agent's samples that agree with its answer
+	JobID       string              // the job started by this agent, or the job this card traces
+	AliasOf     string              // the agent this alias ran under its own settings
+	streaming   bool                // the agent's prompt response is streamed to RunContext.Stream
+	attach      bool                // the run's attachments are sent with the agent's prompt
 }
 
 func (c *TraceCard) String() string {
@@ -111,6 +305,16 @@ func (c *TraceCard) String() string {
 	if len(prompt) > 0 {
 		prompt = fmt.Sprintf("Prompt: %q\n", prompt)
 	}
+	if len(c.History) > 0 {
+		history := "History:\n"
+		for _, msg := range c.History {
+			history += fmt.Sprintf("  %s: %q\n", msg.Role, msg.Content)
+		}
+		prompt = history + prompt
+	}
+	if len(c.Role) > 0 {
+		prompt = fmt.Sprintf("Role: %q\n", c.Role) + prompt
+	}
 	if len(facts) == 0 {
 		facts = "none"
 	} else {
@@ -122,8 +326,38 @@ func (c *TraceCard) String() string {
 		inputs = fmt.Sprintf("%q", inputs)
 	}
 
+	if c.Cached {
+		ranstr += " (cached)"
+	}
+
 	results := fmt.Sprintf("Agent: %s\nInput: \"%s\"\nOutput: \"%s\"\n%s%s\n%s\nInputs: %s\nFacts: %s\nLocalFacts: %s",
-		c.AgentName, c.Input, c.Output, prompt, ranstr, errstr, inputs, facts, locals)
+		c.title(), c.Input, c.Output, prompt, ranstr, errstr, inputs, facts, locals)
+
+	if len(c.Attachments) > 0 {
+		results += "\nAttachments:"
+		for _, a := range c.Attachments {
+			results += fmt.Sprintf("\n  %s", a)
+		}
+	}
+
+	if c.Model != "" {
+		results += fmt.Sprintf("\nModel: %s", c.Model)
+		if c.Fallbacks > 0 {
+			results += fmt.Sprintf(" (%d calls fell back)", c.Fallbacks)
+		}
+	}
+	if c.Sample > 0 {
+		results += fmt.Sprintf("\nSample: %d", c.Sample)
+	}
+	if n := c.samples(); n > 0 {
+		results += fmt.Sprintf("\nAgreement: %.0f%% of %d samples", 100*c.Agreement, n)
+	}
+	if c.JobID != "" {
+		results += fmt.Sprintf("\nJob: %s", c.JobID)
+	}
+	if c.Usage.Calls > 0 {
+		results += fmt.Sprintf("\nUsage: %s", c.Usage)
+	}
 
 	if len(c.Logs) == 0 {
 		results += "\nno logs"
@@ -136,6 +370,37 @@ func (c *TraceCard) String() string {
 	return results
 }
 
+// title names the card's agent, and the agent it ran when it is an alias.
+func (c *TraceCard) title() string {
+	if c.AliasOf != "" {
+		return fmt.Sprintf("%s (alias of %s)", c.AgentName, c.AliasOf)
+	}
+	return c.AgentName
+}
+
+// samples counts the branch cards that are samples of the card's prompt.
+func (c *TraceCard) samples() int {
+	n := 0
+	for _, card := range c.BranchCards {
+		if card.Sample > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// TotalUsage adds the usage of the card and all of its branch cards.
+func (c *TraceCard) TotalUsage() agents.Usage {
+	if c == nil {
+		return agents.Usage{}
+	}
+	total := c.Usage
+	for _, card := range c.BranchCards {
+		total = total.Add(card.TotalUsage())
+	}
+	return total
+}
+
 func (c *TraceCard) ShortString() string {
 	errstr := "no error"
 	if c.Error != nil {
@@ -146,7 +411,7 @@ func (c *TraceCard) ShortString() string {
 		prior = c.PriorCard.AgentName
 	}
 	return fmt.Sprintf("Agent: %s\nFrom: %s\nInput: \"%s\"\nOutput: \"%s\"\n%s",
-		c.AgentName, prior, c.Input, c.Output, errstr)
+		c.title(), prior, c.Input, c.Output, errstr)
 }
 
 func (r *RunContext) NewTraceCard(agent, input string) *TraceCard {
@@ -187,6 +452,9 @@ func (c *TraceCard) WriteMarkdown(w io.Writer, short ...bool) {
 		return
 	}
 	fmt.Fprintf(w, "# Agent Trace: %s\n", c.AgentName)
+	if total := c.TotalUsage(); total.Calls > 0 {
+		fmt.Fprintf(w, "\nTotal usage: %s\n", total)
+	}
 
 	c.WriteMarkdownLevel(w, 1, 1)
 }
@@ -200,7 +468,7 @@ func (c *TraceCard) WriteMarkdownLevel(w io.Writer, index, level int) {
 	if level > 1 {
 		from = fmt.Sprintf(" From: %s\n", c.PriorCard.AgentName)
 	}
-	fmt.Fprintf(w, "\n## %d.%d: %s%s\n", level, index, c.AgentName, from)
+	fmt.Fprintf(w, "\n## %d.%d: %s%s\n", level, index, c.title(), from)
 
 	fmt.Fprintf(w, "\n```%s\n```\n", c.String()) // TODO: add from, level, index
 
@@ -228,6 +496,9 @@ func (r *RunContext) Logf(format string, args ...any) {
 	if r.IsPrint {
 		log.Printf(format, args...)
 	}
+	if r.Card == nil {
+		return // memory extraction after a run has no card
+	}
 	r.Card.Logs = append(r.Card.Logs, &LogMessage{
 		Message:   fmt.Sprintf(format, args...),
 		Timestamp: time.Now(),
@@ -238,9 +509,34 @@ type RunContext struct {
 	IsPrint    bool
 	Chat       *Chat
 	Registry   *Registry
-	Card       *TraceCard     // prompt used for this run
-	Depth      int            // current depth of nested CallAgent invocations
-	LocalFacts map[string]any // All facts stored locally during this run
+	Card       *TraceCard         // prompt used for this run
+	Depth      int                // current depth of nested CallAgent invocations
+	LocalFacts map[string]any     // All facts stored locally during this run
+	Stream     func(delta string) // receives the top-level prompt agent's response as it is generated
+
+	Attachments []agents.Attachment // images and files sent with the run's input
+
+	JobID   string    // the job this run belongs to, or the job a job agent is starting
+	library string    // the library of the running agent, whose agents it calls by their own names
+	owner   *Registry // the registry that defines the running agent, when it is not the run's registry
+}
+
+// fork returns a run for a branch of the current card that runs alongside other branches.
+// It shares the chat and registry, keeps its own copy of the local facts and does not stream.
+func (r *RunContext) fork() *RunContext {
+	return &RunContext{
+		IsPrint:    r.IsPrint,
+		Chat:       r.Chat,
+		Registry:   r.Registry,
+		Card:       r.Card,
+		Depth:      r.Depth,
+		LocalFacts: maps.Clone(r.LocalFacts),
+
+		Attachments: r.Attachments,
+		JobID:       r.JobID,
+		library:     r.library,
+		owner:       r.owner,
+	}
 }
 
 func NewRun(reg *Registry, chat *Chat) *RunContext {
@@ -260,7 +556,22 @@ func (r *Registry) RegisterAgent(agent *agents.Agent) {
 
 // Run is the main entrypoint for calling an agent
 func (r *Registry) Run(ctx context.Context, name string, input string) (string, *TraceCard) {
+	return r.RunStream(ctx, name, input, nil)
+}
+
+// RunStream is Run with the top-level prompt agent's response sent to stream as it is generated.
+// The returned output and trace card hold the complete response.
+func (r *Registry) RunStream(ctx context.Context, name string, input string, stream func(delta string)) (string, *TraceCard) {
+	return r.RunAttached(ctx, name, input, nil, stream)
+}
+
+// RunAttached is RunStream with images and files attached to the input.
+// They are sent with the top-level agent's prompt, and templates list them with .Attachments.
+// A nil stream does not stream.
+func (r *Registry) RunAttached(ctx context.Context, name string, input string, attachments []agents.Attachment, stream func(delta string)) (string, *TraceCard) {
 	run := NewRun(r, defaultChat)
+	run.Stream = stream
+	run.Attachments = attachments
 	res := run.CallAgent(ctx, name, input)
 	if res.Error != nil {
 		// logs.Error("[AGENT ERROR]", res.Error)
@@ -281,6 +592,7 @@ func (r *Registry) Run(ctx context.Context, name string, input string) (string,
 			run.ExtractAgentMemory(ctx, agent, input, out)
 		}
 		defaultChat.Cards = append(defaultChat.Cards, run.Card)
+		defaultChat.AddTurn(name, input, out)
 	}
 	return out, run.Card
 }
@@ -289,7 +601,15 @@ func (r *Registry) Run(ctx context.Context, name string, input string) (string,
 func (r *Registry) RunPrint(ctx context.Context, name string, input string) error {
 	run := NewRun(r, defaultChat)
 	run.IsPrint = true
+	streamed := false
+	run.Stream = func(delta string) {
+		streamed = true
+		fmt.Print(delta)
+	}
 	res := run.CallAgent(ctx, name, input)
+	if streamed {
+		fmt.Println()
+	}
 	if res.Error != nil {
 		return fmt.Errorf("[AGENT ERROR] %v", res.Error)
 	}
@@ -301,8 +621,13 @@ func (r *Registry) RunPrint(ctx context.Context, name string, input string) erro
 	if !utf8.ValidString(out) {
 		out = strings.ToValidUTF8(out, "�")
 	}
-	fmt.Println(out)
+	if !streamed {
+		fmt.Println(out)
+	}
 	card := run.Card
+	if total := card.TotalUsage(); total.Calls > 0 {
+		fmt.Fprintf(os.Stderr, "[USAGE] %s\n", total)
+	}
 	if card != nil {
 		card.SaveMarkdown("trace.md", !IsVerbose())
 	}
@@ -319,32 +644,46 @@ func (r *RunContext) CallAgent(ctx context.Context, name string, input string) A
 			AgentName: name,
 		}
 	}
-	r.Depth++
-	defer func() { r.Depth-- }()
-
 	card := r.NewTraceCard(name, input)
+	card.streaming = r.Stream != nil && r.Card == nil
+	if len(r.Attachments) > 0 && r.Card == nil {
+		card.attach = true
+		for _, a := range r.Attachments {
+			card.Attachments = append(card.Attachments, a.Reference())
+		}
+	}
 	if r.Card != nil {
 		r.Card.BranchCards = append(r.Card.BranchCards, card)
 	}
+	return r.callAgent(ctx, card, name, input)
+}
+
+// callAgent runs the named agent on its trace card, which is already a branch of the run's card.
+func (r *RunContext) callAgent(ctx context.Context, card *TraceCard, name string, input string) AgentResult {
+	r.Depth++
+	defer func() { r.Depth-- }()
+
 	r.Card = card
-	agent, err := r.Registry.LookupAgent(name)
+	name = r.resolve(name)
+	card.AgentName = name
+	agent, target, err := r.Registry.lookupAlias(name)
 	if err != nil {
 		return AgentResult{Ran: false, Error: err, AgentName: name}
 	}
-	if agent.Alias != "" {
-		return r.CallAgent(ctx, agent.Alias, input)
+	card.AliasOf = target
+	outerLibrary, outerOwner := r.library, r.owner
+	r.library = libraryOf(name)
+	if target != "" {
+		r.library = libraryOf(target) // the target calls its own library's agents
 	}
+	r.owner = r.Registry.owner(r.library)
+	defer func() { r.library, r.owner = outerLibrary, outerOwner }()
 
 	var result AgentResult
-	switch {
-	case agent.Function != nil:
-		result = r.execFunctionAgent(ctx, agent, input, name)
-	case agent.Template != "":
-		result = r.execTemplateAgent(ctx, agent, input, name)
-	case agent.Prompt != "":
-		result = r.execPromptAgent(ctx, agent, input, name)
-	default:
-		return AgentResult{Ran: false, Error: errors.New("invalid agent: no prompt, template, alias, or function"), AgentName: name}
+	if len(agent.Job) > 0 {
+		result = r.execJobAgent(ctx, agent, input, name)
+	} else {
+		result = r.execAgent(ctx, agent, input, name)
 	}
 	r.Card.Output = result.Output
 	r.Card.Ran = result.Ran
@@ -355,76 +694,58 @@ func (r *RunContext) CallAgent(ctx context.Context, name string, input string) A
 	return result
 }
 
-func (r *RunContext) extractAgentValues(ctx context.Context, agent *agents.Agent, prompt string) (string, error) {
-	resp, err := r.CallAI(ctx, agent, prompt)
-	if err != nil {
-		return "", err
-	}
-	resp = strings.TrimSpace(resp)
-
-	if strings.HasPrefix(resp, "ERROR:") {
-		return "", fmt.Errorf("AI error: %s", resp)
-	}
-	if strings.Contains(resp, "```") {
-		start := strings.Index(resp, "```")
-		end := strings.LastIndex(resp, "```")
-		if start != -1 && end > start {
-			resp = resp[start+3 : end]
-			resp = strings.TrimSpace(resp)
-		}
-	}
-	if strings.HasPrefix(resp, "yaml\n") {
-		resp = strings.TrimPrefix(resp, "yaml\n")
-		resp = strings.TrimSpace(resp)
+// execAgent runs a function, template or prompt agent.
+func (r *RunContext) execAgent(ctx context.Context, agent *agents.Agent, input string, name string) AgentResult {
+	switch {
+	case agent.Function != nil:
+		return r.execFunctionAgent(ctx, agent, input, name)
+	case agent.Template != "":
+		return r.execTemplateAgent(ctx, agent, input, name)
+	case agent.Prompt != "":
+		return r.execPromptAgent(ctx, agent, input, name)
 	}
-	return resp, nil
+	return AgentResult{Ran: false, Error: errors.New("invalid agent: no prompt, template, alias, or function"), AgentName: name}
 }
 
-// parseAgentInputs parses YAML input into a map and checks if all required fields are present.
-// the input is yaml provided by AI
-func (r *RunContext) parseAgentInputs(agent *agents.Agent, input string) (map[string]any, error) {
-	inputMap := make(map[string]any)
-	if err := yaml.Unmarshal([]byte(input), &inputMap); err != nil {
-		r.Errorf("cannot read function input as yaml: %w", err)
-	}
+// errModelReported marks an extraction the model itself answered with an ERROR.
+// Only those are asked again; provider failures are retried by the provider.
+var errModelReported = errors.New("AI error")
+
+// checkAgentInputs checks that all required inputs are present.
+func (r *RunContext) checkAgentInputs(agent *agents.Agent, inputMap map[string]any) error {
 	missing := []string{}
-	for k, arg := range agent.Inputs {
-		if arg.Required {
-			if _, ok := inputMap[k]; !ok {
+	for _, k := range slices.Sorted(maps.Keys(agent.Inputs)) {
+		if agent.Inputs[k].Required {
+			if v, ok := inputMap[k]; !ok || v == nil {
 				missing = append(missing, k)
 			}
 		}
 	}
 	if len(missing) > 0 {
-		return nil, fmt.Errorf("required inputs missing in agent: %s - %q", agent.Name, missing)
+		return fmt.Errorf("required inputs missing in agent: %s - %q", agent.Name, missing)
 	}
-	return inputMap, nil
+	return nil
 }
 
-// parseAgentInputs parses YAML input into a map and checks if all required fields are present.
-// the input is yaml provided by AI
-func (r *RunContext) parseAgentFacts(agent *agents.Agent, input string) (map[string]any, map[string]any, error) {
-	factMap := make(map[string]any)
-	if err := yaml.Unmarshal([]byte(input), &factMap); err != nil {
-		r.Errorf("cannot read function input as yaml: %w", err)
-	}
+// splitAgentFacts separates the local facts from the global ones.
+func (r *RunContext) splitAgentFacts(agent *agents.Agent, factMap map[string]any) (map[string]any, map[string]any) {
 	localMap := make(map[string]any)
 	missing := []string{}
-	for k, arg := range agent.Facts {
+	for _, k := range slices.Sorted(maps.Keys(agent.Facts)) {
+		arg := agent.Facts[k]
 		if _, ok := factMap[k]; !ok {
 			missing = append(missing, k)
 			continue
 		}
 		if arg.Scope == "local" {
-			localMap[arg.Name] = factMap[arg.Name]
-			delete(factMap, arg.Name)
+			localMap[k] = factMap[k]
+			delete(factMap, k)
 		}
 	}
 	if len(missing) > 0 {
 		r.Errorf("required facts missing in agent: %s - %q", agent.Name, missing)
-		// return nil, nil, fmt.Errorf("required inputs missing in agent: %s - %q", agent.Name, missing)
 	}
-	return factMap, localMap, nil
+	return factMap, localMap
 }
 
 func (r *RunContext) handleAgentInputs(ctx context.Context, agent *agents.Agent, input string) (map[string]any, error) {
@@ -432,7 +753,8 @@ func (r *RunContext) handleAgentInputs(ctx context.Context, agent *agents.Agent,
 		return nil, nil
 	}
 	promptDesc := "Fill out the following YAML fields based on the input. Each value is described and includes a type hint.\n\nInput:\n" + input + "\n\nFields:\n"
-	for k, arg := range agent.Inputs {
+	for _, k := range slices.Sorted(maps.Keys(agent.Inputs)) {
+		arg := agent.Inputs[k]
 		required := "optional"
 		if arg.Required {
 			required = "required"
@@ -457,19 +779,19 @@ greeting: Hello!
 note: Have a nice day.
 `
 
-	resp, err := r.extractAgentValues(ctx, agent, promptDesc)
-	if err != nil {
+	ex := inputsExtraction(agent)
+	inputMap, err := r.extractValues(ctx, agent, agents.PurposeInputs, promptDesc, ex)
+	if errors.Is(err, errModelReported) {
 		// Retry once with clarification request
 		promptDesc += "\nIf there was an error understanding the request, explain the issue clearly in your YAML response."
-		resp, err = r.extractAgentValues(ctx, agent, promptDesc)
-		if err != nil {
-			return nil, err
-		}
+		inputMap, err = r.extractValues(ctx, agent, agents.PurposeInputs, promptDesc, ex)
 	}
-	inputMap, err := r.parseAgentInputs(agent, resp)
 	if err != nil {
 		return nil, err
 	}
+	if err := r.checkAgentInputs(agent, inputMap); err != nil {
+		return nil, err
+	}
 	if len(inputMap) == 0 && agent.Function != nil {
 		inputMap = make(map[string]any)
 		if err := yaml.Unmarshal([]byte(input), &inputMap); err != nil {
@@ -486,9 +808,17 @@ func (r *RunContext) handleAgentFacts(ctx context.Context, agent *agents.Agent,
 	}
 	chat := r.Chat
 	facts := make(map[string]any)
+	for k, arg := range agent.Facts {
+		if val, ok := r.LocalFacts[k]; ok && arg.Scope == "local" {
+			facts[k] = val
+		}
+	}
 	if chat != nil {
-		for k := range agent.Facts {
-			val, ok := chat.Facts[k]
+		for k, arg := range agent.Facts {
+			if arg.Scope == "local" {
+				continue
+			}
+			val, ok := chat.lookupFact(k)
 			if !ok {
 				continue
 			}
@@ -496,7 +826,8 @@ func (r *RunContext) handleAgentFacts(ctx context.Context, agent *agents.Agent,
 		}
 	}
 	promptDesc := "Fill out the following YAML fields based on the input. Each value is described and includes a type hint.\n\nInput:\n" + input + "\n\nFields:\n"
-	for k, arg := range agent.Facts {
+	for _, k := range slices.Sorted(maps.Keys(agent.Facts)) {
+		arg := agent.Facts[k]
 		scope := "global"
 		if arg.Scope == "local" {
 			scope = "local"
@@ -527,22 +858,25 @@ greeting: Hello!
 note: Have a nice day.
 `
 
-	resp, err := r.extractAgentValues(ctx, agent, promptDesc)
-	if err != nil {
+	ex := factsExtraction(agent)
+	extracted, err := r.extractValues(ctx, agent, agents.PurposeFacts, promptDesc, ex)
+	if errors.Is(err, errModelReported) {
 		// Retry once with clarification request
 		promptDesc += "\nIf there was an error understanding the request, explain the issue clearly in your YAML response."
-		resp, err = r.extractAgentValues(ctx, agent, promptDesc)
-		if err != nil {
-			return err
-		}
+		extracted, err = r.extractValues(ctx, agent, agents.PurposeFacts, promptDesc, ex)
+	}
+	var unreadable *unreadableAnswerError
+	if errors.As(err, &unreadable) {
+		r.Errorf("%v", err) // facts are best effort; the agent's output stands
+		return nil
 	}
-	factMap, localMap, err := r.parseAgentFacts(agent, resp)
 	if err != nil {
 		return err
 	}
+	factMap, localMap := r.splitAgentFacts(agent, extracted)
 	for k, v := range factMap {
 		if r.Chat != nil {
-			r.Chat.Facts[k] = v
+			r.Chat.setFact(k, v)
 		}
 		r.Card.Facts[k] = v
 	}
@@ -561,6 +895,16 @@ func (r *RunContext) execFunctionAgent(ctx context.Context, agent *agents.Agent,
 	if inputMap == nil {
 		return AgentResult{Ran: false, Output: "", AgentName: name, Error: errors.New("Function agent requires inputs")}
 	}
+	provider, err := r.Registry.ProviderFor(r.settings().modelParams(agent).Provider)
+	if err != nil {
+		return AgentResult{Ran: false, Error: err, AgentName: name}
+	}
+	embedder, err := r.Registry.Embedder()
+	if err != nil {
+		return AgentResult{Ran: false, Error: err, AgentName: name}
+	}
+	ctx = agents.WithEmbedder(agents.WithProvider(ctx, provider), embedder)
+	ctx = withJobRunner(ctx, r.Registry.JobRunner())
 	resp, err := agent.Function(ctx, inputMap, agent)
 	if err != nil {
 		return AgentResult{Ran: true, Error: err, AgentName: name}
@@ -597,7 +941,12 @@ func (r *RunContext) execPromptAgent(ctx context.Context, agent *agents.Agent, i
 		return AgentResult{Ran: false, Output: "", AgentName: name}
 	}
 	r.Card.Prompt = finalPrompt
-	resp, err := r.CallAI(ctx, agent, finalPrompt)
+	var resp string
+	if agent.Samples > 1 {
+		resp, err = r.sampleAI(ctx, agent, finalPrompt)
+	} else {
+		resp, err = r.CallAI(ctx, agent, finalPrompt)
+	}
 	if err != nil {
 		return AgentResult{Ran: true, Error: err, AgentName: name}
 	}
@@ -615,14 +964,48 @@ func (r *RunContext) renderFinalPrompt(ctx context.Context, template string, age
 	if err != nil {
 		return "", err
 	}
+	tc := NewTemplateContext(ctx, agent, input, r, inputMap)
+	if agent.Role != "" {
+		role, err := r.renderRole(tc, agent.Role)
+		if err != nil {
+			return "", err
+		}
+		r.Card.Role = role
+	}
 	tmpl, err := utils.TemplateParse(agent.Name, template)
 	if err != nil {
 		return "", fmt.Errorf("template parse error: %w", err)
 	}
 	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, NewTemplateContext(ctx, agent, input, r, inputMap))
+	err = tmpl.Execute(&buf, tc)
 	if err != nil {
 		return "", fmt.Errorf("template exec error: %w", err)
 	}
 	return strings.TrimSpace(buf.String()), nil
 }
+
+// RoleText returns the template of a role: the named role from the roles section,
+// or the role as written when no role has that name.
+func (r *Registry) RoleText(role string) string {
+	if text, ok := r.Roles[role]; ok {
+		return text
+	}
+	return role
+}
+
+// renderRole renders the role template with the agent's template context.
+func (r *RunContext) renderRole(tc *TemplateContext, role string) (string, error) {
+	name := "role"
+	if tc.Agent != nil {
+		name = tc.Agent.Name + ".role"
+	}
+	tmpl, err := utils.TemplateParse(name, r.settings().RoleText(role))
+	if err != nil {
+		return "", fmt.Errorf("role template parse error: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, tc); err != nil {
+		return "", fmt.Errorf("role template exec error: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
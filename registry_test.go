@@ -6,7 +6,6 @@ import (
 	"os"
 	"testing"
 
-	"github.com/joho/godotenv"
 	"github.com/robbyriverside/agencia/agents"
 	"github.com/stretchr/testify/assert"
 )
@@ -16,11 +15,6 @@ const loopCount = 10
 
 func TestFunctionAgentWithInputs(t *testing.T) {
 	ctx := context.Background()
-	_ = godotenv.Load()
-
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Fatal("OPENAI_API_KEY must be set (either in environment or .env file)")
-	}
 
 	// Agent with InputPrompt including required and optional fields
 	agent := &agents.Agent{
@@ -52,6 +46,15 @@ func TestFunctionAgentWithInputs(t *testing.T) {
 			"test_func": agent,
 		},
 	}
+	// Offline, replay what the model does: it echoes the given fields and
+	// fills a missing required field from its description.
+	useMockWithoutAPI(t, reg, `
+patterns:
+  - match: "Input:\\nb: optional"
+    inputs: "a: Required field A\nb: optional"
+  - match: "Input:\\na: hello"
+    inputs: "a: hello"
+`)
 
 	t.Run("missing required value", func(t *testing.T) {
 		for i := 0; i < loopCount; i++ {
@@ -0,0 +1,180 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyProvider fails its first failures calls with err, then answers "ok".
+type flakyProvider struct {
+	failures int
+	err      error
+	delay    time.Duration
+
+	calls    atomic.Int32
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (p *flakyProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	n := p.calls.Add(1)
+	now := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if now <= peak || p.peak.CompareAndSwap(peak, now) {
+			break
+		}
+	}
+	time.Sleep(p.delay)
+	if int(n) <= p.failures {
+		return nil, p.err
+	}
+	return &agents.ChatResponse{Content: "ok"}, nil
+}
+
+func (p *flakyProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestResilient_RetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"message": "Rate limit reached", "type": "rate_limit_error"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id": "chatcmpl-1", "object": "chat.completion", "model": "gpt-4o",
+  "choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Hello Bob"}}]}`)
+	}))
+	defer server.Close()
+	t.Setenv("LOCAL_KEY", "secret")
+
+	spec := fmt.Sprintf(`
+providers:
+  local:
+    base_url: %s/v1
+    api_key_env: LOCAL_KEY
+    retry:
+      max_attempts: 2
+      initial_backoff: 10ms
+agents:
+  greet:
+    description: Greet the user
+    provider: local
+    prompt: "Say hello to {{ .Input }}."
+`, server.URL)
+	reg, err := NewRegistry(spec)
+	require.NoError(t, err)
+
+	start := time.Now()
+	out, card := reg.Run(context.Background(), "greet", "Bob")
+	assert.Equal(t, "Hello Bob", out)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "the Retry-After header sets the wait")
+	assert.EqualValues(t, 2, calls.Load())
+
+	require.Len(t, card.Logs, 2)
+	assert.Contains(t, card.Logs[0].Message, "attempt 1/2 with gpt-4o failed")
+	assert.Contains(t, card.Logs[0].Message, "status code: 429")
+	assert.Contains(t, card.Logs[0].Message, "retrying in 1s")
+	assert.Contains(t, card.Logs[1].Message, "attempt 2/2 with gpt-4o succeeded")
+}
+
+func TestResilient_RetryAfterTooLong(t *testing.T) {
+	limited := &agents.ProviderError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour, Err: fmt.Errorf("rate limited")}
+	flaky := &flakyProvider{failures: 1, err: limited}
+	p := agents.NewResilientProvider(flaky, &agents.RetryConfig{InitialBackoff: time.Millisecond, MaxBackoff: time.Second})
+
+	start := time.Now()
+	_, err := p.Chat(context.Background(), &agents.ChatRequest{})
+	assert.ErrorIs(t, err, limited, "the provider's error is returned")
+	assert.Less(t, time.Since(start), time.Second, "a Retry-After past max_backoff is not waited for")
+	assert.EqualValues(t, 1, flaky.calls.Load())
+}
+
+func TestResilient_NotRetryable(t *testing.T) {
+	flaky := &flakyProvider{failures: 1, err: &agents.ProviderError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("bad request")}}
+	p := agents.NewResilientProvider(flaky, &agents.RetryConfig{InitialBackoff: time.Millisecond})
+
+	_, err := p.Chat(context.Background(), &agents.ChatRequest{})
+	require.Error(t, err)
+	assert.EqualValues(t, 1, flaky.calls.Load(), "client errors are not retried")
+}
+
+func TestResilient_CircuitBreaker(t *testing.T) {
+	flaky := &flakyProvider{failures: 10, err: &agents.ProviderError{StatusCode: http.StatusServiceUnavailable, Err: fmt.Errorf("unavailable")}}
+	p := agents.NewResilientProvider(flaky, &agents.RetryConfig{
+		MaxAttempts:      1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := p.Chat(ctx, &agents.ChatRequest{})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, agents.ErrCircuitOpen)
+	}
+	_, err := p.Chat(ctx, &agents.ChatRequest{})
+	assert.ErrorIs(t, err, agents.ErrCircuitOpen)
+	assert.EqualValues(t, 2, flaky.calls.Load(), "an open circuit does not call the provider")
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = p.Chat(ctx, &agents.ChatRequest{})
+	assert.NotErrorIs(t, err, agents.ErrCircuitOpen, "the cooldown lets a call through")
+	assert.EqualValues(t, 3, flaky.calls.Load())
+}
+
+func TestResilient_MaxConcurrent(t *testing.T) {
+	flaky := &flakyProvider{delay: 20 * time.Millisecond}
+	p := agents.NewResilientProvider(flaky, &agents.RetryConfig{MaxConcurrent: 2})
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := p.Chat(context.Background(), &agents.ChatRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, "ok", resp.Content)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 6, flaky.calls.Load())
+	assert.EqualValues(t, 2, flaky.peak.Load())
+}
+
+func TestLintSpecFile_Retry(t *testing.T) {
+	spec := `
+providers:
+  local:
+    base_url: http://localhost:11434/v1
+    retry:
+      max_attempts: three
+      max_backoff: 20 seconds
+      jitter: true
+agents:
+  greet:
+    description: Greet the user
+    provider: local
+    prompt: "Say hello to {{ .Input }}."
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 6: Provider 'local' retry setting 'max_attempts' must be a positive integer, got 'three'.")
+	assert.Contains(t, result.Errors, "Problem: Line 7: Provider 'local' retry setting 'max_backoff' must be a duration like 500ms or 30s, got '20 seconds'.")
+	assert.Contains(t, result.Errors, "Problem: Line 8: Provider 'local' has unknown retry setting 'jitter'.")
+}
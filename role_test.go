@@ -0,0 +1,86 @@
+package agencia
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRole_SystemMessage(t *testing.T) {
+	reg, err := NewRegistry(`
+roles:
+  nurse: |
+    You are a calm triage nurse at {{ .Input "clinic" }}.
+agents:
+  triage:
+    description: Triage the caller
+    role: nurse
+    inputs:
+      clinic:
+        description: The clinic the caller is calling
+    prompt: "{{ .Role }} Triage this call: {{ .Input }}"
+  plain:
+    description: Answer directly
+    role: You answer in one word.
+    prompt: "{{ .Input }}"
+`)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{"clinic: Mercy Clinic", "Please hold.", "Yes."}}
+	reg.UseProvider(provider)
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "triage", "I fell at Mercy Clinic")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Please hold.", res.Output)
+	assert.Equal(t, "You are a calm triage nurse at Mercy Clinic.", run.Card.Role)
+	assert.Contains(t, run.Card.String(), `Role: "You are a calm triage nurse at Mercy Clinic."`)
+
+	require.Len(t, provider.requests, 2)
+	assert.Len(t, provider.requests[0].Messages, 1, "input extraction is not sent the role")
+	assert.Equal(t, []agents.Message{
+		{Role: agents.RoleSystem, Content: "You are a calm triage nurse at Mercy Clinic."},
+		{Role: agents.RoleUser, Content: "You are a calm triage nurse at Mercy Clinic. Triage this call: I fell at Mercy Clinic"},
+	}, provider.requests[1].Messages)
+
+	out, _ := reg.Run(context.Background(), "plain", "Is water wet?")
+	assert.Equal(t, "Yes.", out)
+	assert.Equal(t, agents.Message{Role: agents.RoleSystem, Content: "You answer in one word."}, provider.requests[2].Messages[0],
+		"a role that is not in roles is used as written")
+}
+
+func TestRole_AnthropicSystem(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	replay := []string{"01_tool_use.json", "02_extract_inputs.json", "03_end_turn.json"}
+	server := newAnthropicServer(t, http.StatusOK, func(n int, _ map[string]any) string {
+		return replay[n-1]
+	})
+	reg := anthropicRegistry(t, server)
+	reg.Roles = map[string]string{"host": "You are a warm host."}
+	reg.Agents["tryme"].Role = "host"
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "tryme", "Alice")
+	require.NoError(t, res.Error)
+	require.Len(t, server.requests, 3)
+	assert.Equal(t, "You are a warm host.", server.requests[0]["system"])
+	assert.Nil(t, server.requests[1]["system"], "the listener's input extraction has no role")
+	assert.Equal(t, "You are a warm host.", server.requests[2]["system"], "the tool continuation keeps the role")
+}
+
+func TestLintSpecFile_Roles(t *testing.T) {
+	spec := `
+roles:
+  nurse: "You work with {{ .Get \"doctor\" }}."
+agents:
+  triage:
+    description: Triage the caller
+    role: nures
+    prompt: "Triage this call: {{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.Contains(t, result.Errors, "Problem: Line 3: Role 'nurse' references undefined agent 'doctor' via .Get. Please ensure all referenced agents exist.")
+	assert.Contains(t, result.Warnings, "Reminder: Line 7: Agent 'triage' has role 'nures', which is not defined in roles. It will be sent to the model as written.")
+}
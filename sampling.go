@@ -0,0 +1,133 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/robbyriverside/agencia/agents"
+)
+
+// sampleAI sends the prompt once per sample, concurrently and each with its own seed,
+// and chooses the answer with the agent's vote.
+// Every sample has a branch card; the agent's card records how many samples agree with the answer.
+func (r *RunContext) sampleAI(ctx context.Context, agent *agents.Agent, prompt string) (string, error) {
+	n := agent.Samples
+	base := 0
+	if seed := r.settings().modelParams(agent).Seed; seed != nil {
+		base = *seed
+	}
+	parent := r.Card
+	answers := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		card := r.NewTraceCard(agent.Name, parent.Input)
+		card.Sample = i + 1
+		card.Role = parent.Role
+		card.attach = parent.attach
+		parent.BranchCards = append(parent.BranchCards, card)
+
+		sample := *agent
+		seed := base + i
+		sample.Seed = &seed
+		sample.Samples = 0
+		fork := r.fork()
+		fork.Card = card
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			card.Prompt = prompt
+			answers[i], errs[i] = fork.CallAI(ctx, &sample, prompt)
+			card.Output, card.Error, card.Ran = answers[i], errs[i], true
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+
+	policy := agent.Vote
+	if policy == nil {
+		policy = &agents.VotePolicy{Strategy: agents.VoteMajority}
+	}
+	answer, err := r.vote(ctx, policy, prompt, answers)
+	if err != nil {
+		return "", err
+	}
+	agree := 0
+	for _, a := range answers {
+		if normalizeAnswer(a) == normalizeAnswer(answer) {
+			agree++
+		}
+	}
+	parent.Agreement = float64(agree) / float64(n)
+	r.Logf("[VOTE] %s chose %q by %s: %d of %d samples agree", agent.Name, answer, policy, agree, n)
+	if r.Stream != nil && parent.streaming {
+		r.Stream(answer)
+	}
+	return answer, nil
+}
+
+// vote chooses one of the answers.
+func (r *RunContext) vote(ctx context.Context, policy *agents.VotePolicy, prompt string, answers []string) (string, error) {
+	switch policy.Strategy {
+	case agents.VoteUnanimous:
+		for _, a := range answers[1:] {
+			if normalizeAnswer(a) != normalizeAnswer(answers[0]) {
+				return "", fmt.Errorf("the %d samples do not agree: %q and %q", len(answers), answers[0], a)
+			}
+		}
+		return answers[0], nil
+	case agents.VoteJudge:
+		return r.judge(ctx, policy.Agent, prompt, answers)
+	}
+	counts := map[string]int{}
+	best := answers[0]
+	for _, a := range answers {
+		key := normalizeAnswer(a)
+		counts[key]++
+		if counts[key] > counts[normalizeAnswer(best)] {
+			best = a
+		}
+	}
+	return best, nil
+}
+
+// judge asks the agent to choose among the numbered answers.
+// The judge may reply with the number of an answer or with the answer itself.
+func (r *RunContext) judge(ctx context.Context, name, prompt string, answers []string) (string, error) {
+	var input strings.Builder
+	fmt.Fprintf(&input, "Prompt:\n%s\n\nAnswers:\n", prompt)
+	for i, a := range answers {
+		fmt.Fprintf(&input, "%d. %s\n", i+1, a)
+	}
+	input.WriteString("\nReply with the number of the best answer.")
+	res := r.CallAgent(ctx, name, input.String())
+	if res.Error != nil {
+		return "", fmt.Errorf("judge %s: %w", name, res.Error)
+	}
+	choice := strings.TrimSpace(res.Output)
+	if n, err := strconv.Atoi(strings.TrimRight(choice, ".")); err == nil && n >= 1 && n <= len(answers) {
+		return answers[n-1], nil
+	}
+	for _, a := range answers {
+		if normalizeAnswer(a) == normalizeAnswer(choice) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("judge %s did not choose one of the %d answers: %q", name, len(answers), choice)
+}
+
+// normalizeAnswer makes answers that differ only in case, spacing, quotes or final punctuation equal.
+func normalizeAnswer(answer string) string {
+	answer = strings.Join(strings.Fields(strings.ToLower(answer)), " ")
+	return strings.TrimFunc(answer, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	})
+}
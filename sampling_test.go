@@ -0,0 +1,161 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const samplingSpec = `
+agents:
+  support_flow:
+    description: Decide on a ticket
+    template: 'Decision: {{ .Get "triage_decision" }} Confidence: {{ .Agreement "triage_decision" }}'
+  triage_decision:
+    description: Decide whether to escalate
+    samples: 5
+    temperature: 0.8
+    prompt: |
+      Should we escalate this to a human agent? {{ .Input }}
+      Respond with "Please escalate" or "No escalation required".
+  careful_decision:
+    description: Decide only when every sample agrees
+    samples: 3
+    vote: unanimous
+    prompt: "Should we escalate? {{ .Input }}"
+  judged_decision:
+    description: Let a senior agent choose
+    samples: 3
+    vote: judge:senior
+    prompt: "Should we escalate? {{ .Input }}"
+  senior:
+    description: Choose the best answer
+    prompt: "{{ .Input }}"
+`
+
+// seedProvider answers each seed with its answer, and other agents by name.
+type seedProvider struct {
+	seeds    []string
+	agents   map[string]string
+	mu       sync.Mutex
+	requests []*agents.ChatRequest
+}
+
+func (p *seedProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+	if answer, ok := p.agents[req.Agent]; ok {
+		return &agents.ChatResponse{Content: answer}, nil
+	}
+	if req.Seed == nil || *req.Seed >= len(p.seeds) {
+		return nil, fmt.Errorf("no answer for seed %v", req.Seed)
+	}
+	return &agents.ChatResponse{Content: p.seeds[*req.Seed]}, nil
+}
+
+func (p *seedProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestSampling_Majority(t *testing.T) {
+	reg, err := NewRegistry(samplingSpec)
+	require.NoError(t, err)
+	provider := &seedProvider{seeds: []string{
+		"Please escalate.", "No escalation required", "please  escalate", "No escalation required.", "Please escalate",
+	}}
+	reg.UseProvider(provider)
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "support_flow", "The customer was charged twice.")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Decision: Please escalate. Confidence: 0.6", res.Output)
+
+	card := run.Card.BranchCards[0]
+	assert.Equal(t, "triage_decision", card.AgentName)
+	assert.Equal(t, 0.6, card.Agreement)
+	require.Len(t, card.BranchCards, 5)
+	for i, sample := range card.BranchCards {
+		assert.Equal(t, i+1, sample.Sample)
+		assert.Equal(t, provider.seeds[i], sample.Output)
+		assert.Equal(t, card.Prompt, sample.Prompt)
+	}
+	seeds := map[int]bool{}
+	for _, req := range provider.requests {
+		seeds[*req.Seed] = true
+		assert.Equal(t, float32(0.8), *req.Temperature)
+	}
+	assert.Len(t, seeds, 5, "every sample has its own seed")
+	assert.Contains(t, card.String(), "Agreement: 60% of 5 samples")
+	assert.Contains(t, card.BranchCards[1].String(), "Sample: 2")
+	assert.Equal(t, `[VOTE] triage_decision chose "Please escalate." by majority: 3 of 5 samples agree`, card.Logs[0].Message)
+}
+
+func TestSampling_Unanimous(t *testing.T) {
+	reg, err := NewRegistry(samplingSpec)
+	require.NoError(t, err)
+	reg.UseProvider(&seedProvider{seeds: []string{"Please escalate", "PLEASE ESCALATE!", "Please escalate"}})
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "careful_decision", "Charged twice.")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Please escalate", res.Output)
+	assert.Equal(t, 1.0, run.Card.Agreement)
+
+	reg.UseProvider(&seedProvider{seeds: []string{"Please escalate", "No escalation required", "Please escalate"}})
+	res = NewRun(reg, nil).CallAgent(context.Background(), "careful_decision", "Charged twice.")
+	require.Error(t, res.Error)
+	assert.Equal(t, `the 3 samples do not agree: "Please escalate" and "No escalation required"`, res.Error.Error())
+}
+
+func TestSampling_Judge(t *testing.T) {
+	reg, err := NewRegistry(samplingSpec)
+	require.NoError(t, err)
+	provider := &seedProvider{
+		seeds:  []string{"Please escalate", "No escalation required", "Please escalate"},
+		agents: map[string]string{"senior": "2"},
+	}
+	reg.UseProvider(provider)
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "judged_decision", "Charged twice.")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "No escalation required", res.Output)
+	assert.InDelta(t, 1.0/3, run.Card.Agreement, 1e-9)
+
+	judge := run.Card.BranchCards[3]
+	assert.Equal(t, "senior", judge.AgentName)
+	assert.True(t, strings.HasPrefix(judge.Input, "Prompt:\nShould we escalate? Charged twice.\n\nAnswers:\n1. Please escalate\n2. No escalation required\n3. Please escalate\n"))
+}
+
+func TestLintSpecFile_Samples(t *testing.T) {
+	spec := `
+agents:
+  decide:
+    description: Decide
+    samples: many
+    vote: loudest
+    prompt: "{{ .Input }}"
+  judged:
+    description: Decide with a judge
+    samples: 3
+    vote: judge:nobody
+    prompt: "{{ .Input }}"
+  plain:
+    description: Vote without samples
+    vote: majority
+    template: "{{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 5: Agent 'decide' samples must be a positive integer, got 'many'.")
+	assert.Contains(t, result.Errors, "Problem: Line 6: Agent 'decide' vote must be majority, unanimous or judge:<agent>, got 'loudest'.")
+	assert.Contains(t, result.Errors, "Problem: Line 11: Agent 'judged' vote is judged by undefined agent 'nobody'. Please ensure all referenced agents exist.")
+	assert.Contains(t, result.Warnings, "Reminder: Line 15: Agent 'plain' has a vote but no samples, so it is never used.")
+}
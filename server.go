@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/robbyriverside/agencia/agents"
 	"github.com/robbyriverside/agencia/logs"
 )
 
@@ -17,15 +18,17 @@ import (
 var website embed.FS
 
 type runRequest struct {
-	Spec  string `json:"spec"`
-	Input string `json:"input"`
-	Agent string `json:"agent"`
+	Spec        string              `json:"spec"`
+	Input       string              `json:"input"`
+	Agent       string              `json:"agent"`
+	Attachments []agents.Attachment `json:"attachments,omitempty"` // images and files sent with the input
 }
 
 type runResponse struct {
-	Output string     `json:"output"`
-	Error  string     `json:"error,omitempty"`
-	Card   *TraceCard `json:"card"`
+	Output string       `json:"output"`
+	Error  string       `json:"error,omitempty"`
+	Card   *TraceCard   `json:"card"`
+	Usage  agents.Usage `json:"usage"` // tokens and cost of the whole run
 }
 
 func Server(ctx context.Context, url string) {
@@ -68,8 +71,21 @@ func handleRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for _, a := range req.Attachments {
+		if err := a.Validate(); err != nil {
+			logs.Error("[RUN ERROR] Invalid attachment: %v", err)
+			http.Error(w, fmt.Sprintf("Invalid attachment: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	ctx := context.Background()
 
+	if err := checkRemoteSpec([]byte(req.Spec)); err != nil {
+		logs.Error("[RUN ERROR] %v", err)
+		http.Error(w, fmt.Sprintf("Invalid SPEC: %v", err), http.StatusBadRequest)
+		return
+	}
 	res := LintSpecFile([]byte(req.Spec))
 	if !res.Valid {
 		logs.Error("[RUN ERROR] Invalid request body: %v", err)
@@ -83,8 +99,8 @@ func handleRun(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "[RUN ERROR]", http.StatusBadRequest)
 		return
 	}
-	resp, card := registry.Run(ctx, req.Agent, req.Input)
+	resp, card := registry.RunAttached(ctx, req.Agent, req.Input, req.Attachments, nil)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(runResponse{Output: resp, Card: card})
+	json.NewEncoder(w).Encode(runResponse{Output: resp, Card: card, Usage: card.TotalUsage()})
 }
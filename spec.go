@@ -0,0 +1,276 @@
+package agencia
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/robbyriverside/agencia/agents"
+	"gopkg.in/yaml.v3"
+)
+
+// namedSections are merged name by name when a spec has several documents or includes other files.
+// The other sections are taken from the last document that sets them.
+var namedSections = map[string]bool{
+	"agents":    true,
+	"libraries": true,
+	"providers": true,
+	"prices":    true,
+	"roles":     true,
+}
+
+// specMerger reads the documents of a spec and the files they include into one mapping.
+//
+//	include:
+//	  - billing.yaml
+//	  - support/*.yaml
+type specMerger struct {
+	root      *yaml.Node            // the merged mapping
+	sections  map[string]*yaml.Node // the value of each section in root
+	agents    map[string]string     // where each agent is defined
+	included  map[string]bool       // absolute paths of the files read
+	conflicts []string
+}
+
+// mergeSpec reads every yaml document of the source, and the files its documents include, into one mapping.
+// File names the source in messages, and included paths are relative to its directory; it is empty
+// for a spec that was not read from a file, whose includes are relative to the working directory.
+// An agent defined more than once is a conflict, reported with the file and line of both definitions.
+func mergeSpec(source []byte, file string) (*yaml.Node, []string, error) {
+	m := &specMerger{
+		root:     &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1},
+		sections: map[string]*yaml.Node{},
+		agents:   map[string]string{},
+		included: map[string]bool{},
+	}
+	if file != "" {
+		if abs, err := filepath.Abs(file); err == nil {
+			m.included[abs] = true
+		}
+	}
+	if err := m.merge(source, file); err != nil {
+		return nil, nil, err
+	}
+	return m.root, m.conflicts, nil
+}
+
+func (m *specMerger) merge(source []byte, file string) error {
+	dec := yaml.NewDecoder(bytes.NewReader(source))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			if file != "" {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			return err
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		body := doc.Content[0]
+		if body.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s: a spec document must be a mapping of sections", location(file, body.Line))
+		}
+		if err := m.mergeDocument(body, file); err != nil {
+			return err
+		}
+	}
+}
+
+// mergeDocument merges the files a document includes and then the document itself,
+// so its own sections override those of the files it includes.
+func (m *specMerger) mergeDocument(body *yaml.Node, file string) error {
+	for i := 0; i < len(body.Content)-1; i += 2 {
+		if body.Content[i].Value == "include" {
+			if err := m.include(body.Content[i+1], file); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i < len(body.Content)-1; i += 2 {
+		key, val := body.Content[i], body.Content[i+1]
+		switch {
+		case key.Value == "include":
+		case namedSections[key.Value] && val.Kind == yaml.MappingNode:
+			m.mergeSection(key, val, file)
+		default:
+			m.setSection(key, val)
+		}
+	}
+	return nil
+}
+
+// include merges the files matched by each path or glob, relative to the including file.
+func (m *specMerger) include(node *yaml.Node, file string) error {
+	patterns := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		patterns = node.Content
+	}
+	dir := filepath.Dir(file)
+	for _, pattern := range patterns {
+		path := pattern.Value
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include %q: %w", location(file, pattern.Line), pattern.Value, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: include %q matches no files", location(file, pattern.Line), pattern.Value)
+		}
+		for _, match := range matches {
+			abs, err := filepath.Abs(match)
+			if err != nil {
+				return err
+			}
+			if m.included[abs] {
+				continue // already merged, or including itself
+			}
+			m.included[abs] = true
+			data, err := os.ReadFile(match)
+			if err != nil {
+				return fmt.Errorf("%s: cannot read include: %w", location(file, pattern.Line), err)
+			}
+			if err := m.merge(data, match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeSection adds the entries of a named section.  A later entry replaces an earlier one,
+// except for agents, which conflict.
+func (m *specMerger) mergeSection(key, val *yaml.Node, file string) {
+	section, ok := m.sections[key.Value]
+	if !ok {
+		section = &yaml.Node{Kind: yaml.MappingNode, Tag: val.Tag, Line: val.Line, Column: val.Column}
+		m.setSection(key, section)
+	}
+	for i := 0; i < len(val.Content)-1; i += 2 {
+		name, entry := val.Content[i], val.Content[i+1]
+		if key.Value == "agents" {
+			at := location(file, name.Line)
+			if prev, ok := m.agents[name.Value]; ok {
+				m.conflicts = append(m.conflicts, fmt.Sprintf("Problem: Duplicate agent name '%s' found at %s (previously defined at %s). Agent names must be unique.", name.Value, at, prev))
+				continue
+			}
+			m.agents[name.Value] = at
+		} else if key.Value == "libraries" && entry.Kind == yaml.ScalarNode && !filepath.IsAbs(entry.Value) {
+			path := *entry // the path of a library spec is relative to the file that names it
+			path.Value = filepath.Join(filepath.Dir(file), entry.Value)
+			entry = &path
+		}
+		if j := mappingIndex(section, name.Value); j >= 0 {
+			section.Content[j+1] = entry
+			continue
+		}
+		section.Content = append(section.Content, name, entry)
+	}
+}
+
+// setSection sets the value of a section, replacing any earlier one.
+func (m *specMerger) setSection(key, val *yaml.Node) {
+	if namedSections[key.Value] && val.Kind == yaml.MappingNode {
+		m.sections[key.Value] = val
+	}
+	if j := mappingIndex(m.root, key.Value); j >= 0 {
+		m.root.Content[j+1] = val
+		return
+	}
+	m.root.Content = append(m.root.Content, key, val)
+}
+
+// mappingIndex returns the index of the key in a mapping node, or -1.
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i < len(mapping.Content)-1; i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkRemoteSpec refuses a spec sent to the server that would use the server's disk or secrets: one
+// whose documents, or the inline libraries they name, include other files, name the file of a library,
+// cache responses on disk, or point a provider at another server, key or headers.
+func checkRemoteSpec(source []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(source))
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			return nil // the linter reports the spec's syntax errors
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if err := checkRemoteBody(doc.Content[0]); err != nil {
+			return err
+		}
+	}
+}
+
+// checkRemoteBody checks the sections of one spec document, and of each inline library it names.
+func checkRemoteBody(body *yaml.Node) error {
+	if body.Kind != yaml.MappingNode {
+		return nil // the linter reports a document that is not a mapping
+	}
+	if mappingIndex(body, "include") >= 0 {
+		return errRemoteFiles
+	}
+	if i := mappingIndex(body, "cache"); i >= 0 {
+		cache := body.Content[i+1]
+		if j := mappingIndex(cache, "backend"); j >= 0 && cache.Content[j+1].Value != agents.CacheBackendMemory {
+			return errRemoteCache
+		}
+		if mappingIndex(cache, "dir") >= 0 {
+			return errRemoteCache
+		}
+	}
+	if i := mappingIndex(body, "providers"); i >= 0 {
+		profiles := body.Content[i+1]
+		for j := 1; j < len(profiles.Content); j += 2 {
+			for _, key := range []string{"base_url", "api_key_env", "headers"} {
+				if mappingIndex(profiles.Content[j], key) >= 0 {
+					return errRemoteProvider // the server's keys would go where the client chose
+				}
+			}
+		}
+	}
+	if i := mappingIndex(body, "libraries"); i >= 0 {
+		libs := body.Content[i+1]
+		for j := 1; j < len(libs.Content); j += 2 {
+			lib := libs.Content[j]
+			if lib.Kind == yaml.ScalarNode {
+				return errRemoteFiles // the path of a library spec
+			}
+			if err := checkRemoteBody(lib); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	errRemoteFiles = errors.New("include and library paths are not allowed in specs sent to the server")
+	errRemoteCache = errors.New("only the memory cache is allowed in specs sent to the server")
+
+	errRemoteProvider = errors.New("providers in specs sent to the server cannot set base_url, api_key_env or headers")
+)
+
+// location names a line of a spec: file:line, or line N for a spec that was not read from a file.
+func location(file string, line int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
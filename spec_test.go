@@ -0,0 +1,125 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRegistry_Include(t *testing.T) {
+	reg, err := LoadRegistry("testdata/include/main.yaml")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"mainmenu", "refund", "reset_password", "contact"}, slices.Collect(maps.Keys(reg.Agents)))
+	assert.Equal(t, "gpt-4o", reg.Defaults.Model, "the including file overrides the files it includes")
+	assert.Equal(t, map[string]string{"clerk": "You are a billing clerk.", "helper": "You are a support agent."}, reg.Roles)
+
+	out, _ := reg.Run(context.Background(), "mainmenu", "help")
+	assert.Equal(t, "Refunds take five days.\nUse the reset link on the login page.", out)
+}
+
+func TestNewRegistry_Documents(t *testing.T) {
+	reg, err := NewRegistry(`
+agents:
+  hello:
+    description: Say hello
+    template: Hello {{ .Input }}
+---
+agents:
+  intro:
+    description: Introduce the service
+    template: '{{ .Get "hello" }}, welcome!'
+`)
+	require.NoError(t, err)
+	res := NewRun(reg, nil).CallAgent(context.Background(), "intro", "Ann")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Hello Ann, welcome!", res.Output)
+}
+
+func TestLintSpecFile_DuplicateAcrossFiles(t *testing.T) {
+	result := LintSpecFile([]byte(`
+include: testdata/include/features/*.yaml
+agents:
+  refund:
+    description: Refund the order
+    template: Refunded.
+---
+agents:
+  refund:
+    description: Refund the order again
+    template: Refunded again.
+`))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Duplicate agent name 'refund' found at line 4 (previously defined at testdata/include/features/billing.yaml:6). Agent names must be unique.")
+	assert.Contains(t, result.Errors, "Problem: Duplicate agent name 'refund' found at line 9 (previously defined at testdata/include/features/billing.yaml:6). Agent names must be unique.")
+
+	result = LintSpecFile([]byte(`
+include: testdata/include/nothing/*.yaml
+agents:
+  refund:
+    description: Refund the order
+    template: Refunded.
+`))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, `YAML parsing error: line 2: include "testdata/include/nothing/*.yaml" matches no files`)
+}
+
+func TestLintSpecPath(t *testing.T) {
+	source, err := os.ReadFile("testdata/include/main.yaml")
+	require.NoError(t, err)
+	result := LintSpecPath(source, "testdata/include/main.yaml")
+	assert.True(t, result.Valid, "includes resolve relative to the spec file: %s", result.Result())
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "features"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "features", "billing.yaml"), []byte(`agents:
+  refund:
+    description: Refund the order
+    template: Refunded.
+`), 0o644))
+	main := filepath.Join(dir, "main.yaml")
+	require.NoError(t, os.WriteFile(main, []byte(`include: features/*.yaml
+agents:
+  refund:
+    description: Refund the order again
+    template: Refunded again.
+`), 0o644))
+	source, err = os.ReadFile(main)
+	require.NoError(t, err)
+	result = LintSpecPath(source, main)
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, fmt.Sprintf("Problem: Duplicate agent name 'refund' found at %s:3 (previously defined at %s:2). Agent names must be unique.", main, filepath.Join(dir, "features", "billing.yaml")))
+
+	_, err = LoadRegistry(main)
+	require.Error(t, err, "LoadRegistry lints the spec file")
+	assert.Contains(t, err.Error(), "Duplicate agent name 'refund'")
+}
+
+func TestCheckRemoteSpec(t *testing.T) {
+	assert.Equal(t, errRemoteFiles, checkRemoteSpec([]byte("agents: {}\n---\ninclude: [billing.yaml]\n")))
+	assert.Equal(t, errRemoteFiles, checkRemoteSpec([]byte("libraries:\n  support: support.yaml\nagents: {}\n")))
+	assert.NoError(t, checkRemoteSpec([]byte("libraries:\n  support:\n    agents: {}\n")), "inline libraries read no files")
+	assert.NoError(t, checkRemoteSpec([]byte("agents:\n  include:\n    template: hi\n")))
+	assert.Equal(t, errRemoteCache, checkRemoteSpec([]byte("cache:\n  backend: disk\n  dir: /tmp/anywhere\nagents: {}\n")))
+	assert.Equal(t, errRemoteCache, checkRemoteSpec([]byte("cache:\n  dir: /tmp/anywhere\nagents: {}\n")))
+	assert.NoError(t, checkRemoteSpec([]byte("cache:\n  backend: memory\nagents: {}\n")))
+
+	assert.Equal(t, errRemoteFiles, checkRemoteSpec([]byte("libraries:\n  support:\n    include: [billing.yaml]\n    agents: {}\n")),
+		"inline libraries are checked like the spec")
+	assert.Equal(t, errRemoteFiles, checkRemoteSpec([]byte("libraries:\n  support:\n    libraries:\n      billing: billing.yaml\n    agents: {}\n")))
+	assert.Equal(t, errRemoteCache, checkRemoteSpec([]byte("libraries:\n  support:\n    cache:\n      dir: /tmp/anywhere\n    agents: {}\n")))
+	assert.NoError(t, checkRemoteSpec([]byte("libraries:\n  support:\n    libraries:\n      billing:\n        agents: {}\n    agents: {}\n")))
+
+	assert.Equal(t, errRemoteProvider, checkRemoteSpec([]byte("providers:\n  local:\n    base_url: http://example.com\nagents: {}\n")))
+	assert.Equal(t, errRemoteProvider, checkRemoteSpec([]byte("providers:\n  claude:\n    type: anthropic\n    api_key_env: HOME\nagents: {}\n")))
+	assert.Equal(t, errRemoteProvider, checkRemoteSpec([]byte("providers:\n  local:\n    headers:\n      X-Key: k\nagents: {}\n")))
+	assert.Equal(t, errRemoteProvider, checkRemoteSpec([]byte("libraries:\n  support:\n    providers:\n      local:\n        base_url: http://example.com\n    agents: {}\n")))
+	assert.NoError(t, checkRemoteSpec([]byte("providers:\n  claude:\n    type: anthropic\n    model: claude-haiku-4-5\nagents: {}\n")),
+		"a profile that only picks the model uses the vendor's own server")
+}
@@ -0,0 +1,161 @@
+package agencia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamChunk formats one chat completion chunk as a server-sent event.
+func streamChunk(delta string) string {
+	return fmt.Sprintf("data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":%s}]}\n\n", delta)
+}
+
+func TestStream_OpenAIToolCallDeltas(t *testing.T) {
+	var streamed []bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		stream, _ := body["stream"].(bool)
+		streamed = append(streamed, stream)
+		if !stream {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"chatcmpl-2","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"personName: Alice"}}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if body["tools"] != nil && len(body["messages"].([]any)) == 1 {
+			// The tool call arrives in pieces: id and name first, then the arguments.
+			fmt.Fprint(w, streamChunk(`{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"greet","arguments":""}}]}`))
+			fmt.Fprint(w, streamChunk(`{"tool_calls":[{"index":0,"function":{"arguments":"{\"person"}}]}`))
+			fmt.Fprint(w, streamChunk(`{"tool_calls":[{"index":0,"function":{"arguments":"Name\": \"Alice\"}"}}]}`))
+		} else {
+			fmt.Fprint(w, streamChunk(`{"role":"assistant","content":"Hello"}`))
+			fmt.Fprint(w, streamChunk(`{"content":", Alice"}`))
+			fmt.Fprint(w, streamChunk(`{"content":"!"}`))
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	reg := &Registry{
+		Defaults: agents.ModelParams{Provider: "local"},
+		Profiles: map[string]*agents.ProviderConfig{"local": {BaseURL: server.URL + "/v1"}},
+	}
+	reg.RegisterAgent(&agents.Agent{
+		Name:        "greet",
+		Description: "Generates a greeting message given a person's name",
+		Inputs: map[string]*agents.Argument{
+			"personName": {Description: "The name of the person to greet."},
+		},
+		Template: `Hello, {{ .Input "personName" }}!`,
+	})
+	reg.RegisterAgent(&agents.Agent{
+		Name:      "tryme",
+		Prompt:    "Say hello to {{ .Input }}.",
+		Listeners: []string{"greet"},
+	})
+
+	var deltas []string
+	out, card := reg.RunStream(context.Background(), "tryme", "Alice", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	assert.Equal(t, "Hello, Alice!", out)
+	assert.Equal(t, []string{"Hello", ", Alice", "!"}, deltas)
+	assert.Equal(t, "Hello, Alice!", card.Output)
+	require.Len(t, card.BranchCards, 1)
+	assert.Equal(t, "Hello, Alice!", card.BranchCards[0].Output, "the listener ran with the assembled arguments")
+	assert.Equal(t, []bool{true, false, true}, streamed, "only the prompt and its continuation stream")
+}
+
+func TestStream_AnthropicToolUse(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	server := newAnthropicServer(t, http.StatusOK, func(_ int, body map[string]any) string {
+		switch {
+		case body["stream"] != true:
+			return "02_extract_inputs.json"
+		case len(body["messages"].([]any)) == 1:
+			return "stream_tool_use.sse"
+		default:
+			return "stream_end_turn.sse"
+		}
+	})
+	reg := anthropicRegistry(t, server)
+
+	var deltas []string
+	out, card := reg.RunStream(context.Background(), "tryme", "Alice", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	assert.Equal(t, "The greeting agent says: Hello, Alice!", out)
+	assert.Equal(t, []string{"The greeting agent says: ", "Hello, Alice!"}, deltas)
+	assert.Equal(t, out, card.Output)
+
+	require.Len(t, server.requests, 3)
+	messages := server.requests[2]["messages"].([]any)
+	toolUse := messages[1].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "toolu_01T1x1fJ34qAmk2tNTrN7Up6", toolUse["id"])
+	assert.Equal(t, map[string]any{"personName": "Alice"}, toolUse["input"])
+}
+
+func TestStream_TopLevelOnly(t *testing.T) {
+	reg := &Registry{}
+	reg.RegisterAgent(&agents.Agent{Name: "inner", Prompt: "Say hi to {{ .Input }}."})
+	reg.RegisterAgent(&agents.Agent{Name: "outer", Template: `{{ .Get "inner" }} and welcome.`})
+	reg.RegisterAgent(&agents.Agent{Name: "renamed", Alias: "inner"})
+	mock, err := agents.NewMockProvider([]byte(`
+responses:
+  inner: "Hi {{ .Input }}"
+  renamed: "Hi {{ .Input }}"
+`))
+	require.NoError(t, err)
+	reg.SetProvider(mock)
+
+	var deltas []string
+	stream := func(delta string) { deltas = append(deltas, delta) }
+
+	out, _ := reg.RunStream(context.Background(), "outer", "Bob", stream)
+	assert.Equal(t, "Hi Bob and welcome.", out)
+	assert.Empty(t, deltas, "prompt agents called from a template are not streamed")
+
+	out, _ = reg.RunStream(context.Background(), "renamed", "Bob", stream)
+	assert.Equal(t, "Hi Bob", out)
+	assert.Equal(t, []string{"Hi Bob"}, deltas, "providers without streaming send the whole response at once")
+}
+
+func TestStream_ChatWebSocket(t *testing.T) {
+	mock, err := agents.NewMockProvider([]byte(`
+responses:
+  greet: "Hello {{ .Input }}"
+`))
+	require.NoError(t, err)
+	agents.SetDefaultProvider(mock)
+	defer agents.SetDefaultProvider(nil)
+	saved := defaultChat
+	defer func() { defaultChat = saved }()
+	defaultChat = nil
+
+	server := httptest.NewServer(http.HandlerFunc(ChatWebSocketHandler))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	spec := "agents:\n  greet:\n    description: Greet the user\n    prompt: \"Say hello to {{ .Input }}.\"\n"
+	require.NoError(t, conn.WriteJSON(map[string]any{"agent": "greet", "spec": spec, "stream": true}))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("Bob")))
+
+	var msg ChatStreamMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, ChatStreamMessage{Type: ChatStreamDelta, Text: "Hello Bob"}, msg)
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, ChatStreamMessage{Type: ChatStreamDone, Text: "Hello Bob"}, msg)
+}
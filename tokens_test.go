@@ -0,0 +1,177 @@
+package agencia
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 0, agents.EstimateTokens(""))
+	assert.Equal(t, 4, agents.EstimateTokens("Hello, world!"))
+	assert.Equal(t, 5, agents.EstimateTokens("internationalization"))
+	assert.Equal(t, 2, agents.EstimateTokens("12345"))
+	assert.Equal(t, 5, agents.EstimateTokens("こんにちは"))
+	assert.Equal(t, 3, agents.EstimateTokens("it's"+"\n"))
+
+	text := strings.Repeat("word ", 16)
+	assert.Equal(t, 17, agents.CountTokens("gpt-4o", text))
+	assert.Equal(t, 19, agents.CountTokens("claude-haiku-4-5", text), "claude splits text into more tokens")
+}
+
+func TestCountTokens_Encodings(t *testing.T) {
+	assert.Equal(t, 2, agents.CountTokens("gpt-4o-mini", "internationalization"), "openai models are counted with their encoding")
+	assert.Equal(t, 1, agents.CountTokens("gpt-4o", "こんにちは"))
+	assert.Equal(t, 2, agents.CountTokens("gpt-4-turbo", "internationalization"))
+	assert.Equal(t, 5, agents.CountTokens("llama3", "internationalization"), "unknown models are estimated")
+}
+
+func TestRegisterTokenizer(t *testing.T) {
+	agents.RegisterTokenizer("test-words", agents.TokenizerFunc(func(text string) int {
+		return len(strings.Fields(text))
+	}))
+	assert.Equal(t, 3, agents.CountTokens("test-words-2024", "internationalization of software"))
+	assert.Equal(t, 3+3+3, agents.CountMessageTokens("test-words", []agents.Message{{Role: agents.RoleUser, Content: "one two three"}}),
+		"each message and the reply add their overhead")
+
+	window, ok := agents.ContextWindow("gpt-4o-2024-08-06")
+	assert.True(t, ok)
+	assert.Equal(t, 128000, window)
+	_, ok = agents.ContextWindow("test-words")
+	assert.False(t, ok)
+}
+
+const overflowSpec = `
+agents:
+  tail:
+    description: Answer the start of a long question
+    max_input_tokens: 20
+    prompt: "{{ .Input }}"
+  head:
+    description: Answer the end of a long question
+    max_input_tokens: 20
+    overflow: truncate_head
+    prompt: "{{ .Input }}"
+  review:
+    description: Review a long document
+    max_input_tokens: 20
+    overflow: summarize:condense
+    prompt: "{{ .Input }}"
+  condense:
+    description: Summarize a document
+    prompt: "Summarize: {{ .Input }}"
+`
+
+func TestOverflow_Truncate(t *testing.T) {
+	reg, err := NewRegistry(overflowSpec)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{"ok", "ok"}}
+	reg.UseProvider(provider)
+	question := "start " + strings.Repeat("word ", 50) + "end"
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "tail", question)
+	require.NoError(t, res.Error)
+	sent := provider.requests[0].Messages
+	assert.Equal(t, "start"+strings.Repeat(" word", 13), sent[0].Content)
+	assert.LessOrEqual(t, agents.CountMessageTokens("gpt-4o", sent), 20)
+	assert.Equal(t, sent[0].Content, run.Card.Prompt, "the card shows the prompt that was sent")
+	assert.Equal(t, "[TOKENS] prompt of tail is 58 tokens, over its limit of 20: truncate_tail", run.Card.Logs[0].Message)
+
+	res = NewRun(reg, nil).CallAgent(context.Background(), "head", question)
+	require.NoError(t, res.Error)
+	assert.Equal(t, strings.Repeat(" word", 13)+" end", provider.requests[1].Messages[0].Content)
+}
+
+func TestOverflow_DropHistory(t *testing.T) {
+	chat := useDefaultChat(t, "support")
+	reg, err := chat.NewRegistry(`
+agents:
+  support:
+    description: Answer the customer
+    history: 5
+    max_input_tokens: 20
+    overflow: drop_history
+    prompt: "Customer: {{ .Input }}"
+`)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{"uno", "dos", "tres"}}
+	reg.UseProvider(provider)
+
+	reg.Run(context.Background(), "support", "one")
+	reg.Run(context.Background(), "support", "two")
+	_, card := reg.Run(context.Background(), "support", "three")
+	assert.Equal(t, []agents.Message{
+		{Role: agents.RoleUser, Content: "two"},
+		{Role: agents.RoleAssistant, Content: "dos"},
+		{Role: agents.RoleUser, Content: "Customer: three"},
+	}, provider.requests[2].Messages)
+	assert.Len(t, card.History, 2)
+	assert.Equal(t, "[TOKENS] 1 earlier turns dropped for support", card.Logs[1].Message)
+}
+
+func TestOverflow_Summarize(t *testing.T) {
+	reg, err := NewRegistry(overflowSpec)
+	require.NoError(t, err)
+	provider := &scriptProvider{answers: []string{"A short summary.", "Looks good."}}
+	reg.UseProvider(provider)
+	document := strings.Repeat("A long paragraph. ", 20)
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "review", document)
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Looks good.", res.Output)
+	assert.Equal(t, "condense", provider.requests[0].Agent)
+	assert.Equal(t, "Summarize: "+strings.TrimSpace(document), provider.requests[0].Messages[0].Content)
+	assert.Equal(t, "A short summary.", provider.requests[1].Messages[0].Content)
+	require.Len(t, run.Card.BranchCards, 1)
+	assert.Equal(t, "condense", run.Card.BranchCards[0].AgentName)
+}
+
+func TestOverflow_RoleTooLong(t *testing.T) {
+	reg, err := NewRegistry(`
+agents:
+  answer:
+    description: Answer the question
+    role: ` + strings.Repeat("Be kind. ", 20) + `
+    max_input_tokens: 20
+    prompt: "{{ .Input }}"
+`)
+	require.NoError(t, err)
+	reg.UseProvider(&scriptProvider{})
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "answer", "Why?")
+	require.Error(t, res.Error)
+	assert.Equal(t, "agent answer cannot fit its prompt into max_input_tokens 20: its role and history alone are over the limit", res.Error.Error())
+}
+
+func TestLintSpecFile_MaxInputTokens(t *testing.T) {
+	spec := `
+agents:
+  review:
+    description: Review a document
+    max_input_tokens: 0
+    overflow: squash
+    prompt: "{{ .Input }}"
+  brief:
+    description: Review a document briefly
+    max_input_tokens: 30
+    overflow: summarize:condense
+    prompt: "` + strings.Repeat("Please review carefully. ", 10) + `{{ .Input }}"
+  loose:
+    description: Review without a limit
+    overflow: drop_history
+    prompt: "{{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 5: Agent 'review' max_input_tokens must be a positive integer, got '0'.")
+	assert.Contains(t, result.Errors, "Problem: Line 6: Agent 'review' overflow must be truncate_tail, truncate_head, drop_history or summarize:<agent>, got 'squash'.")
+	assert.Contains(t, result.Errors, "Problem: Line 11: Agent 'brief' overflow summarizes with undefined agent 'condense'. Please ensure all referenced agents exist.")
+	assert.Contains(t, result.Warnings, "Reminder: Line 12: Agent 'brief' prompt is about 41 tokens before any input is rendered, near its limit of 30 tokens.")
+	assert.Contains(t, result.Warnings, "Reminder: Line 15: Agent 'loose' has an overflow strategy but no max_input_tokens, so it is never used.")
+}
@@ -0,0 +1,155 @@
+package agencia
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tripSpec = `
+tool_calls:
+  max_concurrent: 2
+agents:
+  plan:
+    description: Plan a trip
+    listeners: [weather]
+    prompt: "Plan a trip to {{ .Input }}"
+  weather:
+    description: The weather in a city
+    inputs:
+      city:
+        description: The city
+    template: 'Sunny in {{ .Input "city" }}'
+`
+
+// tripProvider asks for the weather in rounds of cities and then answers the prompt.
+// Input extractions take a moment so the calls of a round overlap.
+type tripProvider struct {
+	rounds   [][]string
+	mu       sync.Mutex
+	requests []*agents.ChatRequest
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (p *tripProvider) Chat(ctx context.Context, req *agents.ChatRequest) (*agents.ChatResponse, error) {
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+	if req.Purpose == agents.PurposeInputs {
+		n := p.inFlight.Add(1)
+		defer p.inFlight.Add(-1)
+		for peak := p.peak.Load(); n > peak && !p.peak.CompareAndSwap(peak, n); peak = p.peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		return &agents.ChatResponse{Content: req.Input}, nil // the tool arguments are the inputs
+	}
+	round := 0
+	for _, msg := range req.Messages {
+		if msg.Role == agents.RoleAssistant && len(msg.ToolCalls) > 0 {
+			round++
+		}
+	}
+	if round == len(p.rounds) {
+		return &agents.ChatResponse{Content: "Pack sunglasses."}, nil
+	}
+	resp := &agents.ChatResponse{}
+	for _, city := range p.rounds[round] {
+		resp.ToolCalls = append(resp.ToolCalls, agents.ToolCall{
+			ID:        "call_" + strings.ToLower(city),
+			Name:      "weather",
+			Arguments: fmt.Sprintf(`{"city": %q}`, city),
+		})
+	}
+	return resp, nil
+}
+
+func (p *tripProvider) Embed(ctx context.Context, req *agents.EmbedRequest) (*agents.EmbedResponse, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestToolCalls_ConcurrentRounds(t *testing.T) {
+	reg, err := NewRegistry(tripSpec)
+	require.NoError(t, err)
+	provider := &tripProvider{rounds: [][]string{{"Paris", "Rome", "Oslo"}, {"Lisbon"}}}
+	reg.UseProvider(provider)
+
+	run := NewRun(reg, nil)
+	res := run.CallAgent(context.Background(), "plan", "Europe")
+	require.NoError(t, res.Error)
+	assert.Equal(t, "Pack sunglasses.", res.Output)
+	assert.Equal(t, int32(2), provider.peak.Load(), "tool calls run concurrently up to max_concurrent")
+
+	outputs := []string{}
+	for _, card := range run.Card.BranchCards {
+		outputs = append(outputs, card.Output)
+		assert.Same(t, run.Card, card.PriorCard)
+		assert.Equal(t, 1, card.Usage.Calls, "each tool call counts its own input extraction")
+	}
+	assert.Equal(t, []string{"Sunny in Paris", "Sunny in Rome", "Sunny in Oslo", "Sunny in Lisbon"}, outputs,
+		"each tool call has its own card, in the order the model asked for them")
+
+	last := provider.requests[len(provider.requests)-1]
+	require.Equal(t, agents.PurposeTools, last.Purpose)
+	roles := []string{}
+	results := []string{}
+	for _, msg := range last.Messages {
+		roles = append(roles, msg.Role)
+		if msg.Role == agents.RoleTool {
+			results = append(results, msg.ToolCallID+": "+msg.Content)
+		}
+	}
+	assert.Equal(t, []string{"user", "assistant", "tool", "tool", "tool", "assistant", "tool"}, roles,
+		"the last continuation keeps every earlier round")
+	assert.Equal(t, []string{
+		"call_paris: Sunny in Paris",
+		"call_rome: Sunny in Rome",
+		"call_oslo: Sunny in Oslo",
+		"call_lisbon: Sunny in Lisbon",
+	}, results)
+}
+
+func TestToolCalls_MaxDepth(t *testing.T) {
+	spec := strings.Replace(tripSpec, "    listeners: [weather]\n", "    listeners: [weather]\n    tool_calls:\n      max_depth: 1\n", 1)
+	reg, err := NewRegistry(spec)
+	require.NoError(t, err)
+	reg.UseProvider(&tripProvider{rounds: [][]string{{"Paris"}, {"Rome"}}})
+
+	res := NewRun(reg, nil).CallAgent(context.Background(), "plan", "Europe")
+	require.Error(t, res.Error)
+	assert.Contains(t, res.Error.Error(), "too many recursive tool call levels (depth=2)")
+	assert.Contains(t, res.Error.Error(), `Depth 1: called tool weather with args {"city": "Paris"}`)
+}
+
+func TestToolCalls_Config(t *testing.T) {
+	reg := &Registry{ToolCalls: agents.ToolCallConfig{MaxConcurrent: 8}}
+	assert.Equal(t, agents.ToolCallConfig{MaxDepth: 3, MaxConcurrent: 8},
+		reg.toolCallConfig(&agents.Agent{ToolCalls: agents.ToolCallConfig{MaxDepth: 3}}))
+	assert.Equal(t, agents.ToolCallConfig{MaxDepth: agents.DefaultToolCallDepth, MaxConcurrent: agents.DefaultToolCallConcurrent},
+		(&Registry{}).toolCallConfig(&agents.Agent{}))
+}
+
+func TestLintSpecFile_ToolCalls(t *testing.T) {
+	spec := `
+tool_calls:
+  max_depth: 0
+agents:
+  plan:
+    description: Plan a trip
+    tool_calls:
+      parallel: 2
+    prompt: "Plan a trip to {{ .Input }}"
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, result.Errors, "Problem: Line 3: The spec tool_calls setting 'max_depth' must be a positive integer, got '0'.")
+	assert.Contains(t, result.Errors, "Problem: Line 8: Agent 'plan' has unknown tool_calls setting 'parallel'. Use max_depth and max_concurrent.")
+}
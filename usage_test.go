@@ -0,0 +1,141 @@
+package agencia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/robbyriverside/agencia/agents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsage_RollsUp(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+	replay := []string{"01_tool_use.json", "02_extract_inputs.json", "03_end_turn.json"}
+	server := newAnthropicServer(t, http.StatusOK, func(n int, _ map[string]any) string {
+		return replay[n-1]
+	})
+	reg := anthropicRegistry(t, server)
+	chat := NewChat("tryme")
+
+	run := NewRun(reg, chat)
+	res := run.CallAgent(context.Background(), "tryme", "Alice")
+	require.NoError(t, res.Error)
+
+	card := run.Card
+	assert.Equal(t, agents.Usage{Calls: 2, PromptTokens: 915, CompletionTokens: 85, TotalTokens: 1000},
+		withoutCost(card.Usage), "the prompt and its tool continuation")
+	require.Len(t, card.BranchCards, 1)
+	assert.Equal(t, agents.Usage{Calls: 1, PromptTokens: 188, CompletionTokens: 12, TotalTokens: 200},
+		withoutCost(card.BranchCards[0].Usage), "the listener's input extraction")
+
+	total := card.TotalUsage()
+	assert.Equal(t, agents.Usage{Calls: 3, PromptTokens: 1103, CompletionTokens: 97, TotalTokens: 1200}, withoutCost(total))
+	assert.InDelta(t, (1103*3.00+97*15.00)/1e6, total.Cost, 1e-9, "claude-sonnet-4-5-20250929 is priced as claude-sonnet-4-5")
+	assert.Equal(t, total, chat.Usage)
+
+	var buf bytes.Buffer
+	card.WriteMarkdown(&buf)
+	assert.Contains(t, buf.String(), "Total usage: 3 calls, 1200 tokens (1103 prompt, 97 completion), $0.0048")
+}
+
+func withoutCost(u agents.Usage) agents.Usage {
+	u.Cost = 0
+	return u
+}
+
+func TestUsage_RunJSON(t *testing.T) {
+	server, _, _ := chatCompletionServer(t, "Hello from llama")
+	provider, err := agents.NewProvider(&agents.ProviderConfig{BaseURL: server.URL + "/v1"})
+	require.NoError(t, err)
+	agents.SetDefaultProvider(provider) // specs sent to the server use the server's providers
+	defer agents.SetDefaultProvider(nil)
+	spec := `
+prices:
+  llama3:
+    input: 1
+    output: 2
+agents:
+  greet:
+    description: Greet the user
+    model: llama3
+    prompt: "Say hello to {{ .Input }}."
+  twice:
+    template: '{{ .Get "greet" }} {{ .Get "greet" }}'
+`
+	body, err := json.Marshal(runRequest{Spec: spec, Agent: "twice", Input: "Bob"})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp struct {
+		Output string       `json:"output"`
+		Usage  agents.Usage `json:"usage"`
+		Card   struct {
+			BranchCards []struct {
+				Usage agents.Usage
+			}
+		} `json:"card"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "Hello from llama Hello from llama", resp.Output)
+	assert.Equal(t, agents.Usage{Calls: 2, PromptTokens: 10, CompletionTokens: 4, TotalTokens: 14, Cost: 18e-6}, resp.Usage)
+	require.Len(t, resp.Card.BranchCards, 2, "nested cards encode without following PriorCard")
+	assert.Equal(t, 7, resp.Card.BranchCards[0].Usage.TotalTokens)
+}
+
+func TestUsage_CachedCallsAreFree(t *testing.T) {
+	reg, err := NewRegistry(fmt.Sprintf(cacheSpec, ""))
+	require.NoError(t, err)
+	reg.UseProvider(&flakyProvider{})
+
+	_, first := reg.Run(context.Background(), "classify", "Where am I?")
+	_, second := reg.Run(context.Background(), "classify", "Where am I?")
+	assert.Equal(t, 1, first.Usage.Calls)
+	assert.Zero(t, second.Usage.Calls)
+	assert.NotContains(t, second.String(), "Usage:")
+}
+
+func TestUsage_PriceFor(t *testing.T) {
+	custom := map[string]agents.Price{"gpt-4o": {Input: 1, Output: 1}, "llama3": {Input: 0.1, Output: 0.2}}
+	tests := []struct {
+		model string
+		price agents.Price
+		found bool
+	}{
+		{"gpt-4o", custom["gpt-4o"], true},
+		{"gpt-4o-2024-08-06", custom["gpt-4o"], true},
+		{"gpt-4o-mini-2024-07-18", agents.DefaultPrices["gpt-4o-mini"], true},
+		{"llama3", custom["llama3"], true},
+		{"mistral", agents.Price{}, false},
+	}
+	for _, test := range tests {
+		price, found := agents.PriceFor(custom, test.model)
+		assert.Equal(t, test.found, found, test.model)
+		assert.Equal(t, test.price, price, test.model)
+	}
+}
+
+func TestLintSpecFile_Prices(t *testing.T) {
+	spec := `
+prices:
+  llama3:
+    input: cheap
+    cached: 1
+agents:
+  greet:
+    prompt: "Say hello to {{ .Input }}."
+`
+	result := LintSpecFile([]byte(spec))
+	assert.False(t, result.Valid)
+	assert.Contains(t, strings.Join(result.Errors, "\n"), "Problem: Line 4: The input price of model 'llama3' must be a positive number of dollars per million tokens, got 'cheap'.")
+	assert.Contains(t, strings.Join(result.Errors, "\n"), "Problem: Line 5: Unknown price setting 'cached' for model 'llama3'. Use input and output.")
+}